@@ -0,0 +1,404 @@
+// Package backup takes periodic copies of the database into the backups
+// folder of the data folder, prunes them and restores one on demand.
+package backup
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"main/events"
+	"main/query"
+)
+
+// Kinds of backups, the prefix of their file name
+const (
+	// KindAuto backups are taken on schedule and pruned by the retention policy
+	KindAuto = "auto"
+	// KindManual backups are asked for through the API and never pruned
+	KindManual = "manual"
+	// KindPreMigration backups are taken before the schema is migrated
+	KindPreMigration = "pre-migration"
+	// KindPreRestore backups hold the database replaced by a restore
+	KindPreRestore = "pre-restore"
+)
+
+// confirmDelay is how long a restore can be confirmed once asked for
+const confirmDelay = 2 * time.Minute
+
+// Policy tells when backups are taken and which ones are kept
+type Policy struct {
+	// Interval is the delay between two automatic backups, 0 disables them
+	Interval time.Duration `json:"interval"`
+	// Daily, Weekly and Monthly are the number of days, weeks and months whose
+	// last automatic backup is kept
+	Daily   int `json:"daily"`
+	Weekly  int `json:"weekly"`
+	Monthly int `json:"monthly"`
+}
+
+// Info describes a backup file
+type Info struct {
+	Name      string    `json:"name"`
+	Kind      string    `json:"kind"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	// seq tells apart the backups of a same second, 1 for the first one
+	seq int
+}
+
+// stampLayout dates the backup files, followed by "-N" from the second
+// backup of a same second
+const stampLayout = "20060102-150405"
+
+// stampRe finds the date and the sequence number at the end of a file name
+var stampRe = regexp.MustCompile(`(\d{8}-\d{6})(?:-(\d+))?\.db$`)
+
+// parseStamp reads when a backup was taken from its file name: the time of
+// the file can change when it is copied
+func parseStamp(name string) (time.Time, int, bool) {
+	m := stampRe.FindStringSubmatch(name)
+	if m == nil {
+		return time.Time{}, 0, false
+	}
+	t, err := time.ParseInLocation(stampLayout, m[1], time.Local)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	seq := 1
+	if m[2] != "" {
+		seq, _ = strconv.Atoi(m[2])
+	}
+	return t, seq, true
+}
+
+// Confirmation is returned when a restore is asked for; the restore happens
+// once Token is sent back before ExpiresAt
+type Confirmation struct {
+	Backup    Info                `json:"backup"`
+	Content   query.BackupSummary `json:"content"`
+	Token     string              `json:"token"`
+	ExpiresAt time.Time           `json:"expires_at"`
+}
+
+// Manager owns the backups folder of a database
+type Manager struct {
+	db      *query.Database
+	dataDir string
+	dir     string
+
+	// Events receives the failures of the automatic backups, nil for none
+	Events *events.Bus
+
+	mu      sync.Mutex
+	policy  Policy
+	pending *Confirmation
+	// createMu serializes the backups, so that two of a same second get
+	// different names
+	createMu sync.Mutex
+	// wake interrupts the wait of Run when the policy changes
+	wake chan struct{}
+}
+
+// New returns the manager of the backups of db, stored in dataDir/backups
+func New(db *query.Database, dataDir string) *Manager {
+	return &Manager{
+		db:      db,
+		dataDir: dataDir,
+		dir:     filepath.Join(dataDir, query.BackupFolder),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Dir returns the backups folder
+func (m *Manager) Dir() string {
+	return m.dir
+}
+
+// Policy returns the current policy
+func (m *Manager) Policy() Policy {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.policy
+}
+
+// SetPolicy changes the schedule and the retention, applied right away
+func (m *Manager) SetPolicy(p Policy) {
+	m.mu.Lock()
+	m.policy = p
+	m.mu.Unlock()
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// List returns the backups, most recent first
+func (m *Manager) List() ([]Info, error) {
+	entries, err := os.ReadDir(m.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Info{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := []Info{}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".db" {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		info := Info{Name: e.Name(), Kind: kindOf(e.Name()), Size: fi.Size(), CreatedAt: fi.ModTime(), seq: 1}
+		// Files named by hand keep the time of the file
+		if t, seq, ok := parseStamp(e.Name()); ok {
+			info.CreatedAt, info.seq = t, seq
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.After(out[j].CreatedAt)
+		}
+		return out[i].seq > out[j].seq
+	})
+	return out, nil
+}
+
+// Create takes a backup of the given kind now
+func (m *Manager) Create(kind string) (Info, error) {
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return Info{}, err
+	}
+	m.createMu.Lock()
+	defer m.createMu.Unlock()
+	// The name holds the second, truncated to it so that it parses back
+	now := time.Now().Truncate(time.Second)
+	name := fmt.Sprintf("%s-%s.db", kind, now.Format(stampLayout))
+	seq := 1
+	for {
+		if _, err := os.Stat(filepath.Join(m.dir, name)); errors.Is(err, os.ErrNotExist) {
+			break
+		} else if err != nil {
+			return Info{}, err
+		}
+		seq++
+		name = fmt.Sprintf("%s-%s-%d.db", kind, now.Format(stampLayout), seq)
+	}
+	path := filepath.Join(m.dir, name)
+	if err := m.db.BackupTo(path); err != nil {
+		return Info{}, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{Name: name, Kind: kind, Size: fi.Size(), CreatedAt: now, seq: seq}, nil
+}
+
+// Prune deletes the automatic backups the policy doesn't keep and returns their names
+func (m *Manager) Prune() ([]string, error) {
+	all, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+	var auto []Info
+	for _, b := range all {
+		if b.Kind == KindAuto {
+			auto = append(auto, b)
+		}
+	}
+	keep := retain(auto, m.Policy())
+	var removed []string
+	for _, b := range auto {
+		if keep[b.Name] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(m.dir, b.Name)); err != nil {
+			return removed, err
+		}
+		removed = append(removed, b.Name)
+	}
+	return removed, nil
+}
+
+// retain returns the backups kept by p among backups sorted most recent
+// first: the last one of each of the p.Daily last days, p.Weekly last weeks
+// and p.Monthly last months. The most recent backup is always kept.
+func retain(backups []Info, p Policy) map[string]bool {
+	keep := map[string]bool{}
+	if len(backups) > 0 {
+		keep[backups[0].Name] = true
+	}
+	buckets := []struct {
+		n   int
+		key func(time.Time) string
+	}{
+		{p.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{p.Weekly, func(t time.Time) string { y, w := t.ISOWeek(); return fmt.Sprintf("%d-W%02d", y, w) }},
+		{p.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+	}
+	for _, bk := range buckets {
+		seen := map[string]bool{}
+		for _, b := range backups {
+			if len(seen) == bk.n {
+				break
+			}
+			k := bk.key(b.CreatedAt.Local())
+			if !seen[k] {
+				seen[k] = true
+				keep[b.Name] = true
+			}
+		}
+	}
+	return keep
+}
+
+// Run takes the automatic backups until ctx is done
+func (m *Manager) Run(ctx context.Context) {
+	for {
+		wait := time.Hour
+		if interval := m.Policy().Interval; interval > 0 {
+			next, err := m.nextBackup(interval)
+			if err != nil {
+				log.Println("Sauvegarde:", err)
+			} else if next <= 0 {
+				m.backupAndPrune()
+				next = interval
+			}
+			wait = min(wait, max(next, time.Minute))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// nextBackup returns the delay before the next automatic backup is due
+func (m *Manager) nextBackup(interval time.Duration) (time.Duration, error) {
+	all, err := m.List()
+	if err != nil {
+		return 0, err
+	}
+	for _, b := range all {
+		if b.Kind == KindAuto {
+			return time.Until(b.CreatedAt.Add(interval)), nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *Manager) backupAndPrune() {
+	b, err := m.Create(KindAuto)
+	if err != nil {
+		log.Println("Sauvegarde automatique:", err)
+		m.Events.Publish(events.BackupFailed, map[string]string{"error": err.Error()})
+		return
+	}
+	log.Printf("Sauvegarde automatique: %s\n", b.Name)
+	removed, err := m.Prune()
+	if err != nil {
+		log.Println("Sauvegarde, nettoyage:", err)
+	}
+	if len(removed) > 0 {
+		log.Printf("Sauvegardes supprimées: %s\n", strings.Join(removed, ", "))
+	}
+}
+
+// PrepareRestore checks a backup and returns the token confirming its restore
+func (m *Manager) PrepareRestore(name string) (Confirmation, error) {
+	info, err := m.find(name)
+	if err != nil {
+		return Confirmation{}, err
+	}
+	sum, err := query.InspectBackup(filepath.Join(m.dir, info.Name))
+	if err != nil {
+		return Confirmation{}, err
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return Confirmation{}, err
+	}
+	c := Confirmation{Backup: info, Content: sum, Token: hex.EncodeToString(buf), ExpiresAt: time.Now().Add(confirmDelay)}
+	m.mu.Lock()
+	m.pending = &c
+	m.mu.Unlock()
+	return c, nil
+}
+
+// ErrNotConfirmed is returned by Restore without the token of PrepareRestore
+var ErrNotConfirmed = errors.New("restore not confirmed, or confirmation expired")
+
+// Restore replaces the database with the backup name, confirmed by the token
+// of PrepareRestore. The current database is saved first as a pre-restore
+// backup, which is returned.
+func (m *Manager) Restore(name, token string) (Info, error) {
+	m.mu.Lock()
+	c := m.pending
+	ok := c != nil && c.Backup.Name == name && c.Token == token && time.Now().Before(c.ExpiresAt)
+	if ok {
+		m.pending = nil
+	}
+	m.mu.Unlock()
+	if !ok {
+		return Info{}, ErrNotConfirmed
+	}
+	info, err := m.find(name)
+	if err != nil {
+		return Info{}, err
+	}
+	pre, err := m.Create(KindPreRestore)
+	if err != nil {
+		return Info{}, err
+	}
+	if err := m.db.RestoreFrom(filepath.Join(m.dir, info.Name), m.dataDir); err != nil {
+		return pre, err
+	}
+	log.Printf("Sauvegarde %s restaurée, base précédente dans %s\n", info.Name, pre.Name)
+	return pre, nil
+}
+
+// ErrNotFound is returned for an unknown backup name
+var ErrNotFound = errors.New("backup not found")
+
+// find looks a backup up by file name, nothing outside the folder can be named
+func (m *Manager) find(name string) (Info, error) {
+	if name == "" || filepath.Base(name) != name {
+		return Info{}, ErrNotFound
+	}
+	all, err := m.List()
+	if err != nil {
+		return Info{}, err
+	}
+	for _, b := range all {
+		if b.Name == name {
+			return b, nil
+		}
+	}
+	return Info{}, ErrNotFound
+}
+
+func kindOf(name string) string {
+	for _, k := range []string{KindPreMigration, KindPreRestore, KindAuto, KindManual} {
+		if strings.HasPrefix(name, k+"-") {
+			return k
+		}
+	}
+	return ""
+}
@@ -0,0 +1,59 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"main/query"
+)
+
+func TestCreateAndListSameSecond(t *testing.T) {
+	dir := t.TempDir()
+	db, err := query.OpenDatabase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	m := New(db, dir)
+
+	var created []Info
+	for i := 0; i < 3; i++ {
+		b, err := m.Create(KindManual)
+		if err != nil {
+			t.Fatal(err)
+		}
+		created = append(created, b)
+	}
+	// A copied file gets a new time, the name keeps the right one
+	stamp := time.Date(2026, 3, 2, 10, 0, 0, 0, time.Local)
+	old := "auto-" + stamp.Format(stampLayout) + ".db"
+	if err := os.WriteFile(filepath.Join(m.Dir(), old), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := m.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 4 {
+		t.Fatalf("%d backups listed, want 4", len(all))
+	}
+	seen := map[string]bool{}
+	for _, b := range created {
+		if seen[b.Name] {
+			t.Errorf("backup name %s used twice", b.Name)
+		}
+		seen[b.Name] = true
+	}
+	// Most recent first, the last one of a same second before the others
+	for i, b := range created {
+		if got := all[len(created)-1-i]; got.Name != b.Name || !got.CreatedAt.Equal(b.CreatedAt) {
+			t.Errorf("backup %d listed as %+v, want %+v", i, got, b)
+		}
+	}
+	if last := all[3]; last.Name != old || !last.CreatedAt.Equal(stamp) || last.Kind != KindAuto {
+		t.Errorf("copied backup listed as %+v, want it dated %v", last, stamp)
+	}
+}
@@ -0,0 +1,116 @@
+package cli
+
+import (
+	"time"
+
+	"main/query"
+	"main/settings"
+)
+
+// Backend runs the commands, either on the database or through the HTTP API
+// of a running instance
+type Backend interface {
+	Summary(period, start, end string, metric query.Metric) (Summary, error)
+	History() ([]query.SessionItem, error)
+	Whitelist() ([]string, error)
+	AddWhitelist(name string) error
+	RemoveWhitelist(name string) error
+	Rename(from, to string) error
+	// Finish marks a game as finished on date (YYYY-MM-DD, empty for today),
+	// or not finished when done is false
+	Finish(game string, done bool, date string) error
+	Export() (query.Export, error)
+	Import(p query.Export, mode string) (string, error)
+	Close() error
+}
+
+// Summary is the play time per game over a period
+type Summary struct {
+	Start string              `json:"start"`
+	End   string              `json:"end"`
+	Items []query.SummaryItem `json:"items"`
+}
+
+// local works on the database of the data folder. A running instance doesn't
+// see list changes until it reloads them, --url goes through it instead.
+type local struct {
+	db *query.Database
+}
+
+// openLocal opens the database of the data folder, with the session thresholds
+// of the settings
+func openLocal(dataDir string) (*local, error) {
+	dir, _, configFile, err := settings.ResolveDataDir(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	db, err := query.OpenDatabase(dir)
+	if err != nil {
+		return nil, err
+	}
+	st, err := settings.Load(db.DB, configFile)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	cfg := st.Get()
+	db.SetSessionRules(query.SessionRules{
+		MinDuration: time.Duration(cfg.MinSessionSeconds) * time.Second,
+		MergeGap:    time.Duration(cfg.MergeGapMinutes) * time.Minute,
+	})
+	return &local{db: db}, nil
+}
+
+func (l *local) Summary(period, start, end string, metric query.Metric) (Summary, error) {
+	if start == "" || end == "" {
+		start, end = query.PeriodRange(period, time.Now())
+	}
+	items, err := l.db.GetSummaryBetween(start, end, metric)
+	return Summary{Start: start, End: end, Items: items}, err
+}
+
+func (l *local) History() ([]query.SessionItem, error) {
+	return l.db.GetHistory(false)
+}
+
+func (l *local) Whitelist() ([]string, error) {
+	return l.db.GetAllWhitelisted()
+}
+
+func (l *local) AddWhitelist(name string) error {
+	return l.db.InsertWhitelist(name)
+}
+
+func (l *local) RemoveWhitelist(name string) error {
+	return l.db.DeleteFromWhitelist(name)
+}
+
+func (l *local) Rename(from, to string) error {
+	return l.db.RenameSmart(from, to)
+}
+
+func (l *local) Finish(game string, done bool, date string) error {
+	id, err := l.db.ResolveGame(game)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !done:
+		return l.db.DeleteFinished(id)
+	case date != "":
+		return l.db.UpsertFinishedAt(id, date)
+	}
+	return l.db.InsertFinished(id)
+}
+
+func (l *local) Export() (query.Export, error) {
+	return l.db.Export()
+}
+
+func (l *local) Import(p query.Export, mode string) (string, error) {
+	return l.db.Import(p, mode)
+}
+
+func (l *local) Close() error {
+	return l.db.Close()
+}
@@ -0,0 +1,425 @@
+// Package cli implements the commands querying the stats and managing the
+// lists from a terminal, on the local database or through a running instance.
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"main/query"
+)
+
+// URLEnv is the address of a running instance used by default instead of the database
+const URLEnv = "WGT_URL"
+
+// command is a sub-command of the executable
+type command struct {
+	args string
+	help string
+	run  func(g *globals, args []string) int
+}
+
+var commands = map[string]command{
+	"summary":   {"[--period week|month|year] [--start AAAA-MM-JJ --end AAAA-MM-JJ] [--metric total|active|focused]", "temps de jeu par jeu sur une période", summaryCmd},
+	"history":   {"[--game NOM] [--limit N]", "dernières sessions", historyCmd},
+	"whitelist": {"ls | add NOM | rm NOM", "liste blanche historique", whitelistCmd},
+	"rename":    {"ANCIEN NOUVEAU", "renomme un jeu, ou rattache un exécutable au jeu NOUVEAU", renameCmd},
+	"finish":    {"[--date AAAA-MM-JJ] [--undo] JEU", "marque un jeu comme terminé", finishCmd},
+	"export":    {"[-o FICHIER]", "exporte toutes les données en JSON", exportCmd},
+	"import":    {"[--mode merge|replace] FICHIER|-", "importe un export JSON", importCmd},
+}
+
+// Names lists the commands, in the order of the help
+var Names = []string{"summary", "history", "whitelist", "rename", "finish", "export", "import"}
+
+// Has tells whether name is a command of this package
+func Has(name string) bool {
+	_, ok := commands[name]
+	return ok
+}
+
+// Usage writes one line per command
+func Usage(w io.Writer) {
+	for _, name := range Names {
+		fmt.Fprintf(w, "  %-10s %s\n", name, commands[name].help)
+	}
+}
+
+// Run runs the command name with its arguments and returns the exit code
+func Run(name string, args []string) int {
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "commande inconnue: %s\n", name)
+		return 2
+	}
+	return cmd.run(&globals{name: name, args: cmd.args}, args)
+}
+
+// globals are the options shared by every command
+type globals struct {
+	name, args string
+	fs         *flag.FlagSet
+
+	dataDir string
+	url     string
+	json    bool
+}
+
+// flags returns the flag set of the command, with the shared options
+func (g *globals) flags() *flag.FlagSet {
+	fs := flag.NewFlagSet(g.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Utilisation: %s %s %s\n\nOptions:\n", os.Args[0], g.name, g.args)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&g.dataDir, "data-dir", "", "dossier des données (sinon WGT_DATA_DIR ou le dossier du système)")
+	fs.StringVar(&g.url, "url", os.Getenv(URLEnv), "adresse d'une instance en cours (ex. 127.0.0.1:8080) plutôt que la base locale, défaut "+URLEnv)
+	fs.BoolVar(&g.json, "json", false, "sortie JSON plutôt qu'un tableau")
+	g.fs = fs
+	return fs
+}
+
+// parse parses the options, which may come after the positional arguments,
+// and checks the number of the latter. ok is false when the command must stop
+// with code.
+func (g *globals) parse(args []string, minArgs, maxArgs int) (pos []string, code int, ok bool) {
+	for {
+		if err := g.fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, 0, false
+			}
+			return nil, 2, false
+		}
+		args = g.fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		pos, args = append(pos, args[0]), args[1:]
+	}
+	if len(pos) < minArgs || len(pos) > maxArgs {
+		g.fs.Usage()
+		return nil, 2, false
+	}
+	return pos, 0, true
+}
+
+// backend opens the database, or the API of the instance given by --url
+func (g *globals) backend() (Backend, error) {
+	if g.url != "" {
+		return newRemote(g.url), nil
+	}
+	return openLocal(g.dataDir)
+}
+
+// fail reports err and returns the exit code of a failed command
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, "erreur:", err)
+	return 1
+}
+
+func printJSON(v any) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+// table writes aligned columns
+func table(header []string, rows [][]string) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, r := range rows {
+		fmt.Fprintln(tw, strings.Join(r, "\t"))
+	}
+	tw.Flush()
+}
+
+// formatSeconds formats a duration as 12h05
+func formatSeconds(s float64) string {
+	d := time.Duration(s) * time.Second
+	return fmt.Sprintf("%dh%02d", int(d.Hours()), int(d.Minutes())%60)
+}
+
+func summaryCmd(g *globals, args []string) int {
+	fs := g.flags()
+	period := fs.String("period", "week", "période: week, month ou year, jusqu'à aujourd'hui")
+	start := fs.String("start", "", "premier jour (avec --end)")
+	end := fs.String("end", "", "dernier jour (avec --start)")
+	metric := fs.String("metric", string(query.MetricTotal), "temps compté: total, active (hors inactivité) ou focused (au premier plan)")
+	if _, code, ok := g.parse(args, 0, 0); !ok {
+		return code
+	}
+	switch *period {
+	case "week", "month", "year":
+	default:
+		return fail(fmt.Errorf("période inconnue: %s", *period))
+	}
+	switch query.Metric(*metric) {
+	case query.MetricTotal, query.MetricActive, query.MetricFocused:
+	default:
+		return fail(fmt.Errorf("métrique inconnue: %s", *metric))
+	}
+	if (*start == "") != (*end == "") {
+		return fail(errors.New("--start et --end vont ensemble"))
+	}
+	for _, d := range []string{*start, *end} {
+		if _, err := time.Parse("2006-01-02", d); d != "" && err != nil {
+			return fail(fmt.Errorf("date invalide: %s", d))
+		}
+	}
+	b, err := g.backend()
+	if err != nil {
+		return fail(err)
+	}
+	defer b.Close()
+	s, err := b.Summary(*period, *start, *end, query.Metric(*metric))
+	if err != nil {
+		return fail(err)
+	}
+	if g.json {
+		return printJSON(s)
+	}
+	fmt.Printf("Du %s au %s\n\n", s.Start, s.End)
+	var total float64
+	rows := make([][]string, 0, len(s.Items)+1)
+	for _, it := range s.Items {
+		total += it.Seconds
+		rows = append(rows, []string{it.Name, formatSeconds(it.Seconds), formatSeconds(it.ActiveSeconds), formatSeconds(it.FocusedSeconds)})
+	}
+	rows = append(rows, []string{"Total", formatSeconds(total), "", ""})
+	table([]string{"JEU", "TEMPS", "ACTIF", "PREMIER PLAN"}, rows)
+	return 0
+}
+
+func historyCmd(g *globals, args []string) int {
+	fs := g.flags()
+	game := fs.String("game", "", "seulement ce jeu (nom du jeu ou de l'exécutable)")
+	limit := fs.Int("limit", 20, "nombre de sessions, 0 pour toutes")
+	if _, code, ok := g.parse(args, 0, 0); !ok {
+		return code
+	}
+	b, err := g.backend()
+	if err != nil {
+		return fail(err)
+	}
+	defer b.Close()
+	items, err := b.History()
+	if err != nil {
+		return fail(err)
+	}
+	// The history comes most recent first
+	out := []query.SessionItem{}
+	for _, it := range items {
+		if *game != "" && !strings.EqualFold(it.Name, *game) && !strings.EqualFold(it.Original, *game) {
+			continue
+		}
+		if *limit > 0 && len(out) == *limit {
+			break
+		}
+		out = append(out, it)
+	}
+	if g.json {
+		return printJSON(out)
+	}
+	rows := make([][]string, 0, len(out))
+	for _, it := range out {
+		var flags []string
+		if it.Finished {
+			flags = append(flags, "terminé")
+		}
+		if it.Blacklisted {
+			flags = append(flags, "liste noire")
+		}
+		rows = append(rows, []string{it.Name, clock(it.Start), clock(it.End), formatSeconds(it.Seconds), strings.Join(flags, ", ")})
+	}
+	table([]string{"JEU", "DÉBUT", "FIN", "DURÉE", ""}, rows)
+	return 0
+}
+
+// clock formats an RFC3339 time in local time
+func clock(s string) string {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return s
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+func whitelistCmd(g *globals, args []string) int {
+	g.flags()
+	pos, code, ok := g.parse(args, 1, 2)
+	if !ok {
+		return code
+	}
+	action := pos[0]
+	if (action == "ls") != (len(pos) == 1) {
+		g.fs.Usage()
+		return 2
+	}
+	b, err := g.backend()
+	if err != nil {
+		return fail(err)
+	}
+	defer b.Close()
+	switch action {
+	case "ls":
+		names, err := b.Whitelist()
+		if err != nil {
+			return fail(err)
+		}
+		if names == nil {
+			names = []string{}
+		}
+		if g.json {
+			return printJSON(names)
+		}
+		for _, n := range names {
+			fmt.Println(n)
+		}
+		return 0
+	case "add":
+		err = b.AddWhitelist(strings.TrimSpace(pos[1]))
+	case "rm":
+		err = b.RemoveWhitelist(strings.TrimSpace(pos[1]))
+	default:
+		g.fs.Usage()
+		return 2
+	}
+	if err != nil {
+		return fail(err)
+	}
+	return done(g, map[string]string{"status": "ok"})
+}
+
+func renameCmd(g *globals, args []string) int {
+	g.flags()
+	pos, code, ok := g.parse(args, 2, 2)
+	if !ok {
+		return code
+	}
+	b, err := g.backend()
+	if err != nil {
+		return fail(err)
+	}
+	defer b.Close()
+	if err := b.Rename(strings.TrimSpace(pos[0]), strings.TrimSpace(pos[1])); err != nil {
+		return fail(err)
+	}
+	return done(g, map[string]string{"status": "ok"})
+}
+
+func finishCmd(g *globals, args []string) int {
+	fs := g.flags()
+	date := fs.String("date", "", "date de fin, aujourd'hui par défaut")
+	undo := fs.Bool("undo", false, "retire la marque terminé")
+	pos, code, ok := g.parse(args, 1, 1)
+	if !ok {
+		return code
+	}
+	if *date != "" {
+		if _, err := time.Parse("2006-01-02", *date); err != nil {
+			return fail(fmt.Errorf("date invalide: %s", *date))
+		}
+	}
+	b, err := g.backend()
+	if err != nil {
+		return fail(err)
+	}
+	defer b.Close()
+	if err := b.Finish(strings.TrimSpace(pos[0]), !*undo, *date); err != nil {
+		return fail(err)
+	}
+	return done(g, map[string]string{"status": "ok"})
+}
+
+func exportCmd(g *globals, args []string) int {
+	fs := g.flags()
+	output := fs.String("o", "", "fichier de sortie, la sortie standard par défaut")
+	if _, code, ok := g.parse(args, 0, 0); !ok {
+		return code
+	}
+	b, err := g.backend()
+	if err != nil {
+		return fail(err)
+	}
+	defer b.Close()
+	p, err := b.Export()
+	if err != nil {
+		return fail(err)
+	}
+	w := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fail(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(p); err != nil {
+		return fail(err)
+	}
+	if *output != "" {
+		fmt.Fprintf(os.Stderr, "%d activités exportées dans %s\n", len(p.Activities), *output)
+	}
+	return 0
+}
+
+func importCmd(g *globals, args []string) int {
+	fs := g.flags()
+	mode := fs.String("mode", "", "merge (ajoute ce qui manque) ou replace (efface tout avant), sinon celui de l'export")
+	pos, code, ok := g.parse(args, 1, 1)
+	if !ok {
+		return code
+	}
+	if *mode != "" && *mode != query.ImportMerge && *mode != query.ImportReplace {
+		return fail(fmt.Errorf("mode inconnu: %s", *mode))
+	}
+	r := io.Reader(os.Stdin)
+	if pos[0] != "-" {
+		f, err := os.Open(pos[0])
+		if err != nil {
+			return fail(err)
+		}
+		defer f.Close()
+		r = f
+	}
+	var p query.Export
+	if err := json.NewDecoder(r).Decode(&p); err != nil {
+		return fail(fmt.Errorf("export invalide: %w", err))
+	}
+	b, err := g.backend()
+	if err != nil {
+		return fail(err)
+	}
+	defer b.Close()
+	used, err := b.Import(p, *mode)
+	if err != nil {
+		return fail(err)
+	}
+	if g.json {
+		return printJSON(map[string]string{"status": "ok", "mode": used})
+	}
+	fmt.Printf("import terminé (%s), %d activités lues\n", used, len(p.Activities))
+	return 0
+}
+
+// done reports a successful change
+func done(g *globals, v any) int {
+	if g.json {
+		return printJSON(v)
+	}
+	fmt.Println("ok")
+	return 0
+}
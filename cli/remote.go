@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"main/query"
+)
+
+// remote goes through the HTTP API of a running instance, which applies the
+// list changes immediately
+type remote struct {
+	base   string
+	client *http.Client
+}
+
+func newRemote(base string) *remote {
+	base = strings.TrimRight(strings.TrimSpace(base), "/")
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	return &remote{base: base, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// do sends body (if any) as JSON and decodes the JSON response into out (if any)
+func (r *remote) do(method, path string, params url.Values, body, out any) error {
+	u := r.base + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	var rd io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, u, rd)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+func (r *remote) Summary(period, start, end string, metric query.Metric) (Summary, error) {
+	params := url.Values{"period": {period}, "metric": {string(metric)}}
+	if start != "" && end != "" {
+		params.Set("start", start)
+		params.Set("end", end)
+	}
+	var s Summary
+	err := r.do(http.MethodGet, "/api/summary", params, nil, &s)
+	return s, err
+}
+
+func (r *remote) History() ([]query.SessionItem, error) {
+	var items []query.SessionItem
+	err := r.do(http.MethodGet, "/api/history", nil, nil, &items)
+	return items, err
+}
+
+func (r *remote) Whitelist() ([]string, error) {
+	var names []string
+	err := r.do(http.MethodGet, "/api/whitelist", nil, nil, &names)
+	return names, err
+}
+
+func (r *remote) AddWhitelist(name string) error {
+	return r.do(http.MethodPost, "/api/whitelist", nil, map[string]string{"name": name}, nil)
+}
+
+func (r *remote) RemoveWhitelist(name string) error {
+	return r.do(http.MethodPost, "/api/unwhitelist", nil, map[string]string{"name": name}, nil)
+}
+
+func (r *remote) Rename(from, to string) error {
+	return r.do(http.MethodPost, "/api/rename", nil, map[string]string{"from": from, "to": to}, nil)
+}
+
+func (r *remote) Finish(game string, done bool, date string) error {
+	if done && date != "" {
+		return r.do(http.MethodPost, "/api/set_finished_date", nil, map[string]string{"name": game, "date": date}, nil)
+	}
+	return r.do(http.MethodPost, "/api/finished", nil, map[string]any{"name": game, "done": done}, nil)
+}
+
+func (r *remote) Export() (query.Export, error) {
+	var p query.Export
+	err := r.do(http.MethodGet, "/api/export", nil, nil, &p)
+	return p, err
+}
+
+func (r *remote) Import(p query.Export, mode string) (string, error) {
+	var params url.Values
+	if mode != "" {
+		params = url.Values{"mode": {mode}}
+	}
+	var resp struct {
+		Mode string `json:"mode"`
+	}
+	err := r.do(http.MethodPost, "/api/import", params, p, &resp)
+	return resp.Mode, err
+}
+
+func (r *remote) Close() error {
+	return nil
+}
@@ -0,0 +1,227 @@
+// Package curfew keeps games within their allowed time windows: the monitor
+// hands it the running sessions, it logs the violations and applies the
+// action of the curfews.
+package curfew
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"main/events"
+	"main/monitor"
+	"main/query"
+)
+
+// Schedule holds the curfews and their exceptions
+type Schedule struct {
+	Curfews    []query.Curfew
+	Exceptions []query.CurfewException
+}
+
+// Forbidden returns the curfews forbidding the game gameID to run at t, none
+// when it may run. The curfews of all games and those of the game both have
+// to allow it; a scope without curfew covering the day is not restricted.
+func (s Schedule) Forbidden(gameID int64, t time.Time) []query.Curfew {
+	for _, x := range s.Exceptions {
+		if (x.GameID == 0 || x.GameID == gameID) && x.Includes(t) {
+			return nil
+		}
+	}
+	scopes := []int64{0}
+	if gameID != 0 {
+		scopes = append(scopes, gameID)
+	}
+	var out []query.Curfew
+	for _, scope := range scopes {
+		var covering []query.Curfew
+		allowed := false
+		for _, c := range s.Curfews {
+			if !c.Enabled || c.GameID != scope {
+				continue
+			}
+			if c.Contains(t) {
+				allowed = true
+				break
+			}
+			if c.Covers(t) {
+				covering = append(covering, c)
+			}
+		}
+		if !allowed {
+			out = append(out, covering...)
+		}
+	}
+	return out
+}
+
+// strictest returns the curfew with the strongest action, the shortest grace
+// period among those that terminate
+func strictest(curfews []query.Curfew) query.Curfew {
+	rank := map[string]int{query.ActionNotify: 0, query.ActionWarn: 1, query.ActionTerminate: 2}
+	best := curfews[0]
+	for _, c := range curfews[1:] {
+		if rank[c.Action] > rank[best.Action] || (c.Action == best.Action && c.GraceSeconds < best.GraceSeconds) {
+			best = c
+		}
+	}
+	return best
+}
+
+// violation is the current violation of a running game
+type violation struct {
+	// session is the start of the session, a new session is a new violation
+	session time.Time
+	record  query.CurfewViolation
+	// since is the start of the grace period
+	since    time.Time
+	enforced bool
+	// scheduled is set once a check is planned at the end of the grace period
+	scheduled bool
+}
+
+// Enforcer implements monitor.Guard
+type Enforcer struct {
+	db  *query.Database
+	pm  *monitor.ProcessMonitor
+	bus *events.Bus
+
+	// Warn shows a warning in the tray, an empty message clearing it; nil
+	// when there is no tray
+	Warn func(msg string)
+
+	mu sync.Mutex
+	// violations is keyed by game id
+	violations map[int64]*violation
+	warning    string
+}
+
+// New returns the enforcer of the curfews of db, stopping the games through pm
+// and publishing on bus. It is set as the guard of pm.
+func New(db *query.Database, pm *monitor.ProcessMonitor, bus *events.Bus) *Enforcer {
+	e := &Enforcer{
+		db:         db,
+		pm:         pm,
+		bus:        bus,
+		violations: make(map[int64]*violation),
+	}
+	pm.Guard = e
+	return e
+}
+
+// Changed checks the running games right away, to be called once the curfews
+// or exceptions are modified
+func (e *Enforcer) Changed() {
+	go func() {
+		now := time.Now()
+		e.Check(now, e.pm.NowPlaying(now))
+	}()
+}
+
+// Schedule reads the curfews and exceptions from the database
+func (e *Enforcer) Schedule() (Schedule, error) {
+	var s Schedule
+	var err error
+	if s.Curfews, err = e.db.GetCurfews(); err != nil {
+		return s, fmt.Errorf("curfew: %w", err)
+	}
+	if s.Exceptions, err = e.db.GetCurfewExceptions(); err != nil {
+		return s, fmt.Errorf("curfew: %w", err)
+	}
+	return s, nil
+}
+
+// Check implements monitor.Guard: it logs the games running outside their
+// windows and applies the action of the strictest curfew
+func (e *Enforcer) Check(now time.Time, playing []monitor.Playing) {
+	schedule, err := e.Schedule()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	running := make(map[int64]bool, len(playing))
+	var warnings []string
+	for _, p := range playing {
+		forbidden := schedule.Forbidden(p.GameID, now)
+		if len(forbidden) == 0 {
+			continue
+		}
+		running[p.GameID] = true
+		c := strictest(forbidden)
+		v, ok := e.violations[p.GameID]
+		if !ok || !v.session.Equal(p.StartTime) {
+			v = e.record(p, c, schedule, now)
+			e.violations[p.GameID] = v
+		}
+		if c.Action != query.ActionNotify {
+			warnings = append(warnings, fmt.Sprintf("Hors des horaires autorisés : %s", p.Game))
+		}
+		end := v.since.Add(time.Duration(c.GraceSeconds) * time.Second)
+		if c.Action == query.ActionTerminate && !v.enforced && now.Before(end) && !v.scheduled {
+			// The monitor only checks every few seconds
+			v.scheduled = true
+			time.AfterFunc(end.Sub(now), e.Changed)
+		}
+		if c.Action == query.ActionTerminate && !v.enforced && !now.Before(end) {
+			n, err := e.pm.TerminateGame(p.GameID)
+			if err != nil {
+				log.Println("curfew:", err)
+			}
+			if n > 0 {
+				v.enforced = true
+				v.record.Terminated = true
+				if err := e.db.SetViolationTerminated(v.record.ID); err != nil {
+					log.Println("curfew:", err)
+				}
+				e.bus.Publish(events.CurfewEnforced, v.record)
+				log.Printf("Hors des horaires autorisés : %s arrêté (%d processus)\n", p.Game, n)
+			}
+		}
+	}
+	// The games that stopped or are allowed again
+	for id := range e.violations {
+		if !running[id] {
+			delete(e.violations, id)
+		}
+	}
+
+	warning := strings.Join(warnings, "\n")
+	if warning != e.warning && e.Warn != nil {
+		e.Warn(warning)
+	}
+	e.warning = warning
+}
+
+// record logs a new violation of the session p; must be called with e.mu held
+func (e *Enforcer) record(p monitor.Playing, c query.Curfew, schedule Schedule, now time.Time) *violation {
+	kind := query.ViolationRunning
+	if len(schedule.Forbidden(p.GameID, p.StartTime)) > 0 {
+		kind = query.ViolationStart
+	}
+	v := &violation{
+		session: p.StartTime,
+		since:   now,
+		record: query.CurfewViolation{
+			GameID:      p.GameID,
+			Game:        p.Game,
+			ProcessName: p.ProcessName,
+			CurfewID:    c.ID,
+			At:          now.Format(time.RFC3339),
+			Kind:        kind,
+			Action:      c.Action,
+		},
+	}
+	id, err := e.db.InsertCurfewViolation(v.record)
+	if err != nil {
+		log.Println("curfew:", err)
+	}
+	v.record.ID = id
+	e.bus.Publish(events.CurfewViolation, v.record)
+	log.Printf("Hors des horaires autorisés : %s (%s, fenêtre %s-%s)\n", p.Game, kind, c.Start, c.End)
+	return v
+}
@@ -0,0 +1,152 @@
+package datadir
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DatabaseFile is the name of the database in the data folder
+const DatabaseFile = "activity_tracker.db"
+
+// EnvVar overrides the data folder, below the --data-dir flag
+const EnvVar = "WGT_DATA_DIR"
+
+// Where the data folder comes from
+const (
+	SourceFlag    = "--data-dir"
+	SourceEnv     = EnvVar
+	SourceConfig  = "config"
+	SourceDefault = "default"
+)
+
+// movedFiles are taken along when a legacy folder is migrated
+var movedFiles = []string{DatabaseFile, DatabaseFile + "-wal", DatabaseFile + "-shm", "config.json"}
+
+// Resolve returns the data folder, flagDir first, then WGT_DATA_DIR, then the
+// folder of the platform, along with where it comes from
+func Resolve(flagDir string) (string, string) {
+	if flagDir != "" {
+		return absolute(flagDir), SourceFlag
+	}
+	if dir := os.Getenv(EnvVar); dir != "" {
+		return absolute(dir), SourceEnv
+	}
+	return Default(), SourceDefault
+}
+
+// Default returns the data folder of the platform: XDG_DATA_HOME (or
+// ~/.local/share) on Linux, Application Support on macOS, APPDATA on Windows
+func Default() string {
+	return absolute(platformDir())
+}
+
+// Prepare creates dir. For the default folder, a database left in a legacy
+// location is moved there first, unless dir already has one.
+func Prepare(dir, source string) error {
+	if source == SourceDefault {
+		if err := migrateLegacy(dir); err != nil {
+			// The legacy database stays where it is, a new one is created
+			log.Println("migration du dossier de données:", err)
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("datadir: %w", err)
+	}
+	return nil
+}
+
+// legacyFolder is the name of the data folder of previous versions
+const legacyFolder = ".steam_watcher"
+
+// legacyDirs lists the folders used by previous versions: APPDATA/.steam_watcher,
+// which was relative to the working folder when APPDATA is not set. That one
+// can't be known afterwards, so the working folder and the folder of the
+// executable are probed.
+func legacyDirs() []string {
+	if appData := os.Getenv("APPDATA"); appData != "" {
+		return []string{absolute(filepath.Join(appData, legacyFolder))}
+	}
+	dirs := []string{absolute(legacyFolder)}
+	if exe, err := os.Executable(); err == nil {
+		if dir := filepath.Join(filepath.Dir(exe), legacyFolder); dir != dirs[0] {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+func migrateLegacy(dir string) error {
+	if exists(filepath.Join(dir, DatabaseFile)) {
+		return nil
+	}
+	for _, legacy := range legacyDirs() {
+		if legacy == dir || !exists(filepath.Join(legacy, DatabaseFile)) {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		for _, name := range movedFiles {
+			from := filepath.Join(legacy, name)
+			if !exists(from) {
+				continue
+			}
+			if err := move(from, filepath.Join(dir, name)); err != nil {
+				return err
+			}
+		}
+		log.Printf("Base de données déplacée de %s vers %s\n", legacy, dir)
+		// The legacy folder is removed only when nothing else is left in it
+		os.Remove(legacy)
+		return nil
+	}
+	if os.Getenv("APPDATA") == "" {
+		log.Printf("Aucune ancienne base trouvée dans %s ; une base lancée depuis un autre dossier n'est pas reprise, la déplacer à la main dans %s\n",
+			strings.Join(legacyDirs(), ", "), dir)
+	}
+	return nil
+}
+
+// move renames a file, copying it when the rename crosses file systems
+func move(from, to string) error {
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(to)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(to)
+		return err
+	}
+	src.Close()
+	return os.Remove(from)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return !errors.Is(err, os.ErrNotExist)
+}
+
+func absolute(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		return abs
+	}
+	return dir
+}
@@ -0,0 +1,16 @@
+//go:build darwin
+
+package datadir
+
+import (
+	"os"
+	"path/filepath"
+)
+
+func platformDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".steam_watcher"
+	}
+	return filepath.Join(home, "Library", "Application Support", "steam_watcher")
+}
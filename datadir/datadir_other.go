@@ -0,0 +1,20 @@
+//go:build !windows && !darwin
+
+package datadir
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// platformDir follows the XDG base directory specification
+func platformDir() string {
+	if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "steam_watcher")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".steam_watcher"
+	}
+	return filepath.Join(home, ".local", "share", "steam_watcher")
+}
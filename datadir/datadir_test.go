@@ -0,0 +1,31 @@
+package datadir
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateLegacyFromWorkingFolder(t *testing.T) {
+	t.Setenv("APPDATA", "")
+	work := t.TempDir()
+	t.Chdir(work)
+	legacy := filepath.Join(work, legacyFolder)
+	if err := os.MkdirAll(legacy, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(legacy, DatabaseFile), []byte("db"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "data")
+	if err := Prepare(dir, SourceDefault); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, DatabaseFile)); err != nil || string(b) != "db" {
+		t.Errorf("database not moved: %q, %v", b, err)
+	}
+	if exists(legacy) {
+		t.Errorf("legacy folder %s left behind", legacy)
+	}
+}
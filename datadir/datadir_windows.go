@@ -0,0 +1,13 @@
+//go:build windows
+
+package datadir
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// platformDir keeps the folder of the previous versions
+func platformDir() string {
+	return filepath.Join(os.Getenv("APPDATA"), ".steam_watcher")
+}
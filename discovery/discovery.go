@@ -0,0 +1,149 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Candidate is a game found in the metadata of a launcher
+type Candidate struct {
+	Source      string   `json:"source"`
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	InstallDir  string   `json:"install_dir"`
+	Executables []string `json:"executables"`
+}
+
+// Provider reads the local metadata of one launcher
+type Provider interface {
+	Name() string
+	Discover() ([]Candidate, error)
+}
+
+// DefaultProviders returns the providers with the usual locations for the platform
+func DefaultProviders() []Provider {
+	home, _ := os.UserHomeDir()
+	configDir, _ := os.UserConfigDir()
+	var steamRoots, heroicDirs, legendaryDirs []string
+	providers := []Provider{}
+
+	switch runtime.GOOS {
+	case "windows":
+		pf86 := os.Getenv("ProgramFiles(x86)")
+		if pf86 == "" {
+			pf86 = `C:\Program Files (x86)`
+		}
+		programData := os.Getenv("ProgramData")
+		if programData == "" {
+			programData = `C:\ProgramData`
+		}
+		steamRoots = []string{filepath.Join(pf86, "Steam")}
+		providers = append(providers,
+			&EpicProvider{ManifestDir: filepath.Join(programData, "Epic", "EpicGamesLauncher", "Data", "Manifests")},
+			&GOGGalaxyProvider{DBPath: filepath.Join(programData, "GOG.com", "Galaxy", "storage", "galaxy-2.0.db")},
+		)
+	case "darwin":
+		steamRoots = []string{filepath.Join(home, "Library", "Application Support", "Steam")}
+	default:
+		dataHome := os.Getenv("XDG_DATA_HOME")
+		if dataHome == "" {
+			dataHome = filepath.Join(home, ".local", "share")
+		}
+		steamRoots = []string{
+			filepath.Join(home, ".steam", "steam"),
+			filepath.Join(dataHome, "Steam"),
+			filepath.Join(home, ".var", "app", "com.valvesoftware.Steam", ".local", "share", "Steam"),
+		}
+		providers = append(providers, &LutrisProvider{
+			DBPath:    filepath.Join(dataHome, "lutris", "pga.db"),
+			ConfigDir: filepath.Join(configDir, "lutris", "games"),
+		})
+	}
+	heroicDirs = []string{filepath.Join(configDir, "heroic")}
+	legendaryDirs = []string{filepath.Join(configDir, "legendary")}
+
+	providers = append([]Provider{&SteamProvider{Roots: steamRoots}}, providers...)
+	providers = append(providers, &HeroicProvider{HeroicDirs: heroicDirs, LegendaryDirs: legendaryDirs})
+	return providers
+}
+
+// Discover runs every provider; a failing provider does not hide the others.
+// Candidates sharing an install directory are reported once.
+func Discover(providers []Provider) ([]Candidate, map[string]error) {
+	var all []Candidate
+	errs := map[string]error{}
+	seen := map[string]struct{}{}
+	for _, p := range providers {
+		found, err := p.Discover()
+		if err != nil {
+			errs[p.Name()] = err
+		}
+		for _, c := range found {
+			key := strings.ToLower(filepath.Clean(c.InstallDir))
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			if c.Executables == nil {
+				c.Executables = FindExecutables(c.InstallDir)
+			}
+			all = append(all, c)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return strings.ToLower(all[i].Name) < strings.ToLower(all[j].Name) })
+	return all, errs
+}
+
+// maxExecutables bounds the number of executables proposed per game
+const maxExecutables = 20
+
+// FindExecutables lists the executable files of an install directory,
+// looking two levels deep at most
+func FindExecutables(dir string) []string {
+	exes := []string{}
+	var walk func(d string, depth int)
+	walk = func(d string, depth int) {
+		entries, err := os.ReadDir(d)
+		if err != nil {
+			return
+		}
+		for _, e := range entries {
+			if len(exes) >= maxExecutables {
+				return
+			}
+			p := filepath.Join(d, e.Name())
+			if e.IsDir() {
+				if depth < 2 {
+					walk(p, depth+1)
+				}
+				continue
+			}
+			if isExecutable(e) {
+				exes = append(exes, p)
+			}
+		}
+	}
+	walk(dir, 0)
+	return exes
+}
+
+func isExecutable(e os.DirEntry) bool {
+	name := strings.ToLower(e.Name())
+	if strings.HasSuffix(name, ".exe") {
+		// Installers and crash handlers are not games
+		for _, skip := range []string{"unins", "setup", "crash", "redist", "vc_redist", "dxsetup"} {
+			if strings.Contains(name, skip) {
+				return false
+			}
+		}
+		return true
+	}
+	if runtime.GOOS == "windows" || strings.Contains(name, ".so") || strings.HasSuffix(name, ".sh") {
+		return false
+	}
+	info, err := e.Info()
+	return err == nil && info.Mode().IsRegular() && info.Mode()&0o111 != 0
+}
@@ -0,0 +1,45 @@
+package discovery
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// EpicProvider reads the *.item manifests of the Epic Games Launcher
+type EpicProvider struct {
+	ManifestDir string
+}
+
+type epicManifest struct {
+	AppName          string `json:"AppName"`
+	DisplayName      string `json:"DisplayName"`
+	InstallLocation  string `json:"InstallLocation"`
+	LaunchExecutable string `json:"LaunchExecutable"`
+}
+
+func (p *EpicProvider) Name() string { return "epic" }
+
+func (p *EpicProvider) Discover() ([]Candidate, error) {
+	items, err := filepath.Glob(filepath.Join(p.ManifestDir, "*.item"))
+	if err != nil {
+		return nil, err
+	}
+	var out []Candidate
+	for _, item := range items {
+		data, err := os.ReadFile(item)
+		if err != nil {
+			continue
+		}
+		var m epicManifest
+		if err := json.Unmarshal(data, &m); err != nil || m.InstallLocation == "" {
+			continue
+		}
+		c := Candidate{Source: "epic", ID: m.AppName, Name: m.DisplayName, InstallDir: m.InstallLocation}
+		if m.LaunchExecutable != "" {
+			c.Executables = []string{filepath.Join(m.InstallLocation, m.LaunchExecutable)}
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
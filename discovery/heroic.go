@@ -0,0 +1,88 @@
+package discovery
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// HeroicProvider reads the installed games of Heroic (Epic through Legendary,
+// GOG) and of a standalone Legendary
+type HeroicProvider struct {
+	HeroicDirs    []string
+	LegendaryDirs []string
+}
+
+type legendaryGame struct {
+	AppName     string `json:"app_name"`
+	Title       string `json:"title"`
+	InstallPath string `json:"install_path"`
+	Executable  string `json:"executable"`
+}
+
+type heroicGOGInstalled struct {
+	Installed []struct {
+		AppName     string `json:"appName"`
+		InstallPath string `json:"install_path"`
+	} `json:"installed"`
+}
+
+func (p *HeroicProvider) Name() string { return "heroic" }
+
+func (p *HeroicProvider) Discover() ([]Candidate, error) {
+	var out []Candidate
+	legendary := append([]string{}, p.LegendaryDirs...)
+	for _, d := range p.HeroicDirs {
+		legendary = append(legendary, filepath.Join(d, "legendaryConfig", "legendary"))
+		out = append(out, heroicGOG(filepath.Join(d, "gog_store", "installed.json"))...)
+	}
+	for _, d := range legendary {
+		out = append(out, legendaryInstalled(filepath.Join(d, "installed.json"))...)
+	}
+	return out, nil
+}
+
+func legendaryInstalled(file string) []Candidate {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	games := map[string]legendaryGame{}
+	if err := json.Unmarshal(data, &games); err != nil {
+		return nil
+	}
+	var out []Candidate
+	for _, g := range games {
+		if g.InstallPath == "" {
+			continue
+		}
+		c := Candidate{Source: "epic", ID: g.AppName, Name: g.Title, InstallDir: g.InstallPath}
+		if g.Executable != "" {
+			c.Executables = []string{filepath.Join(g.InstallPath, g.Executable)}
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+func heroicGOG(file string) []Candidate {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	var inst heroicGOGInstalled
+	if err := json.Unmarshal(data, &inst); err != nil {
+		return nil
+	}
+	var out []Candidate
+	for _, g := range inst.Installed {
+		if g.InstallPath == "" {
+			continue
+		}
+		// The title is only in the library cache, the folder name is close enough
+		name := filepath.Base(strings.TrimRight(g.InstallPath, `/\`))
+		out = append(out, Candidate{Source: "gog", ID: g.AppName, Name: name, InstallDir: g.InstallPath})
+	}
+	return out
+}
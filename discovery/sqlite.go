@@ -0,0 +1,140 @@
+package discovery
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	_ "modernc.org/sqlite"
+)
+
+// openReadOnly opens a launcher database without ever writing to it
+func openReadOnly(path string) (*sqlx.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return sqlx.Open("sqlite", "file:"+filepath.ToSlash(path)+"?mode=ro")
+}
+
+// LutrisProvider reads the Lutris game database and game configurations
+type LutrisProvider struct {
+	DBPath    string
+	ConfigDir string
+}
+
+type lutrisGame struct {
+	ID         int64  `db:"id"`
+	Name       string `db:"name"`
+	Directory  string `db:"directory"`
+	ConfigPath string `db:"configpath"`
+}
+
+func (p *LutrisProvider) Name() string { return "lutris" }
+
+func (p *LutrisProvider) Discover() ([]Candidate, error) {
+	db, err := openReadOnly(p.DBPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer db.Close()
+
+	games := []lutrisGame{}
+	q := `SELECT id, COALESCE(name,'') AS name, COALESCE(directory,'') AS directory, COALESCE(configpath,'') AS configpath
+	      FROM games WHERE installed = 1`
+	if err := db.Select(&games, q); err != nil {
+		return nil, fmt.Errorf("lutris: %w", err)
+	}
+	var out []Candidate
+	for _, g := range games {
+		c := Candidate{Source: "lutris", ID: fmt.Sprint(g.ID), Name: g.Name, InstallDir: g.Directory}
+		if g.ConfigPath != "" {
+			if exe := lutrisExe(filepath.Join(p.ConfigDir, g.ConfigPath+".yml")); exe != "" {
+				if !filepath.IsAbs(exe) && g.Directory != "" {
+					exe = filepath.Join(g.Directory, exe)
+				}
+				c.Executables = []string{exe}
+				if c.InstallDir == "" {
+					c.InstallDir = filepath.Dir(exe)
+				}
+			}
+		}
+		if c.InstallDir == "" {
+			continue
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// lutrisExe reads the "exe:" entry of the game section of a Lutris YAML config
+func lutrisExe(file string) string {
+	f, err := os.Open(file)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	inGame := false
+	for sc.Scan() {
+		line := sc.Text()
+		if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+			inGame = strings.TrimSpace(line) == "game:"
+			continue
+		}
+		if !inGame {
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "exe:") {
+			return strings.Trim(strings.TrimSpace(strings.TrimPrefix(trimmed, "exe:")), `"'`)
+		}
+	}
+	return ""
+}
+
+// GOGGalaxyProvider reads the GOG Galaxy 2.0 database
+type GOGGalaxyProvider struct {
+	DBPath string
+}
+
+type galaxyProduct struct {
+	ProductID int64  `db:"productId"`
+	Path      string `db:"installationPath"`
+	Title     string `db:"title"`
+}
+
+func (p *GOGGalaxyProvider) Name() string { return "gog" }
+
+func (p *GOGGalaxyProvider) Discover() ([]Candidate, error) {
+	db, err := openReadOnly(p.DBPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer db.Close()
+
+	rows := []galaxyProduct{}
+	q := `SELECT ib.productId AS productId, ib.installationPath AS installationPath, COALESCE(ld.title, '') AS title
+	      FROM InstalledBaseProducts ib
+	      LEFT JOIN LimitedDetails ld ON ld.productId = ib.productId`
+	if err := db.Select(&rows, q); err != nil {
+		return nil, fmt.Errorf("gog galaxy: %w", err)
+	}
+	var out []Candidate
+	for _, r := range rows {
+		name := r.Title
+		if name == "" {
+			name = filepath.Base(r.Path)
+		}
+		out = append(out, Candidate{Source: "gog", ID: fmt.Sprint(r.ProductID), Name: name, InstallDir: r.Path})
+	}
+	return out, nil
+}
@@ -0,0 +1,130 @@
+package discovery
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SteamProvider reads libraryfolders.vdf and appmanifest_*.acf files
+type SteamProvider struct {
+	Roots []string
+}
+
+// SteamApp is an installed Steam application
+type SteamApp struct {
+	AppID      string
+	Name       string
+	InstallDir string
+}
+
+// steamTools are Steam components installed like games
+var steamTools = []string{"Proton", "Steam Linux Runtime", "Steamworks Common Redistributables", "SteamVR"}
+
+func (p *SteamProvider) Name() string { return "steam" }
+
+func (p *SteamProvider) Discover() ([]Candidate, error) {
+	apps, err := p.Apps()
+	out := make([]Candidate, 0, len(apps))
+	for _, a := range apps {
+		if isSteamTool(a.Name) {
+			continue
+		}
+		out = append(out, Candidate{Source: "steam", ID: a.AppID, Name: a.Name, InstallDir: a.InstallDir})
+	}
+	return out, err
+}
+
+func isSteamTool(name string) bool {
+	for _, t := range steamTools {
+		if strings.HasPrefix(name, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apps lists the applications installed in every library of every root
+func (p *SteamProvider) Apps() ([]SteamApp, error) {
+	var apps []SteamApp
+	var firstErr error
+	seen := map[string]struct{}{}
+	for _, lib := range p.libraries() {
+		key := strings.ToLower(filepath.Clean(lib))
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		found, err := LibraryApps(lib)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		apps = append(apps, found...)
+	}
+	return apps, firstErr
+}
+
+// libraries returns the library folders declared by each root, the root included
+func (p *SteamProvider) libraries() []string {
+	var libs []string
+	for _, root := range p.Roots {
+		if _, err := os.Stat(filepath.Join(root, "steamapps")); err != nil {
+			continue
+		}
+		libs = append(libs, root)
+		data, err := os.ReadFile(filepath.Join(root, "steamapps", "libraryfolders.vdf"))
+		if err != nil {
+			continue
+		}
+		doc, err := parseVDF(string(data))
+		if err != nil {
+			continue
+		}
+		folders, _ := lookupFold(doc, "libraryfolders").(map[string]any)
+		for _, v := range folders {
+			switch f := v.(type) {
+			case map[string]any:
+				// Current format: "0" { "path" "..." "apps" {...} }
+				if path := vdfString(f, "path"); path != "" {
+					libs = append(libs, path)
+				}
+			case string:
+				// Old format: "1" "D:\\SteamLibrary"
+				if strings.ContainsAny(f, `/\`) {
+					libs = append(libs, f)
+				}
+			}
+		}
+	}
+	return libs
+}
+
+// LibraryApps parses the app manifests of one library folder
+func LibraryApps(library string) ([]SteamApp, error) {
+	manifests, err := filepath.Glob(filepath.Join(library, "steamapps", "appmanifest_*.acf"))
+	if err != nil {
+		return nil, err
+	}
+	var apps []SteamApp
+	for _, m := range manifests {
+		data, err := os.ReadFile(m)
+		if err != nil {
+			continue
+		}
+		doc, err := parseVDF(string(data))
+		if err != nil {
+			return apps, fmt.Errorf("%s: %w", m, err)
+		}
+		installDir := vdfString(doc, "AppState", "installdir")
+		if installDir == "" {
+			continue
+		}
+		apps = append(apps, SteamApp{
+			AppID:      vdfString(doc, "AppState", "appid"),
+			Name:       vdfString(doc, "AppState", "name"),
+			InstallDir: filepath.Join(library, "steamapps", "common", installDir),
+		})
+	}
+	return apps, nil
+}
@@ -0,0 +1,87 @@
+package discovery
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// StoreIDs derives the store identifiers of a game from the path of one of
+// its executables: the Steam AppID when it lives under steamapps/common/<dir>
+// and the GOG product id from the goggame-<id>.info file of a GOG install.
+func StoreIDs(exePath string) map[string]string {
+	ids := map[string]string{}
+	if id := steamAppID(exePath); id != "" {
+		ids["steam"] = id
+	}
+	if id := gogProductID(exePath); id != "" {
+		ids["gog"] = id
+	}
+	return ids
+}
+
+// steamAppID matches the install folder of the executable against the
+// appmanifest files of its library
+func steamAppID(exePath string) string {
+	parts := strings.Split(filepath.ToSlash(exePath), "/")
+	for i := 0; i+2 < len(parts); i++ {
+		if !strings.EqualFold(parts[i], "steamapps") || !strings.EqualFold(parts[i+1], "common") {
+			continue
+		}
+		library := filepath.FromSlash(strings.Join(parts[:i], "/"))
+		if library == "" {
+			library = string(filepath.Separator)
+		}
+		dir := parts[i+2]
+		apps, _ := LibraryApps(library)
+		for _, a := range apps {
+			if strings.EqualFold(filepath.Base(a.InstallDir), dir) {
+				return a.AppID
+			}
+		}
+		return ""
+	}
+	return ""
+}
+
+// gogMaxDepth is how many parent folders are searched for goggame-*.info
+const gogMaxDepth = 3
+
+func gogProductID(exePath string) string {
+	dir := filepath.Dir(exePath)
+	for i := 0; i < gogMaxDepth; i++ {
+		infos, _ := filepath.Glob(filepath.Join(dir, "goggame-*.info"))
+		for _, f := range infos {
+			if id := gogInfoID(f); id != "" {
+				return id
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return ""
+}
+
+// gogInfoID reads the gameId of a goggame-<id>.info file; DLCs have their own
+// file naming the base game in rootGameId
+func gogInfoID(file string) string {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+	var info struct {
+		GameID     string `json:"gameId"`
+		RootGameID string `json:"rootGameId"`
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return ""
+	}
+	if info.RootGameID != "" && info.RootGameID != info.GameID {
+		return ""
+	}
+	return info.GameID
+}
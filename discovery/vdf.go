@@ -0,0 +1,155 @@
+package discovery
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseVDF parses Valve's text KeyValues format (libraryfolders.vdf, *.acf).
+// Values are either strings or nested map[string]any.
+func parseVDF(data string) (map[string]any, error) {
+	p := &vdfParser{data: data}
+	root, err := p.object(false)
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+type vdfParser struct {
+	data string
+	pos  int
+}
+
+func (p *vdfParser) object(nested bool) (map[string]any, error) {
+	obj := map[string]any{}
+	for {
+		tok, kind, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case tokEOF:
+			if nested {
+				return nil, fmt.Errorf("vdf: unexpected end of data")
+			}
+			return obj, nil
+		case tokClose:
+			if !nested {
+				return nil, fmt.Errorf("vdf: unexpected '}' at %d", p.pos)
+			}
+			return obj, nil
+		case tokOpen:
+			return nil, fmt.Errorf("vdf: unexpected '{' at %d", p.pos)
+		}
+		key := tok
+		val, vkind, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		switch vkind {
+		case tokString:
+			obj[key] = val
+		case tokOpen:
+			child, err := p.object(true)
+			if err != nil {
+				return nil, err
+			}
+			obj[key] = child
+		default:
+			return nil, fmt.Errorf("vdf: missing value for %q", key)
+		}
+	}
+}
+
+const (
+	tokEOF = iota
+	tokString
+	tokOpen
+	tokClose
+)
+
+func (p *vdfParser) next() (string, int, error) {
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			p.pos++
+		case strings.HasPrefix(p.data[p.pos:], "//"):
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == '{':
+			p.pos++
+			return "", tokOpen, nil
+		case c == '}':
+			p.pos++
+			return "", tokClose, nil
+		case c == '"':
+			return p.quoted()
+		default:
+			start := p.pos
+			for p.pos < len(p.data) && !strings.ContainsRune(" \t\r\n{}\"", rune(p.data[p.pos])) {
+				p.pos++
+			}
+			return p.data[start:p.pos], tokString, nil
+		}
+	}
+	return "", tokEOF, nil
+}
+
+func (p *vdfParser) quoted() (string, int, error) {
+	p.pos++ // opening quote
+	var b strings.Builder
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		switch c {
+		case '\\':
+			if p.pos+1 < len(p.data) {
+				p.pos++
+				switch p.data[p.pos] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				default:
+					b.WriteByte(p.data[p.pos])
+				}
+			}
+		case '"':
+			p.pos++
+			return b.String(), tokString, nil
+		default:
+			b.WriteByte(c)
+		}
+		p.pos++
+	}
+	return "", tokEOF, fmt.Errorf("vdf: unterminated string")
+}
+
+// vdfString reads a string value at the given key path
+func vdfString(obj map[string]any, keys ...string) string {
+	var cur any = obj
+	for _, k := range keys {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return ""
+		}
+		cur = lookupFold(m, k)
+	}
+	s, _ := cur.(string)
+	return s
+}
+
+// lookupFold finds a key case-insensitively, Valve files are not consistent
+func lookupFold(m map[string]any, key string) any {
+	if v, ok := m[key]; ok {
+		return v
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return nil
+}
@@ -2,11 +2,29 @@ package entity
 
 import "time"
 
+// Origin of ActivityRecord.StartTime
+const (
+	// StartSourceProcess: the OS-reported process creation time
+	StartSourceProcess = "process"
+	// StartSourceTracker: the tracker startup time, for games already running when it started
+	StartSourceTracker = "tracker"
+	// StartSourceDetected: the time the process was first noticed (creation time unknown)
+	StartSourceDetected = "detected"
+)
+
 type ActivityRecord struct {
 	ID          int64
 	ProcessName string
+	// GameID attributes the session to a game regardless of the executable
+	// mapping (launcher children), 0 to use the game of ProcessName
+	GameID      int64
 	WindowTitle string
 	StartTime   time.Time
 	EndTime     time.Time
 	Duration    time.Duration
+	StartSource string
+	// Idle lists the periods spent away from keyboard during the session
+	Idle []Interval
+	// Unfocused lists the periods where the game window was not in the foreground
+	Unfocused []Interval
 }
@@ -0,0 +1,61 @@
+package entity
+
+import (
+	"sort"
+	"time"
+)
+
+// Interval is a time range [Start, End)
+type Interval struct {
+	Start time.Time
+	End   time.Time
+}
+
+// OverlapWith returns the total duration of intervals falling inside [start, end)
+func OverlapWith(intervals []Interval, start, end time.Time) time.Duration {
+	var total time.Duration
+	for _, iv := range intervals {
+		s, e := iv.Start, iv.End
+		if s.Before(start) {
+			s = start
+		}
+		if e.After(end) {
+			e = end
+		}
+		if e.After(s) {
+			total += e.Sub(s)
+		}
+	}
+	return total
+}
+
+// UnionWithin returns the duration covered by at least one of the intervals
+// inside [start, end), overlapping intervals counting once
+func UnionWithin(intervals []Interval, start, end time.Time) time.Duration {
+	clipped := make([]Interval, 0, len(intervals))
+	for _, iv := range intervals {
+		if iv.Start.Before(start) {
+			iv.Start = start
+		}
+		if iv.End.After(end) {
+			iv.End = end
+		}
+		if iv.End.After(iv.Start) {
+			clipped = append(clipped, iv)
+		}
+	}
+	sort.Slice(clipped, func(i, j int) bool { return clipped[i].Start.Before(clipped[j].Start) })
+	var total time.Duration
+	var cur Interval
+	for i, iv := range clipped {
+		if i > 0 && !iv.Start.After(cur.End) {
+			if iv.End.After(cur.End) {
+				cur.End = iv.End
+			}
+			continue
+		}
+		total += cur.End.Sub(cur.Start)
+		cur = iv
+	}
+	return total + cur.End.Sub(cur.Start)
+}
@@ -0,0 +1,132 @@
+// Package events broadcasts what happens in the tracker (sessions starting
+// and ending, list changes, imports) to the clients of the web server.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Type is the kind of an event, the event name of the SSE stream
+type Type string
+
+const (
+	// SessionStarted is published when a game starts running, with the
+	// monitor.Playing of the session
+	SessionStarted Type = "session_started"
+	// SessionEnded is published when the last process of a game exits, with
+	// the monitor.Playing of the session
+	SessionEnded Type = "session_ended"
+	// ListChanged is published after a change of the whitelist, the blacklist
+	// or the rules
+	ListChanged Type = "list_changed"
+	// DataImported is published after an import or a restore replaced or
+	// merged the data
+	DataImported Type = "data_imported"
+	// LimitWarning is published when a play-time limit is about to be
+	// reached, with the limits.Budget of the limit
+	LimitWarning Type = "limit_warning"
+	// LimitExceeded is published when a limit is reached, and again when a
+	// game of an exceeded limit starts, with the limits.Budget of the limit
+	LimitExceeded Type = "limit_exceeded"
+	// LimitEnforced is published once the games of an exceeded limit were
+	// asked to exit, with the limits.Budget of the limit
+	LimitEnforced Type = "limit_enforced"
+	// CurfewViolation is published when a game runs outside its allowed
+	// windows, with the query.CurfewViolation logged
+	CurfewViolation Type = "curfew_violation"
+	// CurfewEnforced is published once a game running outside its allowed
+	// windows was asked to exit, with the query.CurfewViolation
+	CurfewEnforced Type = "curfew_enforced"
+	// FirstLaunch is published after SessionStarted when the game has no
+	// saved session yet, with the monitor.Playing of the session
+	FirstLaunch Type = "first_launch"
+	// GameFinished is published when a game is marked as finished, with
+	// {"game_id","game","finished_at"}
+	GameFinished Type = "game_finished"
+	// BackupFailed is published when an automatic backup can't be taken, with
+	// {"error"}
+	BackupFailed Type = "backup_failed"
+)
+
+// Event is a published event
+type Event struct {
+	Type Type      `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data,omitempty"`
+}
+
+// Bus fans the published events out to its subscribers and handlers. A nil
+// *Bus drops every event, so publishers don't have to check whether one is set.
+type Bus struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+
+	// hmu is held for reading while the handlers run, so that none runs once
+	// its removal returned
+	hmu      sync.RWMutex
+	handlers map[int]func(Event)
+	nextID   int
+}
+
+// NewBus returns a bus without subscribers
+func NewBus() *Bus {
+	return &Bus{subs: make(map[chan Event]struct{}), handlers: make(map[int]func(Event))}
+}
+
+// Publish sends an event to every subscriber without blocking: a subscriber
+// whose buffer is full misses it. The handlers are then called in turn.
+func (b *Bus) Publish(t Type, data any) {
+	if b == nil {
+		return
+	}
+	ev := Event{Type: t, Time: time.Now(), Data: data}
+	b.mu.Lock()
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+	b.mu.Unlock()
+
+	b.hmu.RLock()
+	defer b.hmu.RUnlock()
+	for _, fn := range b.handlers {
+		fn(ev)
+	}
+}
+
+// Handle calls fn from Publish for every event published from now on, until
+// the returned function is called. Unlike a subscription no event is missed,
+// but the publisher waits for fn, which must not publish.
+func (b *Bus) Handle(fn func(Event)) func() {
+	b.hmu.Lock()
+	id := b.nextID
+	b.nextID++
+	b.handlers[id] = fn
+	b.hmu.Unlock()
+	return func() {
+		b.hmu.Lock()
+		delete(b.handlers, id)
+		b.hmu.Unlock()
+	}
+}
+
+// Subscribe returns a channel receiving the events published from now on,
+// and the function to call once done with it
+func (b *Bus) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
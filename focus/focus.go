@@ -0,0 +1,54 @@
+package focus
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrUnsupported is returned when the foreground window cannot be queried
+var ErrUnsupported = errors.New("foreground window detection unsupported")
+
+// Window describes the window that currently has the input focus
+type Window struct {
+	PID   int32
+	Title string
+}
+
+// Provider reports the foreground window
+type Provider interface {
+	Foreground() (Window, error)
+}
+
+// NewDefault returns the provider of the platform, or nil when there is none
+func NewDefault() Provider {
+	p, err := NewSystemProvider()
+	if err != nil {
+		return nil
+	}
+	return p
+}
+
+// Fake is a Provider whose foreground window is set by hand
+type Fake struct {
+	mu  sync.Mutex
+	win Window
+	err error
+}
+
+func (f *Fake) Foreground() (Window, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.win, f.err
+}
+
+func (f *Fake) Set(w Window) {
+	f.mu.Lock()
+	f.win, f.err = w, nil
+	f.mu.Unlock()
+}
+
+func (f *Fake) SetError(err error) {
+	f.mu.Lock()
+	f.err = err
+	f.mu.Unlock()
+}
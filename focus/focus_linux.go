@@ -0,0 +1,42 @@
+//go:build linux
+
+package focus
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// XdotoolProvider queries the active X11 window with xdotool. Wayland
+// compositors do not expose the focused window to other clients.
+type XdotoolProvider struct{}
+
+func NewSystemProvider() (Provider, error) {
+	if _, err := exec.LookPath("xdotool"); err != nil {
+		return nil, ErrUnsupported
+	}
+	p := XdotoolProvider{}
+	if _, err := p.Foreground(); err != nil {
+		return nil, ErrUnsupported
+	}
+	return p, nil
+}
+
+func (XdotoolProvider) Foreground() (Window, error) {
+	out, err := exec.Command("xdotool", "getactivewindow", "getwindowpid", "getwindowname").Output()
+	if err != nil {
+		return Window{}, fmt.Errorf("xdotool: %w", err)
+	}
+	lines := strings.SplitN(strings.TrimRight(string(out), "\n"), "\n", 2)
+	pid, err := strconv.ParseInt(strings.TrimSpace(lines[0]), 10, 32)
+	if err != nil {
+		return Window{}, fmt.Errorf("xdotool: unexpected output %q", out)
+	}
+	w := Window{PID: int32(pid)}
+	if len(lines) > 1 {
+		w.Title = lines[1]
+	}
+	return w, nil
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !windows
+
+package focus
+
+func NewSystemProvider() (Provider, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,42 @@
+//go:build windows
+
+package focus
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	user32                       = windows.NewLazySystemDLL("user32.dll")
+	procGetForegroundWindow      = user32.NewProc("GetForegroundWindow")
+	procGetWindowThreadProcessId = user32.NewProc("GetWindowThreadProcessId")
+	procGetWindowTextW           = user32.NewProc("GetWindowTextW")
+)
+
+// Win32Provider queries the foreground window through user32
+type Win32Provider struct{}
+
+func NewSystemProvider() (Provider, error) {
+	if err := procGetForegroundWindow.Find(); err != nil {
+		return nil, ErrUnsupported
+	}
+	return Win32Provider{}, nil
+}
+
+func (Win32Provider) Foreground() (Window, error) {
+	hwnd, _, _ := procGetForegroundWindow.Call()
+	if hwnd == 0 {
+		// No window has the focus, e.g. during a UAC prompt or a lock
+		return Window{}, nil
+	}
+	var pid uint32
+	if tid, _, err := procGetWindowThreadProcessId.Call(hwnd, uintptr(unsafe.Pointer(&pid))); tid == 0 {
+		return Window{}, fmt.Errorf("GetWindowThreadProcessId: %w", err)
+	}
+	buf := make([]uint16, 512)
+	n, _, _ := procGetWindowTextW.Call(hwnd, uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+	return Window{PID: int32(pid), Title: windows.UTF16ToString(buf[:n])}, nil
+}
@@ -0,0 +1,65 @@
+package idle
+
+import (
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/cpu"
+)
+
+// Heuristic guesses idleness when no reliable input source exists: the user
+// is considered away once the system CPU usage has stayed under CPUThreshold.
+// A running game keeps the CPU busy even when nobody plays it, so this only
+// detects a game that is suspended or paused in the background, not a user
+// away from a game that keeps running. When Input is set and answers, its
+// idle time is used and the CPU is only the fallback.
+type Heuristic struct {
+	Input        Detector
+	CPUThreshold float64 // percent
+
+	mu       sync.Mutex
+	lowSince time.Time
+	cpuUsage func() (float64, error)
+}
+
+func NewHeuristic(input Detector) *Heuristic {
+	return &Heuristic{
+		Input:        input,
+		CPUThreshold: 10,
+		cpuUsage:     systemCPU,
+	}
+}
+
+func systemCPU() (float64, error) {
+	// Interval 0 compares with the previous call
+	values, err := cpu.Percent(0, false)
+	if err != nil || len(values) == 0 {
+		return 0, err
+	}
+	return values[0], nil
+}
+
+func (h *Heuristic) IdleTime() (time.Duration, error) {
+	if h.Input != nil {
+		if inputIdle, err := h.Input.IdleTime(); err == nil {
+			return inputIdle, nil
+		}
+	}
+	usage, err := h.cpuUsage()
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if usage >= h.CPUThreshold {
+		h.lowSince = time.Time{}
+	} else if h.lowSince.IsZero() {
+		h.lowSince = now
+	}
+	if h.lowSince.IsZero() {
+		return 0, nil
+	}
+	return now.Sub(h.lowSince), nil
+}
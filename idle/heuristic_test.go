@@ -0,0 +1,43 @@
+package idle
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHeuristicPrefersInput(t *testing.T) {
+	input := &Fake{}
+	usage := 2.0
+	h := NewHeuristic(input)
+	h.cpuUsage = func() (float64, error) { return usage, nil }
+
+	// The CPU is quiet but the user is typing
+	h.lowSince = time.Now().Add(-time.Hour)
+	input.Set(time.Second)
+	if got, err := h.IdleTime(); err != nil || got != time.Second {
+		t.Errorf("idle %v, %v with input, want 1s", got, err)
+	}
+	// The game keeps the CPU busy while the user is away
+	usage = 80
+	input.Set(10 * time.Minute)
+	if got, err := h.IdleTime(); err != nil || got != 10*time.Minute {
+		t.Errorf("idle %v, %v with input, want 10m", got, err)
+	}
+
+	// Without input the CPU decides
+	input.SetError(ErrUnsupported)
+	if got, err := h.IdleTime(); err != nil || got != 0 {
+		t.Errorf("idle %v, %v with a busy CPU, want 0", got, err)
+	}
+	usage = 2
+	h.IdleTime()
+	h.lowSince = h.lowSince.Add(-time.Minute)
+	if got, err := h.IdleTime(); err != nil || got < time.Minute {
+		t.Errorf("idle %v, %v with a quiet CPU, want a minute", got, err)
+	}
+	h.cpuUsage = func() (float64, error) { return 0, errors.New("no cpu") }
+	if _, err := h.IdleTime(); err == nil {
+		t.Error("no error without any source")
+	}
+}
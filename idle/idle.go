@@ -0,0 +1,49 @@
+package idle
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrUnsupported is returned when no idle source is available on this system
+var ErrUnsupported = errors.New("idle detection unsupported")
+
+// Detector reports for how long the user has been away
+type Detector interface {
+	IdleTime() (time.Duration, error)
+}
+
+// NewDefault returns the session detector of the platform when there is one,
+// and the CPU heuristic otherwise
+func NewDefault() Detector {
+	if d, err := NewSystemDetector(); err == nil {
+		return d
+	}
+	return NewHeuristic(nil)
+}
+
+// Fake is a Detector whose idle time is set by hand
+type Fake struct {
+	mu   sync.Mutex
+	idle time.Duration
+	err  error
+}
+
+func (f *Fake) IdleTime() (time.Duration, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.idle, f.err
+}
+
+func (f *Fake) Set(d time.Duration) {
+	f.mu.Lock()
+	f.idle, f.err = d, nil
+	f.mu.Unlock()
+}
+
+func (f *Fake) SetError(err error) {
+	f.mu.Lock()
+	f.err = err
+	f.mu.Unlock()
+}
@@ -0,0 +1,67 @@
+//go:build linux
+
+package idle
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sessionCommands print the idle time of the session, in unit
+var sessionCommands = []struct {
+	name string
+	args []string
+	unit time.Duration
+}{
+	// GNOME (X11 and Wayland)
+	{"gdbus", []string{"call", "--session", "--dest", "org.gnome.Mutter.IdleMonitor",
+		"--object-path", "/org/gnome/Mutter/IdleMonitor/Core",
+		"--method", "org.gnome.Mutter.IdleMonitor.GetIdletime"}, time.Millisecond},
+	// KDE Plasma and other freedesktop screensavers (X11 and Wayland)
+	{"gdbus", []string{"call", "--session", "--dest", "org.freedesktop.ScreenSaver",
+		"--object-path", "/org/freedesktop/ScreenSaver",
+		"--method", "org.freedesktop.ScreenSaver.GetSessionIdleTime"}, time.Millisecond},
+	// Plain X11
+	{"xprintidle", nil, time.Millisecond},
+}
+
+// lastNumber skips type annotations such as the "64" of "(uint64 1234,)"
+var lastNumber = regexp.MustCompile(`(\d+)\D*$`)
+
+// SessionDetector asks the desktop session for the time since the last input
+type SessionDetector struct {
+	name string
+	args []string
+	unit time.Duration
+}
+
+// NewSystemDetector returns the first session idle source that answers
+func NewSystemDetector() (Detector, error) {
+	for _, c := range sessionCommands {
+		d := &SessionDetector{name: c.name, args: c.args, unit: c.unit}
+		if _, err := d.IdleTime(); err == nil {
+			return d, nil
+		}
+	}
+	return nil, ErrUnsupported
+}
+
+func (d *SessionDetector) IdleTime() (time.Duration, error) {
+	out, err := exec.Command(d.name, d.args...).Output()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", d.name, err)
+	}
+	m := lastNumber.FindStringSubmatch(strings.TrimSpace(string(out)))
+	if m == nil {
+		return 0, fmt.Errorf("%s: unexpected output %q", d.name, out)
+	}
+	v, err := strconv.ParseUint(m[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", d.name, err)
+	}
+	return time.Duration(v) * d.unit, nil
+}
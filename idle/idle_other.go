@@ -0,0 +1,7 @@
+//go:build !linux && !windows
+
+package idle
+
+func NewSystemDetector() (Detector, error) {
+	return nil, ErrUnsupported
+}
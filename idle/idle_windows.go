@@ -0,0 +1,44 @@
+//go:build windows
+
+package idle
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	user32               = windows.NewLazySystemDLL("user32.dll")
+	kernel32             = windows.NewLazySystemDLL("kernel32.dll")
+	procGetLastInputInfo = user32.NewProc("GetLastInputInfo")
+	procGetTickCount     = kernel32.NewProc("GetTickCount")
+)
+
+type lastInputInfo struct {
+	cbSize uint32
+	dwTime uint32
+}
+
+// LastInputDetector uses GetLastInputInfo, which covers keyboard, mouse and gamepads
+type LastInputDetector struct{}
+
+func NewSystemDetector() (Detector, error) {
+	if err := procGetLastInputInfo.Find(); err != nil {
+		return nil, ErrUnsupported
+	}
+	return LastInputDetector{}, nil
+}
+
+func (LastInputDetector) IdleTime() (time.Duration, error) {
+	info := lastInputInfo{cbSize: uint32(unsafe.Sizeof(lastInputInfo{}))}
+	ok, _, err := procGetLastInputInfo.Call(uintptr(unsafe.Pointer(&info)))
+	if ok == 0 {
+		return 0, fmt.Errorf("GetLastInputInfo: %w", err)
+	}
+	now, _, _ := procGetTickCount.Call()
+	// Both counters wrap around after 49.7 days, unsigned arithmetic handles it
+	return time.Duration(uint32(now)-info.dwTime) * time.Millisecond, nil
+}
@@ -0,0 +1,275 @@
+package launch
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"main/backup"
+	"main/curfew"
+	"main/events"
+	"main/focus"
+	"main/idle"
+	"main/limits"
+	"main/manager"
+	"main/monitor"
+	"main/notify"
+	"main/query"
+	"main/settings"
+	"main/web"
+	"main/webhook"
+)
+
+// Options are the command line options of the application
+type Options struct {
+	// DataDir overrides the data folder
+	DataDir string
+	// Headless runs without the tray icon, until SIGINT/SIGTERM
+	Headless bool
+	// LogFile receives the logs instead of the console
+	LogFile string
+}
+
+// application owns every long-lived component so they can be stopped in order
+type application struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	opts   Options
+
+	mu       sync.Mutex
+	db       *query.Database
+	monitor  *monitor.ProcessMonitor
+	server   *http.Server
+	settings *settings.Store
+	notifier *notify.Dispatcher
+
+	// warn shows a limit or curfew warning in the tray, nil without tray; set
+	// before run
+	warn func(msg string)
+	// warnings are the current tray warnings by source
+	warnMu   sync.Mutex
+	warnings map[string]string
+
+	// stopped is closed once the monitor loop has returned
+	stopped      chan struct{}
+	shutdownOnce sync.Once
+	// stopConsumers stops the notifications and the webhooks, after the
+	// sessions closed at shutdown were published; consumers waits for them
+	stopConsumers context.CancelFunc
+	consumers     sync.WaitGroup
+}
+
+// newApplication cancels its context on SIGINT/SIGTERM. On Windows the tray
+// receives WM_ENDSESSION when the user session ends and calls onExit itself.
+func newApplication() *application {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	return &application{
+		ctx:           ctx,
+		cancel:        cancel,
+		stopped:       make(chan struct{}),
+		stopConsumers: func() {},
+	}
+}
+
+// run opens the database, starts the web server and tracks processes until shutdown
+func (a *application) run() {
+	defer close(a.stopped)
+
+	// Data folder: --data-dir, WGT_DATA_DIR, data_dir of the config file, or
+	// the folder of the platform
+	dataDir, origin, configFile, err := settings.ResolveDataDir(a.opts.DataDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Dossier des données: %s (%s)\n", dataDir, origin)
+	db, err := query.OpenDatabase(dataDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, err := settings.Load(db.DB, configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	source := monitor.NewDefaultSource()
+	processMonitor := monitor.NewProcessMonitor(db, source)
+	processMonitor.Idle = idle.NewDefault()
+	// Live events for the web UI and the notifications
+	bus := events.NewBus()
+	processMonitor.Events = bus
+	backups := backup.New(db, dataDir)
+	backups.Events = bus
+	notifier := notify.NewDispatcher(db, bus, notify.NewDefault())
+	apply := func(cfg settings.Settings) {
+		applySettings(cfg, db, processMonitor, source, backups, notifier)
+	}
+	apply(store.Get())
+	store.OnChange(apply)
+	processMonitor.Focus = focus.NewDefault()
+	lm, err := manager.NewListManager(db.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	lm.OnChange(func() { bus.Publish(events.ListChanged, nil) })
+	enforcer := limits.New(db, processMonitor, bus)
+	enforcer.Warn = func(msg string) { a.setWarning("limits", msg) }
+	// Set as the guard of the monitor, before it runs
+	curfews := curfew.New(db, processMonitor, bus)
+	curfews.Warn = func(msg string) { a.setWarning("curfew", msg) }
+	webhooks := webhook.New(db, bus)
+
+	a.mu.Lock()
+	a.db = db
+	a.monitor = processMonitor
+	a.settings = store
+	a.notifier = notifier
+	// Start web server
+	a.server = web.StartServer(db, lm, store, backups, processMonitor, bus, enforcer, curfews, webhooks)
+	// The events of the sessions closed by shutdown come after a.ctx is done
+	consumers, stopConsumers := context.WithCancel(context.Background())
+	a.stopConsumers = stopConsumers
+	a.consumers.Add(2)
+	a.mu.Unlock()
+	go backups.Run(a.ctx)
+	go func() { defer a.consumers.Done(); notifier.Run(consumers) }()
+	go func() { defer a.consumers.Done(); webhooks.Run(consumers) }()
+	go enforcer.Run(a.ctx)
+
+	if err := processMonitor.Run(a.ctx, lm); err != nil {
+		log.Println(err)
+	}
+}
+
+// setWarning replaces the tray warning of source, the warnings of the limits
+// and of the curfews being shown together
+func (a *application) setWarning(source, msg string) {
+	if a.warn == nil {
+		return
+	}
+	a.warnMu.Lock()
+	defer a.warnMu.Unlock()
+	if a.warnings == nil {
+		a.warnings = make(map[string]string)
+	}
+	a.warnings[source] = msg
+	var all []string
+	for _, s := range []string{"limits", "curfew"} {
+		if a.warnings[s] != "" {
+			all = append(all, a.warnings[s])
+		}
+	}
+	a.warn(strings.Join(all, "\n"))
+}
+
+// applySettings hands the settings to the components that can change them live
+func applySettings(cfg settings.Settings, db *query.Database, pm *monitor.ProcessMonitor, source monitor.ProcessSource, backups *backup.Manager, notifier *notify.Dispatcher) {
+	policy, err := monitor.ParseStartPolicy(cfg.StartPolicy)
+	if err != nil {
+		log.Println(err)
+		policy = monitor.StartFromCreateTime
+	}
+	pm.Configure(policy, time.Duration(cfg.HeartbeatSeconds)*time.Second, time.Duration(cfg.IdleMinutes)*time.Minute)
+	if t, ok := source.(monitor.Tunable); ok {
+		t.SetIntervals(time.Duration(cfg.ScanIntervalMs)*time.Millisecond, time.Duration(cfg.ExitIntervalMs)*time.Millisecond)
+	}
+	db.SetSessionRules(query.SessionRules{
+		MinDuration: time.Duration(cfg.MinSessionSeconds) * time.Second,
+		MergeGap:    time.Duration(cfg.MergeGapMinutes) * time.Minute,
+	})
+	backups.SetPolicy(backup.Policy{
+		Interval: time.Duration(cfg.BackupIntervalHours) * time.Hour,
+		Daily:    cfg.BackupKeepDaily,
+		Weekly:   cfg.BackupKeepWeekly,
+		Monthly:  cfg.BackupKeepMonthly,
+	})
+	notifier.Configure(notify.Categories{
+		Sessions:   cfg.NotifySessions,
+		Limits:     cfg.NotifyLimits,
+		Milestones: cfg.NotifyMilestones,
+		Backups:    cfg.NotifyBackups,
+	})
+}
+
+// about shows the version of the application and the address of its web UI
+func (a *application) about() {
+	a.mu.Lock()
+	n := a.notifier
+	a.mu.Unlock()
+	msg := notify.Notification{Title: "SteamStracker", Body: "Suivi du temps de jeu\nInterface : " + a.webURL()}
+	// A message box on Windows, which has no desktop notifier
+	if err := notify.Dialog(msg); err == nil {
+		return
+	} else if !errors.Is(err, notify.ErrUnsupported) {
+		log.Println("À propos:", err)
+	}
+	if n == nil {
+		// Not started yet
+		_ = notify.NewDefault().Notify(msg)
+		return
+	}
+	n.Notify(msg)
+}
+
+// webURL is the address of the web UI, for the tray menu
+func (a *application) webURL() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	addr := settings.Defaults().ListenAddr
+	if a.settings != nil {
+		addr = a.settings.Get().ListenAddr
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err == nil && (host == "" || host == "0.0.0.0" || host == "::") {
+		addr = net.JoinHostPort("localhost", port)
+	}
+	return "http://" + addr
+}
+
+// shutdown stops the monitor loop, closes running sessions at the current
+// time, lets the notifications and the webhooks handle their end, stops the
+// web server and closes the database. Safe to call twice.
+func (a *application) shutdown() {
+	a.shutdownOnce.Do(func() {
+		log.Println("Arrêt en cours...")
+		a.cancel()
+		select {
+		case <-a.stopped:
+		case <-time.After(10 * time.Second):
+			log.Println("shutdown: la boucle de surveillance ne s'est pas arrêtée")
+		}
+		now := time.Now()
+
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.monitor != nil {
+			a.monitor.CloseSessions(now)
+		}
+		a.stopConsumers()
+		done := make(chan struct{})
+		go func() { a.consumers.Wait(); close(done) }()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			log.Println("shutdown: notifications et webhooks non terminés")
+		}
+		if a.server != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := a.server.Shutdown(ctx); err != nil {
+				log.Println("shutdown serveur web:", err)
+			}
+		}
+		if a.db != nil {
+			if err := a.db.Close(); err != nil {
+				log.Println("shutdown base de données:", err)
+			}
+		}
+	})
+}
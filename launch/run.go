@@ -0,0 +1,44 @@
+package launch
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+var app = newApplication()
+
+// Run starts the tracker and the web server, with the tray icon unless
+// opts.Headless is set, and returns once they are stopped
+func Run(opts Options) error {
+	app.opts = opts
+	if opts.LogFile != "" {
+		f, err := os.OpenFile(opts.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("log file: %w", err)
+		}
+		defer f.Close()
+		log.SetOutput(f)
+		// Messages printed with fmt go to the log file too
+		os.Stdout = f
+	} else if opts.Headless {
+		// stdout is what a service manager such as systemd collects
+		log.SetOutput(os.Stdout)
+	}
+	if !opts.Headless {
+		return runTray()
+	}
+	return runHeadless()
+}
+
+// runHeadless tracks processes until SIGINT/SIGTERM
+func runHeadless() error {
+	log.Println("Démarrage sans icône de notification")
+	go app.run()
+	select {
+	case <-app.ctx.Done():
+	case <-app.stopped:
+	}
+	app.shutdown()
+	return nil
+}
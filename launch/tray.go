@@ -0,0 +1,88 @@
+//go:build !notray
+
+package launch
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/getlantern/systray"
+)
+
+// TraySupported tells whether this build has the system tray (not built with the notray tag)
+const TraySupported = true
+
+// runTray shows the tray icon and tracks processes until the tray is closed
+func runTray() error {
+	// A signal closes the tray, which triggers onExit
+	go func() {
+		<-app.ctx.Done()
+		systray.Quit()
+	}()
+	systray.Run(onReady, onExit)
+	return nil
+}
+
+// trayTooltip is the tooltip of the icon without limit or curfew warning
+const trayTooltip = "J'observe tes jeux"
+
+func onReady() {
+	// Définir l'icône de l'application
+	// Vous devez remplacer "icon.ico" par le chemin vers votre fichier d'icône
+	icon, err := os.ReadFile("./icon.ico")
+	if err == nil {
+		systray.SetIcon(icon)
+	}
+
+	// Définir le titre de l'icône (visible au survol)
+	systray.SetTitle("SteamStracker")
+	systray.SetTooltip(trayTooltip)
+
+	// Les limites de temps de jeu atteintes et les horaires dépassés s'affichent
+	// au survol de l'icône
+	app.warn = func(msg string) {
+		if msg == "" {
+			systray.SetTooltip(trayTooltip)
+			return
+		}
+		systray.SetTooltip(msg)
+	}
+	go app.run()
+
+	// Ajouter des éléments de menu
+	mOpenWeb := systray.AddMenuItem("Ouvrir l'interface Web", "Ouvrir l'interface dans le navigateur")
+	mQuit := systray.AddMenuItem("Quitter", "Quitter l'application")
+	mInfo := systray.AddMenuItem("À propos", "Informations sur l'application")
+
+	// Créer une goroutine pour gérer les clics sur les éléments de menu
+	go func() {
+		for {
+			select {
+			case <-mOpenWeb.ClickedCh:
+				_ = exec.Command("rundll32", "url.dll,FileProtocolHandler", app.webURL()).Start()
+			case <-mQuit.ClickedCh:
+				systray.Quit()
+				return
+			case <-mInfo.ClickedCh:
+				// Affiché par une notification du bureau
+				go app.about()
+			}
+		}
+	}()
+}
+
+func onExit() {
+	// Clôturer les sessions en cours et libérer les ressources
+	app.shutdown()
+}
+
+// Formater la durée de façon plus lisible
+func formatDuration(d time.Duration) string {
+	hours := int(d.Hours())
+	minutes := int(d.Minutes()) % 60
+	seconds := int(d.Seconds()) % 60
+
+	return fmt.Sprintf("%dh %dm %ds", hours, minutes, seconds)
+}
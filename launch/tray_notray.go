@@ -0,0 +1,12 @@
+//go:build notray
+
+package launch
+
+import "errors"
+
+// TraySupported tells whether this build has the system tray (not built with the notray tag)
+const TraySupported = false
+
+func runTray() error {
+	return errors.New("built without system tray support (notray), use run --headless")
+}
@@ -0,0 +1,327 @@
+// Package limits checks the play time of the running and stored sessions
+// against the limits of the database and applies their action when one is
+// reached.
+package limits
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"main/entity"
+	"main/events"
+	"main/monitor"
+	"main/query"
+)
+
+const (
+	// checkInterval is the delay between two checks of the limits
+	checkInterval = 30 * time.Second
+	// warnBefore is the remaining time under which a limit warning is published
+	warnBefore = 5 * time.Minute
+)
+
+// Budget is the consumption of a limit over its current period
+type Budget struct {
+	Limit query.Limit `json:"limit"`
+	// PeriodStart is the start of the current day or week
+	PeriodStart time.Time `json:"period_start"`
+	// Active is unset for a disabled limit, or a daily one not applying today
+	Active bool `json:"active"`
+	// Used and Remaining are in seconds, stored and running sessions together
+	Used      float64 `json:"used"`
+	Remaining float64 `json:"remaining"`
+	Exceeded  bool    `json:"exceeded"`
+	// Running lists the running games counted by the limit
+	Running []string `json:"running"`
+	// TerminateAt is when the running games of an exceeded limit are stopped
+	TerminateAt *time.Time `json:"terminate_at,omitempty"`
+}
+
+// state is what is known of a limit over one period between two checks
+type state struct {
+	warned   bool
+	exceeded bool
+	// running is set when a game of the limit was running at the last check
+	running bool
+	// graceFrom is the start of the grace period, zero until a game runs
+	// while the limit is exceeded
+	graceFrom time.Time
+}
+
+// Enforcer checks the limits while the monitor runs
+type Enforcer struct {
+	db  *query.Database
+	pm  *monitor.ProcessMonitor
+	bus *events.Bus
+
+	// Warn shows a warning in the tray, an empty message clearing it; nil
+	// when there is no tray
+	Warn func(msg string)
+
+	mu sync.Mutex
+	// states is keyed by limit id and period start
+	states  map[string]*state
+	warning string
+	// wake triggers a check, after a change of the limits
+	wake chan struct{}
+}
+
+// New returns the enforcer of the limits of db over the sessions of pm,
+// publishing on bus
+func New(db *query.Database, pm *monitor.ProcessMonitor, bus *events.Bus) *Enforcer {
+	return &Enforcer{
+		db:     db,
+		pm:     pm,
+		bus:    bus,
+		states: make(map[string]*state),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Changed checks the limits right away, to be called once they are modified
+func (e *Enforcer) Changed() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run checks the limits regularly and when a game starts, until ctx is done
+func (e *Enforcer) Run(ctx context.Context) {
+	sub, cancel := e.bus.Subscribe(16)
+	defer cancel()
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	// deadline fires at the end of the next grace period
+	var deadline <-chan time.Time
+	schedule := func() {
+		deadline = nil
+		if next := e.check(time.Now()); !next.IsZero() {
+			deadline = time.After(time.Until(next))
+		}
+	}
+	schedule()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-deadline:
+		case <-e.wake:
+		case ev := <-sub:
+			if ev.Type != events.SessionStarted && ev.Type != events.DataImported {
+				continue
+			}
+		}
+		schedule()
+	}
+}
+
+// Status returns the consumption of every limit at now
+func (e *Enforcer) Status(now time.Time) ([]Budget, error) {
+	budgets, err := e.budgets(now)
+	if err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i := range budgets {
+		if st, ok := e.states[stateKey(budgets[i])]; ok {
+			budgets[i].TerminateAt = terminateAt(budgets[i], st)
+		}
+	}
+	return budgets, nil
+}
+
+// budgets sums up the play time counted by each limit at now
+func (e *Enforcer) budgets(now time.Time) ([]Budget, error) {
+	limits, err := e.db.GetLimits()
+	if err != nil {
+		return nil, fmt.Errorf("limits: %w", err)
+	}
+	playing := e.pm.NowPlaying(now)
+	// Stored play time of each game since the start of a period
+	stored := map[time.Time]map[int64]float64{}
+	out := make([]Budget, 0, len(limits))
+	for _, l := range limits {
+		start := l.PeriodStart(now)
+		perGame, ok := stored[start]
+		if !ok {
+			items, err := e.db.GetSummaryBetween(start.Format("2006-01-02"), now.Format("2006-01-02"), query.MetricTotal)
+			if err != nil {
+				return nil, fmt.Errorf("limits: %w", err)
+			}
+			perGame = make(map[int64]float64, len(items))
+			for _, it := range items {
+				perGame[it.GameID] = it.Seconds
+			}
+			stored[start] = perGame
+		}
+		b := Budget{Limit: l, PeriodStart: start, Active: l.Enabled && l.AppliesOn(now), Running: []string{}}
+		if l.GameID == 0 {
+			// Games played at the same time count once for all games together
+			used, err := e.wallClock(start, now, playing)
+			if err != nil {
+				return nil, err
+			}
+			b.Used = used
+		}
+		for id, sec := range perGame {
+			if id == l.GameID {
+				b.Used += sec
+			}
+		}
+		for _, p := range playing {
+			if l.GameID != 0 && p.GameID != l.GameID {
+				continue
+			}
+			if l.GameID != 0 {
+				// A session started before the period only counts from its start
+				b.Used += min(p.Elapsed, now.Sub(start).Seconds())
+			}
+			b.Running = append(b.Running, p.Game)
+		}
+		allowed := float64(l.Minutes * 60)
+		b.Remaining = max(0, allowed-b.Used)
+		b.Exceeded = b.Active && b.Used >= allowed
+		out = append(out, b)
+	}
+	return out, nil
+}
+
+// wallClock returns the time at least one game was played between start and
+// now, stored and running sessions together
+func (e *Enforcer) wallClock(start, now time.Time, playing []monitor.Playing) (float64, error) {
+	rows, err := e.db.GetIntervalsBetween(start.Format("2006-01-02"), now.Format("2006-01-02"))
+	if err != nil {
+		return 0, fmt.Errorf("limits: %w", err)
+	}
+	spans := make([]entity.Interval, 0, len(rows)+len(playing))
+	for _, r := range rows {
+		from, err1 := time.Parse(time.RFC3339, r.StartTime)
+		to, err2 := time.Parse(time.RFC3339, r.EndTime)
+		if err1 == nil && err2 == nil {
+			// A session merged over a gap is counted for its played time only
+			if played := from.Add(time.Duration(r.Seconds * float64(time.Second))); played.Before(to) {
+				to = played
+			}
+			spans = append(spans, entity.Interval{Start: from, End: to})
+		}
+	}
+	for _, p := range playing {
+		spans = append(spans, entity.Interval{Start: p.StartTime, End: now})
+	}
+	return entity.UnionWithin(spans, start, now).Seconds(), nil
+}
+
+// check publishes the warnings and breaches and stops the games whose grace
+// period is over. It returns the end of the next grace period, zero if none.
+func (e *Enforcer) check(now time.Time) (next time.Time) {
+	budgets, err := e.budgets(now)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	seen := make(map[string]bool, len(budgets))
+	var warnings []string
+	for _, b := range budgets {
+		if !b.Active {
+			continue
+		}
+		key := stateKey(b)
+		seen[key] = true
+		st, ok := e.states[key]
+		if !ok {
+			st = &state{}
+			e.states[key] = st
+		}
+		running := len(b.Running) > 0
+		switch {
+		case b.Exceeded:
+			// Reached now, or a game of the limit starts again: a new grace period
+			if !st.exceeded || (running && !st.running) {
+				st.exceeded = true
+				st.graceFrom = time.Time{}
+				if running {
+					st.graceFrom = now
+				}
+				b.TerminateAt = terminateAt(b, st)
+				e.bus.Publish(events.LimitExceeded, b)
+				log.Println(b.Message("Limite atteinte"))
+			}
+			if b.Limit.Action != query.ActionNotify {
+				warnings = append(warnings, b.Message("Limite atteinte"))
+			}
+			if at := terminateAt(b, st); at != nil && !now.Before(*at) {
+				n, err := e.pm.TerminateGame(b.Limit.GameID)
+				if err != nil {
+					log.Println("limits:", err)
+				}
+				if n > 0 {
+					e.bus.Publish(events.LimitEnforced, b)
+					log.Printf("%s, %d processus arrêté(s)\n", b.Message("Limite dépassée"), n)
+				}
+			} else if at != nil && (next.IsZero() || at.Before(next)) {
+				next = *at
+			}
+		case st.exceeded:
+			// The limit was raised
+			*st = state{}
+		case running && !st.warned && b.Remaining <= warnBefore.Seconds():
+			st.warned = true
+			e.bus.Publish(events.LimitWarning, b)
+			log.Println(b.Message("Limite bientôt atteinte"))
+		}
+		st.running = running
+	}
+	// The periods that are over
+	for key := range e.states {
+		if !seen[key] {
+			delete(e.states, key)
+		}
+	}
+
+	warning := strings.Join(warnings, "\n")
+	if warning != e.warning && e.Warn != nil {
+		e.Warn(warning)
+	}
+	e.warning = warning
+	return next
+}
+
+func stateKey(b Budget) string {
+	return fmt.Sprintf("%d/%s", b.Limit.ID, b.PeriodStart.Format("2006-01-02"))
+}
+
+// terminateAt returns when the running games of b are stopped, nil if they aren't
+func terminateAt(b Budget, st *state) *time.Time {
+	if b.Limit.Action != query.ActionTerminate || !b.Exceeded || len(b.Running) == 0 || st.graceFrom.IsZero() {
+		return nil
+	}
+	at := st.graceFrom.Add(time.Duration(b.Limit.GraceSeconds) * time.Second)
+	return &at
+}
+
+// Message describes the limit of b for the log, the tray and the notifications
+func (b Budget) Message(prefix string) string {
+	scope := b.Limit.Game
+	if b.Limit.GameID == 0 {
+		scope = "tous les jeux"
+	}
+	period := "aujourd'hui"
+	if b.Limit.Period == query.LimitWeek {
+		period = "cette semaine"
+	}
+	return fmt.Sprintf("%s : %s, %s sur %d min %s", prefix, scope, formatMinutes(b.Used), b.Limit.Minutes, period)
+}
+
+func formatMinutes(seconds float64) string {
+	return fmt.Sprintf("%d min", int(seconds/60))
+}
@@ -0,0 +1,65 @@
+package limits
+
+import (
+	"testing"
+	"time"
+
+	"main/entity"
+	"main/events"
+	"main/monitor"
+	"main/query"
+)
+
+func TestGlobalLimitCountsConcurrentGamesOnce(t *testing.T) {
+	db, err := query.OpenDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	pm := monitor.NewProcessMonitor(db, monitor.NewFakeSource())
+	e := New(db, pm, events.NewBus())
+
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.Local)
+	at := func(ago time.Duration) time.Time { return now.Add(-ago) }
+	// a.exe for 90 minutes, b.exe for 30 of them, then c.exe running for the
+	// last 20 minutes, 10 of them with a.exe
+	for _, s := range []struct {
+		name     string
+		from, to time.Time
+	}{{"a.exe", at(100 * time.Minute), at(10 * time.Minute)}, {"b.exe", at(90 * time.Minute), at(60 * time.Minute)}} {
+		if err := db.SaveActivity(entity.ActivityRecord{ProcessName: s.name, StartTime: s.from, EndTime: s.to}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := db.EnsureGame("c.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := monitor.ProcessInfo{PID: 100, Name: "c.exe", Exe: "/games/c.exe", CreateTime: at(20 * time.Minute)}
+	if err := pm.StartTracking(p, c, false, at(20*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []query.Limit{{Period: query.LimitDay, Minutes: 120}, {GameID: c, Period: query.LimitDay, Minutes: 120}} {
+		if _, err := db.SaveLimit(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	budgets, err := e.budgets(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(budgets) != 2 {
+		t.Fatalf("%d budgets, want 2", len(budgets))
+	}
+	// 100 minutes ago to now, once
+	if got, want := budgets[0].Used, now.Sub(at(100*time.Minute)).Seconds(); got != want {
+		t.Errorf("all games used %v s, want %v", got, want)
+	}
+	if got, want := budgets[1].Used, now.Sub(at(20*time.Minute)).Seconds(); got != want {
+		t.Errorf("c.exe used %v s, want %v", got, want)
+	}
+	if len(budgets[0].Running) != 1 || budgets[0].Running[0] != "c.exe" {
+		t.Errorf("running %v, want c.exe", budgets[0].Running)
+	}
+}
@@ -1,12 +1,79 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
 	_ "modernc.org/sqlite"
 
+	"main/cli"
 	"main/launch"
 )
 
+const usage = `Utilisation: %s [commande] [options]
+
+Commandes:
+  run        suit les jeux et sert l'interface web (commande par défaut)
+%s
+"%s COMMANDE -h" détaille les options d'une commande. Les autres commandes
+travaillent sur la base locale, ou sur une instance en cours avec --url.
+
+Options de run:
+`
+
 func main() {
-	launch.StartProgramme()
-	// Récupérer tous les processus en cours d'exécution
+	args := os.Args[1:]
+	cmd := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	switch cmd {
+	case "run":
+		os.Exit(runCommand(args))
+	case "help":
+		runCommand([]string{"-h"})
+	default:
+		if cli.Has(cmd) {
+			os.Exit(cli.Run(cmd, args))
+		}
+		fmt.Fprintf(os.Stderr, "commande inconnue: %s\n", cmd)
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+}
+
+func printUsage(w io.Writer) {
+	var cmds strings.Builder
+	cli.Usage(&cmds)
+	fmt.Fprintf(w, usage, os.Args[0], cmds.String(), os.Args[0])
+}
+
+func runCommand(args []string) int {
+	var opts launch.Options
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	fs.Usage = func() {
+		printUsage(fs.Output())
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.DataDir, "data-dir", "", "dossier des données (sinon WGT_DATA_DIR ou le dossier du système)")
+	fs.BoolVar(&opts.Headless, "headless", !launch.TraySupported, "sans icône de notification, jusqu'à SIGINT/SIGTERM (service systemd, conteneur)")
+	tray := fs.Bool("tray", false, "avec l'icône de notification (par défaut quand elle est disponible)")
+	fs.StringVar(&opts.LogFile, "log-file", "", "écrit les journaux dans ce fichier plutôt que sur la console")
+	fs.Parse(args)
+	if *tray && opts.Headless {
+		if !launch.TraySupported {
+			fmt.Fprintln(os.Stderr, "cette version est compilée sans icône de notification (tag notray)")
+		} else {
+			fmt.Fprintln(os.Stderr, "--tray et --headless sont incompatibles")
+		}
+		return 2
+	}
+	if err := launch.Run(opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
 }
@@ -1,19 +1,27 @@
 package manager
 
 import (
-	"strings"
+	"fmt"
 	"sync"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// Structure pour gérer les listes en mémoire
+// Structure pour gérer les listes en mémoire.
+// La whitelist et la blacklist restent indexées par nom : ce sont des motifs
+// "contains" comparés au chemin des processus, avant que le jeu n'existe.
+// La blacklist tient à jour games.blacklisted, que les stats utilisent : pour
+// les jeux existants dans AddToBlacklist et RemoveFromBlacklist, pour les
+// suivants quand ensureGame les crée.
 type ListManager struct {
 	db        *sqlx.DB
 	whitelist map[string]struct{} // Utilisation d'un map pour des lookups O(1)
 	blacklist map[string]struct{}
+	rules     []*Rule // règles typées de la table list_rules
+	ordered   []*Rule // toutes les règles (typées + listes historiques) dans l'ordre d'évaluation
 	mutex     sync.RWMutex
+	listeners []func() // appelées après chaque modification des listes
 }
 
 // Créer un nouveau gestionnaire de liste
@@ -47,9 +55,26 @@ func (lm *ListManager) RefreshLists() error {
 		return err
 	}
 
+	var rows []Rule
+	err = lm.db.Select(&rows, `SELECT r.id, r.list, r.kind, r.pattern, r.case_insensitive, r.priority,
+		COALESCE(r.track_children, FALSE) AS track_children, COALESCE(r.game_id, 0) AS game_id, COALESCE(g.name, '') AS game
+		FROM list_rules r LEFT JOIN games g ON g.id = r.game_id`)
+	if err != nil {
+		return err
+	}
+	rules := make([]*Rule, 0, len(rows))
+	for i := range rows {
+		r := rows[i]
+		if err := r.compile(); err != nil {
+			// Une règle invalide ne doit pas bloquer les autres
+			fmt.Printf("Règle %d ignorée: %v\n", r.ID, err)
+			continue
+		}
+		rules = append(rules, &r)
+	}
+
 	// Mettre à jour les maps en mémoire
 	lm.mutex.Lock()
-	defer lm.mutex.Unlock()
 
 	// Recréer les maps
 	newWhitelist := make(map[string]struct{}, len(whitelistedNames))
@@ -65,38 +90,148 @@ func (lm *ListManager) RefreshLists() error {
 	// Remplacer les anciennes listes
 	lm.whitelist = newWhitelist
 	lm.blacklist = newBlacklist
+	lm.rules = rules
+	lm.rebuild()
+	lm.mutex.Unlock()
+	lm.changed()
 
 	return nil
 }
 
-// Vérifier si un chemin contient une entrée de la whitelist
-func (lm *ListManager) IsWhitelisted(path string) bool {
+// OnChange enregistre fn, appelée après chaque modification des listes ou
+// des règles, rechargement compris
+func (lm *ListManager) OnChange(fn func()) {
+	lm.mutex.Lock()
+	lm.listeners = append(lm.listeners, fn)
+	lm.mutex.Unlock()
+}
+
+// changed prévient les abonnés; à appeler sans le verrou
+func (lm *ListManager) changed() {
+	lm.mutex.RLock()
+	listeners := append([]func(){}, lm.listeners...)
+	lm.mutex.RUnlock()
+
+	for _, fn := range listeners {
+		fn()
+	}
+}
+
+// rebuild recalcule l'ordre d'évaluation; à appeler avec le verrou en écriture
+func (lm *ListManager) rebuild() {
+	ordered := make([]*Rule, 0, len(lm.rules)+len(lm.whitelist)+len(lm.blacklist))
+	ordered = append(ordered, lm.rules...)
+	// Les entrées historiques sont des règles "contains" de priorité 0
+	for name := range lm.whitelist {
+		ordered = append(ordered, &Rule{List: ListWhitelist, Kind: KindContains, Pattern: name, Legacy: true})
+	}
+	for name := range lm.blacklist {
+		ordered = append(ordered, &Rule{List: ListBlacklist, Kind: KindContains, Pattern: name, Legacy: true})
+	}
+	sortRules(ordered)
+	lm.ordered = ordered
+}
+
+// Match renvoie la première règle qui correspond au chemin
+func (lm *ListManager) Match(path string) (Rule, bool) {
 	lm.mutex.RLock()
 	defer lm.mutex.RUnlock()
 
-	// Parcourir toutes les entrées de la whitelist
-	for entry := range lm.whitelist {
-		if strings.Contains(path, entry) {
-			return true
+	for _, r := range lm.ordered {
+		if r.Matches(path) {
+			return *r, true
 		}
 	}
-	return false
+	return Rule{}, false
 }
 
-// Vérifier si un chemin contient une entrée de la blacklist
-func (lm *ListManager) IsBlacklisted(path string) bool {
+// Rules renvoie toutes les règles dans l'ordre d'évaluation
+func (lm *ListManager) Rules() []Rule {
+	lm.mutex.RLock()
+	defer lm.mutex.RUnlock()
+
+	out := make([]Rule, 0, len(lm.ordered))
+	for _, r := range lm.ordered {
+		out = append(out, *r)
+	}
+	return out
+}
+
+// HasLaunchers indique si une règle suit les processus enfants
+func (lm *ListManager) HasLaunchers() bool {
 	lm.mutex.RLock()
 	defer lm.mutex.RUnlock()
 
-	// Parcourir toutes les entrées de la blacklist
-	for entry := range lm.blacklist {
-		if strings.Contains(path, entry) {
+	for _, r := range lm.ordered {
+		if r.TrackChildren {
 			return true
 		}
 	}
 	return false
 }
 
+// Vérifier si la règle décisive pour ce chemin est dans la whitelist
+func (lm *ListManager) IsWhitelisted(path string) bool {
+	r, ok := lm.Match(path)
+	return ok && r.List == ListWhitelist
+}
+
+// Vérifier si la règle décisive pour ce chemin est dans la blacklist
+func (lm *ListManager) IsBlacklisted(path string) bool {
+	r, ok := lm.Match(path)
+	return ok && r.List == ListBlacklist
+}
+
+// Ajouter une règle typée après validation
+func (lm *ListManager) AddRule(r Rule) (Rule, error) {
+	if err := r.compile(); err != nil {
+		return Rule{}, err
+	}
+	var gameID any
+	if r.GameID > 0 {
+		gameID = r.GameID
+	}
+	res, err := lm.db.Exec("INSERT INTO list_rules (list, kind, pattern, case_insensitive, priority, track_children, game_id) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		r.List, r.Kind, r.Pattern, r.CaseInsensitive, r.Priority, r.TrackChildren, gameID)
+	if err != nil {
+		return Rule{}, err
+	}
+	if r.ID, err = res.LastInsertId(); err != nil {
+		return Rule{}, err
+	}
+
+	lm.mutex.Lock()
+	added := r
+	lm.rules = append(lm.rules, &added)
+	lm.rebuild()
+	lm.mutex.Unlock()
+	lm.changed()
+
+	return r, nil
+}
+
+// Supprimer une règle typée
+func (lm *ListManager) RemoveRule(id int64) error {
+	_, err := lm.db.Exec("DELETE FROM list_rules WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	lm.mutex.Lock()
+	kept := lm.rules[:0]
+	for _, r := range lm.rules {
+		if r.ID != id {
+			kept = append(kept, r)
+		}
+	}
+	lm.rules = kept
+	lm.rebuild()
+	lm.mutex.Unlock()
+	lm.changed()
+
+	return nil
+}
+
 // Ajouter à la whitelist et mettre à jour la mémoire
 func (lm *ListManager) AddToWhitelist(name string) error {
 	// Insérer dans la base de données
@@ -108,7 +243,9 @@ func (lm *ListManager) AddToWhitelist(name string) error {
 	// Mettre à jour la liste en mémoire
 	lm.mutex.Lock()
 	lm.whitelist[name] = struct{}{}
+	lm.rebuild()
 	lm.mutex.Unlock()
+	lm.changed()
 
 	return nil
 }
@@ -124,7 +261,9 @@ func (lm *ListManager) RemoveFromWhitelist(name string) error {
 	// Mettre à jour la liste en mémoire
 	lm.mutex.Lock()
 	delete(lm.whitelist, name)
+	lm.rebuild()
 	lm.mutex.Unlock()
+	lm.changed()
 
 	return nil
 }
@@ -136,11 +275,19 @@ func (lm *ListManager) AddToBlacklist(name string) error {
 	if err != nil {
 		return err
 	}
+	// Masquer le jeu de ce nom ou de cet exécutable dans les stats
+	_, err = lm.db.Exec(`UPDATE games SET blacklisted = TRUE
+		WHERE name = ? OR id IN (SELECT game_id FROM game_executables WHERE process_name = ?)`, name, name)
+	if err != nil {
+		return err
+	}
 
 	// Mettre à jour la liste en mémoire
 	lm.mutex.Lock()
 	lm.blacklist[name] = struct{}{}
+	lm.rebuild()
 	lm.mutex.Unlock()
+	lm.changed()
 
 	return nil
 }
@@ -152,11 +299,21 @@ func (lm *ListManager) RemoveFromBlacklist(name string) error {
 	if err != nil {
 		return err
 	}
+	// Réafficher le jeu, sauf si une autre entrée le masque encore
+	_, err = lm.db.Exec(`UPDATE games SET blacklisted = FALSE
+		WHERE (name = ? OR id IN (SELECT game_id FROM game_executables WHERE process_name = ?))
+		  AND name NOT IN (SELECT name FROM blacklist)
+		  AND NOT EXISTS (SELECT 1 FROM game_executables ge JOIN blacklist b ON b.name = ge.process_name WHERE ge.game_id = games.id)`, name, name)
+	if err != nil {
+		return err
+	}
 
 	// Mettre à jour la liste en mémoire
 	lm.mutex.Lock()
 	delete(lm.blacklist, name)
+	lm.rebuild()
 	lm.mutex.Unlock()
+	lm.changed()
 
 	return nil
 }
@@ -0,0 +1,68 @@
+package manager
+
+import (
+	"testing"
+
+	"main/query"
+)
+
+func TestBlacklistFlagsGames(t *testing.T) {
+	db, err := query.OpenDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	lm, err := NewListManager(db.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blacklisted := func(id int64) bool {
+		t.Helper()
+		g, err := db.GetGame(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return g.Blacklisted
+	}
+
+	// Blacklisted before the game is seen
+	if err := lm.AddToBlacklist("early.exe"); err != nil {
+		t.Fatal(err)
+	}
+	early, err := db.EnsureGame("early.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !blacklisted(early) {
+		t.Error("game created unflagged after its executable was blacklisted")
+	}
+
+	// A game with two executables stays hidden while one of them is listed
+	game, err := db.EnsureGame("game.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AssignExecutable("launcher.exe", game); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"game.exe", "launcher.exe"} {
+		if err := lm.AddToBlacklist(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !blacklisted(game) {
+		t.Error("game not flagged once blacklisted")
+	}
+	if err := lm.RemoveFromBlacklist("game.exe"); err != nil {
+		t.Fatal(err)
+	}
+	if !blacklisted(game) {
+		t.Error("game shown again while launcher.exe is still blacklisted")
+	}
+	if err := lm.RemoveFromBlacklist("launcher.exe"); err != nil {
+		t.Fatal(err)
+	}
+	if blacklisted(game) {
+		t.Error("game still flagged once removed from the blacklist")
+	}
+}
@@ -0,0 +1,146 @@
+package manager
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Lists a rule can belong to
+const (
+	ListWhitelist = "whitelist"
+	ListBlacklist = "blacklist"
+)
+
+// Kinds of rules
+const (
+	// KindExact matches the executable file name (e.g. "game.exe")
+	KindExact = "exact"
+	// KindPrefix matches the beginning of the full path (e.g. "D:/Games/")
+	KindPrefix = "prefix"
+	// KindGlob matches the full path with path.Match syntax, or the file name
+	// when the pattern has no separator (e.g. "*/steamapps/common/*/*.exe", "*.exe")
+	KindGlob = "glob"
+	// KindRegex matches the full path with a regular expression
+	KindRegex = "regex"
+	// KindContains matches a substring of the full path, as the legacy lists do
+	KindContains = "contains"
+)
+
+// Rule is a typed whitelist/blacklist entry. Rules are evaluated by
+// decreasing priority; at equal priority blacklist rules come first, then
+// rules are taken in id order. The first matching rule decides.
+type Rule struct {
+	ID              int64  `db:"id" json:"id"`
+	List            string `db:"list" json:"list"`
+	Kind            string `db:"kind" json:"kind"`
+	Pattern         string `db:"pattern" json:"pattern"`
+	CaseInsensitive bool   `db:"case_insensitive" json:"case_insensitive"`
+	Priority        int    `db:"priority" json:"priority"`
+	// TrackChildren turns a whitelist rule into a launcher rule: the matching
+	// process is not tracked itself, its descendants are, as the game GameID
+	TrackChildren bool `db:"track_children" json:"track_children"`
+	// GameID is the game descendants are attributed to, 0 for the launcher's own game
+	GameID int64 `db:"game_id" json:"game_id,omitempty"`
+	// Game is the name of GameID
+	Game string `db:"game" json:"game,omitempty"`
+	// Legacy is set for entries of the whitelist/blacklist tables, seen as contains rules
+	Legacy bool `db:"-" json:"legacy,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// normalizePath uses forward slashes so patterns work with Windows paths too
+func normalizePath(p string) string {
+	return strings.ReplaceAll(p, `\`, "/")
+}
+
+// compile validates the rule and prepares its matcher
+func (r *Rule) compile() error {
+	if r.List != ListWhitelist && r.List != ListBlacklist {
+		return fmt.Errorf("unknown list %q", r.List)
+	}
+	if r.Pattern == "" {
+		return fmt.Errorf("empty pattern")
+	}
+	if r.TrackChildren && r.List != ListWhitelist {
+		return fmt.Errorf("only whitelist rules can track children")
+	}
+	switch r.Kind {
+	case KindExact, KindPrefix, KindContains:
+	case KindGlob:
+		if _, err := path.Match(r.pattern(), ""); err != nil {
+			return fmt.Errorf("bad glob %q: %w", r.Pattern, err)
+		}
+	case KindRegex:
+		expr := r.Pattern
+		if r.CaseInsensitive {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("bad regex %q: %w", r.Pattern, err)
+		}
+		r.re = re
+	default:
+		return fmt.Errorf("unknown kind %q", r.Kind)
+	}
+	return nil
+}
+
+func (r *Rule) pattern() string {
+	p := r.Pattern
+	if r.Kind != KindRegex && r.Kind != KindContains {
+		p = normalizePath(p)
+	}
+	if r.CaseInsensitive {
+		p = strings.ToLower(p)
+	}
+	return p
+}
+
+// Matches reports whether the executable path matches the rule
+func (r *Rule) Matches(exePath string) bool {
+	if r.Kind == KindRegex {
+		return r.re != nil && r.re.MatchString(exePath)
+	}
+	p := r.pattern()
+	subject := exePath
+	if r.Kind != KindContains {
+		subject = normalizePath(subject)
+	}
+	if r.CaseInsensitive {
+		subject = strings.ToLower(subject)
+	}
+	switch r.Kind {
+	case KindExact:
+		return path.Base(subject) == p
+	case KindPrefix:
+		return strings.HasPrefix(subject, p)
+	case KindGlob:
+		if !strings.Contains(p, "/") {
+			subject = path.Base(subject)
+		}
+		ok, _ := path.Match(p, subject)
+		return ok
+	case KindContains:
+		return strings.Contains(subject, p)
+	}
+	return false
+}
+
+// sortRules applies the evaluation order
+func sortRules(rules []*Rule) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		a, b := rules[i], rules[j]
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		if a.List != b.List {
+			return a.List == ListBlacklist
+		}
+		return a.ID < b.ID
+	})
+}
@@ -0,0 +1,816 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"main/discovery"
+	"main/entity"
+	"main/events"
+	"main/focus"
+	"main/idle"
+	"main/manager"
+	"main/query"
+)
+
+// StartPolicy decides when a session starts for a game that was already
+// running when the tracker started
+type StartPolicy string
+
+const (
+	// StartFromCreateTime counts from the process creation time
+	StartFromCreateTime StartPolicy = "create_time"
+	// StartFromTrackerStart counts from the tracker startup only
+	StartFromTrackerStart StartPolicy = "tracker_start"
+)
+
+// ParseStartPolicy validates a policy name, empty meaning the default
+func ParseStartPolicy(s string) (StartPolicy, error) {
+	switch StartPolicy(s) {
+	case "", StartFromCreateTime:
+		return StartFromCreateTime, nil
+	case StartFromTrackerStart:
+		return StartFromTrackerStart, nil
+	}
+	return "", fmt.Errorf("unknown start policy %q", s)
+}
+
+type ProcessMonitor struct {
+	source ProcessSource
+	// trackers holds one session per game, so the executables of a game
+	// running at the same time are counted once
+	trackers map[int64]*ProcessTracker
+	// pids maps each tracked process to the game of its session
+	pids map[int32]int64
+	// launchers holds the running processes matched by a track children rule
+	launchers    map[int32]launcher
+	db           *query.Database
+	trackerMutex sync.Mutex
+	startedAt    time.Time
+
+	// StartPolicy applies to games already running when Run is called
+	StartPolicy StartPolicy
+	// HeartbeatInterval is the delay between two checkpoints of running sessions
+	HeartbeatInterval time.Duration
+	// Idle detects when the user is away, nil disables idle tracking
+	Idle idle.Detector
+	// IdleThreshold is the inactivity after which time is counted as idle, 0
+	// disables idle tracking
+	IdleThreshold time.Duration
+	// idleFrom is the start of the current away period, zero while the user is active
+	idleFrom time.Time
+	// Focus reports the foreground window, nil disables focus tracking
+	Focus focus.Provider
+	// Events receives the start and end of the sessions, nil publishes nothing
+	Events *events.Bus
+	// Guard is consulted when a game starts and while games run, nil for none
+	Guard Guard
+	// storeIDsDone holds the executables whose store ids were already looked up
+	storeIDsDone map[string]struct{}
+}
+
+const (
+	// maxLauncherDepth bounds the walk up the process tree looking for a launcher
+	maxLauncherDepth = 32
+	// idleCheckInterval is the delay between two queries of the idle detector
+	idleCheckInterval = 5 * time.Second
+	// focusCheckInterval is the delay between two queries of the foreground window
+	focusCheckInterval = 2 * time.Second
+	// guardCheckInterval is the delay between two checks of the running games by the guard
+	guardCheckInterval = 15 * time.Second
+)
+
+// Guard enforces rules on the running games, such as allowed time windows
+type Guard interface {
+	// Check is called from the monitor loop with the running sessions, when
+	// a game starts and every guardCheckInterval
+	Check(now time.Time, playing []Playing)
+}
+
+func NewProcessMonitor(db *query.Database, source ProcessSource) *ProcessMonitor {
+	return &ProcessMonitor{
+		source:       source,
+		trackers:     make(map[int64]*ProcessTracker),
+		pids:         make(map[int32]int64),
+		launchers:    make(map[int32]launcher),
+		storeIDsDone: make(map[string]struct{}),
+		db:           db,
+		startedAt:    time.Now(),
+		StartPolicy:  StartFromCreateTime,
+
+		HeartbeatInterval: 30 * time.Second,
+		IdleThreshold:     5 * time.Minute,
+	}
+}
+
+// Run consumes the events of the process source until ctx is done
+func (pm *ProcessMonitor) Run(ctx context.Context, lm *manager.ListManager) error {
+	pm.trackerMutex.Lock()
+	pm.startedAt = time.Now()
+	pm.trackerMutex.Unlock()
+
+	events, err := pm.source.Subscribe(ctx)
+	if err != nil {
+		return fmt.Errorf("Run: %w", err)
+	}
+	pm.trackerMutex.Lock()
+	interval := pm.HeartbeatInterval
+	pm.trackerMutex.Unlock()
+	heartbeat := time.NewTicker(interval)
+	defer heartbeat.Stop()
+	var idleTick <-chan time.Time
+	if pm.Idle != nil {
+		t := time.NewTicker(idleCheckInterval)
+		defer t.Stop()
+		idleTick = t.C
+	}
+	var focusTick <-chan time.Time
+	if pm.Focus != nil {
+		t := time.NewTicker(focusCheckInterval)
+		defer t.Stop()
+		focusTick = t.C
+	}
+	var guardTick <-chan time.Time
+	if pm.Guard != nil {
+		t := time.NewTicker(guardCheckInterval)
+		defer t.Stop()
+		guardTick = t.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-heartbeat.C:
+			pm.checkpoint(now)
+			// The interval may have been changed by Configure
+			pm.trackerMutex.Lock()
+			if pm.HeartbeatInterval != interval {
+				interval = pm.HeartbeatInterval
+				heartbeat.Reset(interval)
+			}
+			pm.trackerMutex.Unlock()
+		case now := <-idleTick:
+			pm.checkIdle(now)
+		case now := <-focusTick:
+			pm.checkFocus(now)
+		case now := <-guardTick:
+			pm.checkGuard(now)
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			switch ev.Kind {
+			case EventStart:
+				pm.processCheck(ev.Process, ev.Time, lm)
+			case EventExit:
+				pm.handleProcessExit(ev.Process, ev.Time)
+			}
+		}
+	}
+}
+
+// Configure changes the start policy, heartbeat interval and idle threshold,
+// of a running monitor too
+func (pm *ProcessMonitor) Configure(policy StartPolicy, heartbeat, idleThreshold time.Duration) {
+	pm.trackerMutex.Lock()
+	defer pm.trackerMutex.Unlock()
+	pm.StartPolicy = policy
+	if heartbeat > 0 {
+		pm.HeartbeatInterval = heartbeat
+	}
+	pm.IdleThreshold = idleThreshold
+}
+
+// StartTracking adds p, seen running at detectedAt, to the session of the game
+// gameID, starting the session if the game is not running yet. attributed is
+// set when the game comes from a launcher rule rather than from the executable.
+func (pm *ProcessMonitor) StartTracking(p ProcessInfo, gameID int64, attributed bool, detectedAt time.Time) error {
+	// The database is only used without trackerMutex, which NowPlaying needs
+	game := p.Name
+	if g, err := pm.db.GetGame(gameID); err == nil {
+		game = g.Name
+	}
+
+	pm.trackerMutex.Lock()
+	// Vérifier si on suit déjà ce processus
+	if t, exists := pm.trackers[pm.pids[p.PID]]; exists && sameProcess(t.Processes[p.PID], p) {
+		pm.trackerMutex.Unlock()
+		return nil
+	}
+	if err := pm.source.Watch(p); err != nil {
+		pm.trackerMutex.Unlock()
+		return fmt.Errorf("StartTracking: %w", err)
+	}
+	stale, end := pm.untrack(p, gameID, detectedAt)
+	tracker := pm.startTracking(p, gameID, game, attributed, detectedAt)
+	var started Playing
+	if tracker != nil {
+		started = tracker.playing(detectedAt, pm.idleFrom)
+	}
+	pm.trackerMutex.Unlock()
+
+	if stale != nil {
+		pm.closeSession(stale, end)
+	}
+	if tracker != nil {
+		pm.sessionStarted(tracker, started)
+	}
+	return nil
+}
+
+// startTracking adds p to the session of its game, starting it if needed, and
+// returns the session started, nil when p joined a running one; must be called
+// with trackerMutex held
+func (pm *ProcessMonitor) startTracking(p ProcessInfo, gameID int64, game string, attributed bool, detectedAt time.Time) *ProcessTracker {
+	// Une autre instance du jeu tourne déjà : même session
+	if t, exists := pm.trackers[gameID]; exists {
+		t.Processes[p.PID] = p
+		pm.pids[p.PID] = gameID
+		return nil
+	}
+
+	start, source := pm.sessionStart(p, detectedAt)
+	tracker := &ProcessTracker{
+		PID:         p.PID,
+		Name:        p.Name,
+		Process:     p,
+		GameID:      gameID,
+		Game:        game,
+		Attributed:  attributed,
+		Processes:   map[int32]ProcessInfo{p.PID: p},
+		StartTime:   start,
+		StartSource: source,
+		IsRunning:   true,
+	}
+	pm.trackers[gameID] = tracker
+	pm.pids[p.PID] = gameID
+	return tracker
+}
+
+// sessionStarted checkpoints a session just started and publishes its start;
+// must be called without trackerMutex
+func (pm *ProcessMonitor) sessionStarted(tracker *ProcessTracker, started Playing) {
+	pm.trackerMutex.Lock()
+	record := tracker.record()
+	pm.trackerMutex.Unlock()
+	id, err := pm.db.InsertActiveSession(record)
+	if err != nil {
+		// The session is still tracked, it just won't survive a crash
+		log.Println("StartTracking:", err)
+	}
+	pm.trackerMutex.Lock()
+	// The session may have ended meanwhile, before its checkpoint was known
+	running := pm.trackers[tracker.GameID] == tracker
+	if running {
+		tracker.SessionID = id
+	}
+	pm.trackerMutex.Unlock()
+	if !running && id != 0 {
+		if err := pm.db.DeleteActiveSession(id); err != nil {
+			log.Println("StartTracking:", err)
+		}
+	}
+
+	pm.Events.Publish(events.SessionStarted, started)
+	if played, err := pm.db.GameHasActivity(tracker.GameID); err != nil {
+		log.Println("StartTracking:", err)
+	} else if !played {
+		pm.Events.Publish(events.FirstLaunch, started)
+	}
+}
+
+// NowPlaying returns the running sessions as of now, oldest first
+func (pm *ProcessMonitor) NowPlaying(now time.Time) []Playing {
+	pm.trackerMutex.Lock()
+	defer pm.trackerMutex.Unlock()
+
+	out := make([]Playing, 0, len(pm.trackers))
+	for _, t := range pm.trackers {
+		out = append(out, t.playing(now, pm.idleFrom))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].StartTime.Before(out[j].StartTime) })
+	return out
+}
+
+// TerminateGame asks the running processes of the game gameID to exit, all
+// running games when gameID is 0, and returns how many were asked
+func (pm *ProcessMonitor) TerminateGame(gameID int64) (int, error) {
+	t, ok := pm.source.(Terminator)
+	if !ok {
+		return 0, errors.New("TerminateGame: the process source can't stop processes")
+	}
+	pm.trackerMutex.Lock()
+	var procs []ProcessInfo
+	for id, tracker := range pm.trackers {
+		if gameID == 0 || id == gameID {
+			for _, p := range tracker.Processes {
+				procs = append(procs, p)
+			}
+		}
+	}
+	pm.trackerMutex.Unlock()
+
+	var errs []error
+	for _, p := range procs {
+		if err := t.Terminate(p); err != nil {
+			errs = append(errs, fmt.Errorf("%s (%d): %w", p.Name, p.PID, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return len(procs), fmt.Errorf("TerminateGame: %w", err)
+	}
+	return len(procs), nil
+}
+
+// untrack forgets a stale process whose pid was reused by p, of the game
+// gameID; must be called with trackerMutex held. The session of its game goes
+// on while other processes of the game run, or when p belongs to it too.
+// Otherwise the session is ended and returned with its end, for the caller to
+// close it once trackerMutex is released: the stale process exited before p
+// was created, when that is known.
+func (pm *ProcessMonitor) untrack(p ProcessInfo, gameID int64, detectedAt time.Time) (*ProcessTracker, time.Time) {
+	t, exists := pm.trackers[pm.pids[p.PID]]
+	delete(pm.pids, p.PID)
+	if !exists {
+		return nil, time.Time{}
+	}
+	delete(t.Processes, p.PID)
+	if len(t.Processes) > 0 || t.GameID == gameID {
+		return nil, time.Time{}
+	}
+	end := detectedAt
+	if !p.CreateTime.IsZero() && p.CreateTime.Before(end) && p.CreateTime.After(t.StartTime) {
+		end = p.CreateTime
+	}
+	delete(pm.trackers, t.GameID)
+	pm.endSegments(t, end)
+	return t, end
+}
+
+// checkpoint saves the heartbeat of every running session
+func (pm *ProcessMonitor) checkpoint(at time.Time) {
+	pm.trackerMutex.Lock()
+	ids := make([]int64, 0, len(pm.trackers))
+	for _, t := range pm.trackers {
+		if t.SessionID != 0 {
+			ids = append(ids, t.SessionID)
+		}
+	}
+	pm.trackerMutex.Unlock()
+
+	for _, id := range ids {
+		if err := pm.db.HeartbeatActiveSession(id, at); err != nil {
+			log.Println("checkpoint:", err)
+		}
+	}
+}
+
+// ResumeSessions checkpoints the running sessions again once a restore
+// replaced the database: their rows in active_sessions are gone, and their
+// game may be missing from the restored one. The sessions left running in the
+// backup become activities first, ending at their last heartbeat.
+func (pm *ProcessMonitor) ResumeSessions() error {
+	pm.trackerMutex.Lock()
+	defer pm.trackerMutex.Unlock()
+	if _, err := pm.db.RecoverActiveSessions(); err != nil {
+		return fmt.Errorf("ResumeSessions: %w", err)
+	}
+	trackers := pm.trackers
+	pm.trackers = map[int64]*ProcessTracker{}
+	for _, t := range trackers {
+		if _, err := pm.db.GetGame(t.GameID); errors.Is(err, query.ErrGameNotFound) {
+			id, err := pm.db.EnsureGame(t.Name)
+			if err != nil {
+				return fmt.Errorf("ResumeSessions: %w", err)
+			}
+			t.GameID = id
+		} else if err != nil {
+			return fmt.Errorf("ResumeSessions: %w", err)
+		}
+		for pid := range t.Processes {
+			pm.pids[pid] = t.GameID
+		}
+		// Two running games can be one game of the restored database; the away
+		// periods of the user are the same in both sessions
+		if other, exists := pm.trackers[t.GameID]; exists {
+			for pid, p := range t.Processes {
+				other.Processes[pid] = p
+			}
+			if t.StartTime.Before(other.StartTime) {
+				other.StartTime, other.StartSource, other.Idle = t.StartTime, t.StartSource, t.Idle
+			}
+			continue
+		}
+		pm.trackers[t.GameID] = t
+	}
+	for _, t := range pm.trackers {
+		id, err := pm.db.InsertActiveSession(t.record())
+		if err != nil {
+			log.Println("ResumeSessions:", err)
+		}
+		t.SessionID = id
+	}
+	return nil
+}
+
+func (pm *ProcessMonitor) handleProcessExit(p ProcessInfo, at time.Time) {
+	pm.trackerMutex.Lock()
+	if l, exists := pm.launchers[p.PID]; exists && sameProcess(l.process, p) {
+		delete(pm.launchers, p.PID)
+	}
+	tracker, exists := pm.trackers[pm.pids[p.PID]]
+	if !exists || !sameProcess(tracker.Processes[p.PID], p) {
+		pm.trackerMutex.Unlock()
+		return
+	}
+	delete(tracker.Processes, p.PID)
+	delete(pm.pids, p.PID)
+	// La session continue tant qu'un exécutable du jeu tourne
+	if len(tracker.Processes) > 0 {
+		pm.trackerMutex.Unlock()
+		return
+	}
+	// Retirer le tracker de la liste
+	delete(pm.trackers, tracker.GameID)
+	pm.endSegments(tracker, at)
+	pm.trackerMutex.Unlock()
+
+	pm.closeSession(tracker, at)
+}
+
+// CloseSessions ends every running session at the given time. It is meant to
+// be called on shutdown, once Run has returned.
+func (pm *ProcessMonitor) CloseSessions(at time.Time) {
+	pm.trackerMutex.Lock()
+	trackers := make([]*ProcessTracker, 0, len(pm.trackers))
+	for gameID, t := range pm.trackers {
+		pm.endSegments(t, at)
+		trackers = append(trackers, t)
+		delete(pm.trackers, gameID)
+	}
+	clear(pm.pids)
+	clear(pm.launchers)
+	pm.trackerMutex.Unlock()
+
+	for _, t := range trackers {
+		pm.closeSession(t, at)
+	}
+}
+
+func (pm *ProcessMonitor) closeSession(tracker *ProcessTracker, at time.Time) {
+	tracker.IsRunning = false
+	tracker.EndTime = at
+
+	// Enregistrer l'activité
+	err := pm.db.SaveActivity(tracker.record())
+	// Once saved, so that the subscribers find the session in the stats
+	pm.Events.Publish(events.SessionEnded, tracker.playing(at, time.Time{}))
+	if err != nil {
+		// Keep the active session so the recovery can still save it
+		log.Println("Erreur lors de l'enregistrement de l'activité:", err)
+		return
+	}
+	if tracker.SessionID != 0 {
+		if err := pm.db.DeleteActiveSession(tracker.SessionID); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// checkIdle splits running sessions into active and idle segments
+func (pm *ProcessMonitor) checkIdle(now time.Time) {
+	d, err := pm.Idle.IdleTime()
+	if err != nil {
+		// Unknown state: keep the current one
+		return
+	}
+	pm.trackerMutex.Lock()
+	defer pm.trackerMutex.Unlock()
+
+	away := pm.IdleThreshold > 0 && d >= pm.IdleThreshold
+	switch {
+	case away && pm.idleFrom.IsZero():
+		// The away period started with the last input
+		pm.idleFrom = now.Add(-d)
+	case !away && !pm.idleFrom.IsZero():
+		back := now.Add(-d)
+		for _, t := range pm.trackers {
+			t.addIdle(pm.idleFrom, back)
+		}
+		pm.idleFrom = time.Time{}
+	}
+}
+
+// checkFocus splits running sessions into focused and unfocused segments
+func (pm *ProcessMonitor) checkFocus(now time.Time) {
+	win, err := pm.Focus.Foreground()
+	if err != nil {
+		return
+	}
+	pm.trackerMutex.Lock()
+	defer pm.trackerMutex.Unlock()
+
+	for _, t := range pm.trackers {
+		if _, ok := t.Processes[win.PID]; ok {
+			if win.Title != "" {
+				t.WindowTitle = win.Title
+			}
+			t.endUnfocused(now)
+		} else if t.unfocusedFrom.IsZero() {
+			t.unfocusedFrom = now
+		}
+	}
+}
+
+// checkGuard hands the running sessions to the guard, if any
+func (pm *ProcessMonitor) checkGuard(now time.Time) {
+	if pm.Guard == nil {
+		return
+	}
+	pm.Guard.Check(now, pm.NowPlaying(now))
+}
+
+// endSegments closes the current away and unfocused periods of a session
+// ending at end; must be called with trackerMutex held
+func (pm *ProcessMonitor) endSegments(t *ProcessTracker, end time.Time) {
+	if !pm.idleFrom.IsZero() {
+		t.addIdle(pm.idleFrom, end)
+	}
+	t.endUnfocused(end)
+}
+
+// sessionStart picks the session start time according to the start policy;
+// must be called with trackerMutex held
+func (pm *ProcessMonitor) sessionStart(p ProcessInfo, detectedAt time.Time) (time.Time, string) {
+	if p.CreateTime.IsZero() || p.CreateTime.After(detectedAt) {
+		return detectedAt, entity.StartSourceDetected
+	}
+	if p.CreateTime.Before(pm.startedAt) && pm.StartPolicy == StartFromTrackerStart {
+		return pm.startedAt, entity.StartSourceTracker
+	}
+	return p.CreateTime, entity.StartSourceProcess
+}
+
+// ProcessTracker is the running session of a game
+type ProcessTracker struct {
+	// PID, Name and Process describe the process that started the session
+	PID     int32
+	Name    string
+	Process ProcessInfo
+	// GameID is the game of the session, Game its name when the session started
+	GameID int64
+	Game   string
+	// Attributed is set when GameID comes from a launcher rule
+	Attributed bool
+	// Processes holds the running processes of the game, the session ends
+	// with the last one
+	Processes   map[int32]ProcessInfo
+	StartTime   time.Time
+	StartSource string
+	EndTime     time.Time
+	IsRunning   bool
+	// SessionID is the id of the checkpoint row in active_sessions, 0 if none
+	SessionID int64
+	// Idle lists the away periods of the session
+	Idle []entity.Interval
+	// Unfocused lists the periods where the game window was in the background
+	Unfocused []entity.Interval
+	// WindowTitle is the last title seen while the game had the focus
+	WindowTitle string
+	// unfocusedFrom is the start of the current background period, zero while focused
+	unfocusedFrom time.Time
+}
+
+// Playing describes a running session, or one that just ended
+type Playing struct {
+	GameID      int64      `json:"game_id"`
+	Game        string     `json:"game"`
+	ProcessName string     `json:"process_name"`
+	PIDs        []int32    `json:"pids"`
+	StartTime   time.Time  `json:"start_time"`
+	StartSource string     `json:"start_source"`
+	EndTime     *time.Time `json:"end_time,omitempty"`
+	// Elapsed, Idle and Unfocused are in seconds, up to now or to the end of the session
+	Elapsed   float64 `json:"elapsed"`
+	Idle      float64 `json:"idle"`
+	Unfocused float64 `json:"unfocused"`
+	// Background is set while the game window is not in the foreground
+	Background  bool   `json:"background"`
+	WindowTitle string `json:"window_title,omitempty"`
+}
+
+// playing sums the session up at now, idleFrom being the start of the
+// current away period (zero while the user is active)
+func (t *ProcessTracker) playing(now, idleFrom time.Time) Playing {
+	p := Playing{
+		GameID:      t.GameID,
+		Game:        t.Game,
+		ProcessName: t.Name,
+		PIDs:        make([]int32, 0, len(t.Processes)),
+		StartTime:   t.StartTime,
+		StartSource: t.StartSource,
+		Elapsed:     now.Sub(t.StartTime).Seconds(),
+		Background:  !t.unfocusedFrom.IsZero(),
+		WindowTitle: t.WindowTitle,
+	}
+	if !t.IsRunning {
+		end := t.EndTime
+		p.EndTime = &end
+		p.Elapsed = end.Sub(t.StartTime).Seconds()
+	}
+	for pid := range t.Processes {
+		p.PIDs = append(p.PIDs, pid)
+	}
+	slices.Sort(p.PIDs)
+	for _, iv := range t.Idle {
+		p.Idle += iv.End.Sub(iv.Start).Seconds()
+	}
+	if !idleFrom.IsZero() && now.After(idleFrom) {
+		p.Idle += now.Sub(maxTime(idleFrom, t.StartTime)).Seconds()
+	}
+	for _, iv := range t.Unfocused {
+		p.Unfocused += iv.End.Sub(iv.Start).Seconds()
+	}
+	if p.Background && now.After(t.unfocusedFrom) {
+		p.Unfocused += now.Sub(t.unfocusedFrom).Seconds()
+	}
+	return p
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func (t *ProcessTracker) endUnfocused(at time.Time) {
+	if t.unfocusedFrom.IsZero() {
+		return
+	}
+	if at.After(t.unfocusedFrom) {
+		t.Unfocused = append(t.Unfocused, entity.Interval{Start: t.unfocusedFrom, End: at})
+	}
+	t.unfocusedFrom = time.Time{}
+}
+
+func (t *ProcessTracker) addIdle(from, to time.Time) {
+	if from.Before(t.StartTime) {
+		from = t.StartTime
+	}
+	if to.After(from) {
+		t.Idle = append(t.Idle, entity.Interval{Start: from, End: to})
+	}
+}
+
+func (t *ProcessTracker) record() entity.ActivityRecord {
+	var gameID int64
+	if t.Attributed {
+		gameID = t.GameID
+	}
+	return entity.ActivityRecord{
+		ProcessName: t.Name,
+		GameID:      gameID,
+		StartTime:   t.StartTime,
+		EndTime:     t.EndTime,
+		Duration:    t.EndTime.Sub(t.StartTime),
+		StartSource: t.StartSource,
+		Idle:        t.Idle,
+		WindowTitle: t.WindowTitle,
+		Unfocused:   t.Unfocused,
+	}
+}
+
+// launcher is a running process matched by a track children rule
+type launcher struct {
+	process ProcessInfo
+	gameID  int64
+}
+
+func (pm *ProcessMonitor) processCheck(p ProcessInfo, seenAt time.Time, listManager *manager.ListManager) {
+	path := p.Exe
+
+	rule, matched := listManager.Match(path)
+	// Vérifier d'abord si le programme est dans la blacklist
+	if matched && rule.List == manager.ListBlacklist {
+		fmt.Printf("Programme blacklisté ignoré: %s\n", path)
+		return
+	}
+
+	// Un lanceur n'est pas suivi lui-même, ses descendants le sont
+	if matched && rule.TrackChildren {
+		pm.addLauncher(p, rule)
+		return
+	}
+
+	gameID, attributed := int64(0), false
+	if l, ok := pm.launcherOf(p, listManager); ok {
+		gameID, attributed = l.gameID, true
+	} else if matched && rule.List == manager.ListWhitelist {
+		id, err := pm.db.EnsureGame(p.Name)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		gameID = id
+	} else {
+		return
+	}
+	if err := pm.StartTracking(p, gameID, attributed, seenAt); err != nil {
+		log.Println(err)
+		return
+	}
+	pm.checkGuard(seenAt)
+	// The store ids of a launcher child may belong to another game than the configured one
+	if !attributed {
+		pm.recordStoreIDs(p, gameID)
+	}
+}
+
+// addLauncher remembers a process matched by a track children rule and
+// watches it so it is forgotten when it exits
+func (pm *ProcessMonitor) addLauncher(p ProcessInfo, rule manager.Rule) (launcher, bool) {
+	pm.trackerMutex.Lock()
+	l, exists := pm.launchers[p.PID]
+	pm.trackerMutex.Unlock()
+	if exists && sameProcess(l.process, p) {
+		return l, true
+	}
+	gameID := rule.GameID
+	if gameID == 0 {
+		id, err := pm.db.EnsureGame(p.Name)
+		if err != nil {
+			log.Println("addLauncher:", err)
+			return launcher{}, false
+		}
+		gameID = id
+	}
+	if err := pm.source.Watch(p); err != nil {
+		log.Println("addLauncher:", err)
+		return launcher{}, false
+	}
+	l = launcher{process: p, gameID: gameID}
+	pm.trackerMutex.Lock()
+	pm.launchers[p.PID] = l
+	pm.trackerMutex.Unlock()
+	return l, true
+}
+
+// launcherOf walks up the process tree of p looking for a launcher. Ancestors
+// are matched against the rules too, since a snapshot may list a child before
+// its launcher.
+func (pm *ProcessMonitor) launcherOf(p ProcessInfo, listManager *manager.ListManager) (launcher, bool) {
+	if !listManager.HasLaunchers() {
+		return launcher{}, false
+	}
+	pid := p.PPID
+	for i := 0; i < maxLauncherDepth && pid > 1 && pid != p.PID; i++ {
+		pm.trackerMutex.Lock()
+		l, ok := pm.launchers[pid]
+		pm.trackerMutex.Unlock()
+		// A launcher started after p only reuses the pid of its real parent
+		if ok && (l.process.CreateTime.IsZero() || p.CreateTime.IsZero() || !l.process.CreateTime.After(p.CreateTime)) {
+			return l, true
+		}
+		parent, err := pm.source.Lookup(pid)
+		if err != nil {
+			break
+		}
+		if rule, matched := listManager.Match(parent.Exe); matched && rule.TrackChildren {
+			if l, ok := pm.addLauncher(parent, rule); ok {
+				return l, true
+			}
+		}
+		pid = parent.PPID
+	}
+	return launcher{}, false
+}
+
+// recordStoreIDs saves the store ids derived from the executable path, once per executable
+func (pm *ProcessMonitor) recordStoreIDs(p ProcessInfo, gameID int64) {
+	if p.Exe == "" {
+		return
+	}
+	pm.trackerMutex.Lock()
+	_, done := pm.storeIDsDone[p.Exe]
+	pm.storeIDsDone[p.Exe] = struct{}{}
+	pm.trackerMutex.Unlock()
+	if done {
+		return
+	}
+	ids := discovery.StoreIDs(p.Exe)
+	if len(ids) == 0 {
+		return
+	}
+	for store, id := range ids {
+		if err := pm.db.UpsertStoreID(gameID, store, id); err != nil {
+			log.Println("recordStoreIDs:", err)
+		}
+	}
+}
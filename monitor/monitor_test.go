@@ -0,0 +1,343 @@
+package monitor
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"main/entity"
+	"main/events"
+	"main/focus"
+	"main/idle"
+	"main/manager"
+	"main/query"
+)
+
+func newMonitor(t *testing.T, whitelist ...string) (*ProcessMonitor, *FakeSource, *manager.ListManager) {
+	t.Helper()
+	db, err := query.OpenDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	lm, err := manager.NewListManager(db.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range whitelist {
+		if err := lm.AddToWhitelist(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	source := NewFakeSource()
+	pm := NewProcessMonitor(db, source)
+	pm.Events = events.NewBus()
+	return pm, source, lm
+}
+
+// day avoids sessions split at midnight
+var day = time.Date(2026, 3, 2, 0, 0, 0, 0, time.Local)
+
+func proc(pid int32, name string, created time.Time) ProcessInfo {
+	return ProcessInfo{PID: pid, PPID: 1, Exe: "/games/" + name, Name: name, CreateTime: created}
+}
+
+type activity struct {
+	ProcessName string  `db:"process_name"`
+	StartTime   string  `db:"start_time"`
+	EndTime     string  `db:"end_time"`
+	Duration    float64 `db:"duration"`
+	StartSource string  `db:"start_source"`
+	Idle        float64 `db:"idle_seconds"`
+	Unfocused   float64 `db:"unfocused_seconds"`
+}
+
+func activities(t *testing.T, pm *ProcessMonitor) []activity {
+	t.Helper()
+	var out []activity
+	err := pm.db.Select(&out, `SELECT process_name, start_time, end_time, duration, start_source, idle_seconds, unfocused_seconds
+		FROM activities ORDER BY start_time`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func waitEvent(t *testing.T, sub <-chan events.Event, want events.Type) events.Event {
+	t.Helper()
+	for {
+		select {
+		case ev := <-sub:
+			if ev.Type == want {
+				return ev
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s event", want)
+		}
+	}
+}
+
+func TestRunTracksStartAndExit(t *testing.T) {
+	pm, source, lm := newMonitor(t, "game.exe")
+	sub, unsubscribe := pm.Events.Subscribe(16)
+	defer unsubscribe()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pm.Run(ctx, lm)
+
+	start := day.Add(10 * time.Hour)
+	source.Start(proc(100, "other.exe", start), start)
+	source.Start(proc(101, "game.exe", start), start)
+	started := waitEvent(t, sub, events.SessionStarted).Data.(Playing)
+	if started.ProcessName != "game.exe" || len(started.PIDs) != 1 || started.PIDs[0] != 101 {
+		t.Errorf("session started %+v", started)
+	}
+	// A second executable of the game joins the session, its exit is only
+	// reported once the monitor watches it
+	source.Start(proc(102, "game.exe", start.Add(10*time.Second)), start.Add(10*time.Second))
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if playing := pm.NowPlaying(time.Now()); len(playing) == 1 && len(playing[0].PIDs) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the second executable did not join the session")
+		}
+	}
+	source.Exit(101, start.Add(20*time.Second))
+	end := start.Add(30 * time.Second)
+	source.Exit(102, end)
+	ended := waitEvent(t, sub, events.SessionEnded).Data.(Playing)
+	if ended.EndTime == nil || !ended.EndTime.Equal(end) || ended.Elapsed != 30 {
+		t.Errorf("session ended %+v", ended)
+	}
+
+	got := activities(t, pm)
+	if len(got) != 1 {
+		t.Fatalf("got %d activities, want 1: %+v", len(got), got)
+	}
+	if got[0].ProcessName != "game.exe" || got[0].Duration != 30 || got[0].StartSource != entity.StartSourceProcess {
+		t.Errorf("activity saved %+v", got[0])
+	}
+	if playing := pm.NowPlaying(time.Now()); len(playing) != 0 {
+		t.Errorf("still playing %+v", playing)
+	}
+}
+
+func TestStartPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		policy     StartPolicy
+		created    time.Duration
+		wantStart  time.Duration
+		wantSource string
+	}{
+		// Running before the tracker started
+		{StartFromCreateTime, -time.Hour, -time.Hour, entity.StartSourceProcess},
+		{StartFromTrackerStart, -time.Hour, 0, entity.StartSourceTracker},
+		// Started afterwards, whatever the policy
+		{StartFromTrackerStart, time.Minute, time.Minute, entity.StartSourceProcess},
+		// Unknown or inconsistent creation time
+		{StartFromCreateTime, 0, 2 * time.Minute, entity.StartSourceDetected},
+	} {
+		pm, _, _ := newMonitor(t)
+		pm.Configure(tc.policy, 0, 0)
+		startedAt := pm.startedAt
+		var created time.Time
+		if tc.created != 0 {
+			created = startedAt.Add(tc.created)
+		}
+		if err := pm.StartTracking(proc(100, "game.exe", created), 1, false, startedAt.Add(2*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		playing := pm.NowPlaying(startedAt.Add(3 * time.Minute))
+		if len(playing) != 1 {
+			t.Fatalf("%s: %d sessions running", tc.policy, len(playing))
+		}
+		if p := playing[0]; !p.StartTime.Equal(startedAt.Add(tc.wantStart)) || p.StartSource != tc.wantSource {
+			t.Errorf("%s created at %v: session from %v (%s), want %v (%s)", tc.policy, tc.created,
+				p.StartTime.Sub(startedAt), p.StartSource, tc.wantStart, tc.wantSource)
+		}
+	}
+}
+
+func TestPIDReuse(t *testing.T) {
+	pm, _, _ := newMonitor(t)
+	start := day.Add(10 * time.Hour)
+	if err := pm.StartTracking(proc(100, "game.exe", start), 1, false, start); err != nil {
+		t.Fatal(err)
+	}
+	// The exit of 100 was missed, its pid now belongs to another game
+	reused := start.Add(20 * time.Minute)
+	if err := pm.StartTracking(proc(100, "other.exe", reused), 2, false, reused.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	playing := pm.NowPlaying(reused.Add(time.Minute))
+	if len(playing) != 1 || playing[0].GameID != 2 {
+		t.Fatalf("playing %+v, want the second game only", playing)
+	}
+	// The first session ended when the pid was reused
+	got := activities(t, pm)
+	if len(got) != 1 || got[0].ProcessName != "game.exe" || got[0].Duration != 20*60 {
+		t.Fatalf("activities %+v, want 20 minutes of game.exe", got)
+	}
+	var active int
+	if err := pm.db.Get(&active, `SELECT COUNT(*) FROM active_sessions`); err != nil {
+		t.Fatal(err)
+	}
+	if active != 1 {
+		t.Errorf("%d active sessions, want 1", active)
+	}
+
+	// The pid reused by the same game keeps its session
+	again := reused.Add(5 * time.Minute)
+	if err := pm.StartTracking(proc(100, "other.exe", again), 2, false, again); err != nil {
+		t.Fatal(err)
+	}
+	playing = pm.NowPlaying(again)
+	if len(playing) != 1 || !playing[0].StartTime.Equal(reused) || len(playing[0].PIDs) != 1 {
+		t.Errorf("playing %+v, want the session started at %v", playing, reused)
+	}
+	pm.handleProcessExit(proc(100, "other.exe", again), again.Add(time.Minute))
+	if playing := pm.NowPlaying(again); len(playing) != 0 {
+		t.Errorf("still playing %+v", playing)
+	}
+	if got := activities(t, pm); len(got) != 2 || got[1].Duration != 6*60 {
+		t.Errorf("activities %+v, want 6 minutes of other.exe", got)
+	}
+}
+
+func TestIdleAndFocus(t *testing.T) {
+	pm, _, _ := newMonitor(t)
+	detector, provider := &idle.Fake{}, &focus.Fake{}
+	pm.Idle, pm.Focus = detector, provider
+	pm.Configure(StartFromCreateTime, 0, 5*time.Minute)
+	start := day.Add(10 * time.Hour)
+	if err := pm.StartTracking(proc(100, "game.exe", start), 1, false, start); err != nil {
+		t.Fatal(err)
+	}
+
+	// Away from 10 to 20 minutes
+	detector.Set(6 * time.Minute)
+	pm.checkIdle(start.Add(16 * time.Minute))
+	detector.Set(0)
+	pm.checkIdle(start.Add(20 * time.Minute))
+	// Another window in the foreground from 30 to 40 minutes
+	provider.Set(focus.Window{PID: 200, Title: "Browser"})
+	pm.checkFocus(start.Add(30 * time.Minute))
+	provider.Set(focus.Window{PID: 100, Title: "Game"})
+	pm.checkFocus(start.Add(40 * time.Minute))
+	// An unknown state changes nothing
+	detector.SetError(idle.ErrUnsupported)
+	pm.checkIdle(start.Add(45 * time.Minute))
+
+	pm.CloseSessions(start.Add(50 * time.Minute))
+	got := activities(t, pm)
+	if len(got) != 1 || got[0].Idle != 10*60 || got[0].Unfocused != 10*60 {
+		t.Errorf("activities %+v, want 10 minutes idle and 10 unfocused", got)
+	}
+}
+
+func TestResumeSessionsAfterRestore(t *testing.T) {
+	pm, _, _ := newMonitor(t)
+	dir := t.TempDir()
+	start := day.Add(10 * time.Hour)
+	// The backup is taken while game.exe is running
+	gameID, err := pm.db.EnsureGame("game.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.StartTracking(proc(100, "game.exe", start), gameID, false, start); err != nil {
+		t.Fatal(err)
+	}
+	pm.checkpoint(start.Add(10 * time.Minute))
+	path := filepath.Join(dir, "backup.db")
+	if err := pm.db.BackupTo(path); err != nil {
+		t.Fatal(err)
+	}
+	// other.exe is not in the backup
+	otherID, err := pm.db.EnsureGame("other.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.StartTracking(proc(200, "other.exe", start.Add(20*time.Minute)), otherID, false, start.Add(20*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.db.RestoreFrom(path, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pm.ResumeSessions(); err != nil {
+		t.Fatal(err)
+	}
+	// The session of the backup was saved up to its heartbeat
+	if got := activities(t, pm); len(got) != 1 || got[0].Duration != 10*60 {
+		t.Fatalf("activities %+v, want the 10 minutes of the backup", got)
+	}
+	var active []struct {
+		ID          int64  `db:"id"`
+		ProcessName string `db:"process_name"`
+	}
+	if err := pm.db.Select(&active, `SELECT id, process_name FROM active_sessions ORDER BY process_name`); err != nil {
+		t.Fatal(err)
+	}
+	playing := pm.NowPlaying(start.Add(30 * time.Minute))
+	if len(active) != 2 || len(playing) != 2 {
+		t.Fatalf("%d active sessions and %d running, want 2", len(active), len(playing))
+	}
+	for _, p := range playing {
+		if _, err := pm.db.GetGame(p.GameID); err != nil {
+			t.Errorf("%s running as game %d: %v", p.ProcessName, p.GameID, err)
+		}
+	}
+
+	// Both sessions close without error, the overlap with the backup merged
+	pm.CloseSessions(start.Add(30 * time.Minute))
+	got := activities(t, pm)
+	if len(got) != 2 || got[0].ProcessName != "game.exe" || got[0].Duration != 30*60 || got[1].Duration != 10*60 {
+		t.Errorf("activities %+v, want 30 minutes of game.exe and 10 of other.exe", got)
+	}
+	var left int
+	if err := pm.db.Get(&left, `SELECT COUNT(*) FROM active_sessions`); err != nil {
+		t.Fatal(err)
+	}
+	if left != 0 {
+		t.Errorf("%d active sessions left", left)
+	}
+}
+
+func TestFirstLaunchPublishedOnce(t *testing.T) {
+	pm, _, _ := newMonitor(t)
+	sub, unsubscribe := pm.Events.Subscribe(16)
+	defer unsubscribe()
+	gameID, err := pm.db.EnsureGame("game.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := day.Add(10 * time.Hour)
+	for i, pid := range []int32{100, 101} {
+		from := start.Add(time.Duration(i) * time.Hour)
+		if err := pm.StartTracking(proc(pid, "game.exe", from), gameID, false, from); err != nil {
+			t.Fatal(err)
+		}
+		pm.handleProcessExit(proc(pid, "game.exe", from), from.Add(30*time.Minute))
+	}
+
+	var first, started int
+	for {
+		select {
+		case ev := <-sub:
+			switch ev.Type {
+			case events.FirstLaunch:
+				first++
+			case events.SessionStarted:
+				started++
+			}
+			continue
+		default:
+		}
+		break
+	}
+	if started != 2 || first != 1 {
+		t.Errorf("%d sessions started and %d first launches, want 2 and 1", started, first)
+	}
+}
@@ -0,0 +1,81 @@
+//go:build linux
+
+package monitor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Proc connector constants (linux/connector.h, linux/cn_proc.h)
+const (
+	cnIdxProc         = 0x1
+	cnValProc         = 0x1
+	procCnMcastListen = 0x1
+	procEventExec     = 0x00000002
+
+	nlmsgHdrLen = 16
+	cnMsgLen    = 20
+)
+
+// openProcConnector subscribes to the kernel proc connector. It usually
+// requires CAP_NET_ADMIN, callers must be ready to fall back to scanning.
+func openProcConnector() (int, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, unix.NETLINK_CONNECTOR)
+	if err != nil {
+		return -1, fmt.Errorf("openProcConnector socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: cnIdxProc}); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("openProcConnector bind: %w", err)
+	}
+
+	// nlmsghdr + cn_msg + PROC_CN_MCAST_LISTEN
+	msg := make([]byte, nlmsgHdrLen+cnMsgLen+4)
+	ne := binary.NativeEndian
+	ne.PutUint32(msg[0:], uint32(len(msg)))
+	ne.PutUint16(msg[4:], unix.NLMSG_DONE)
+	ne.PutUint32(msg[nlmsgHdrLen+0:], cnIdxProc)
+	ne.PutUint32(msg[nlmsgHdrLen+4:], cnValProc)
+	ne.PutUint16(msg[nlmsgHdrLen+16:], 4)
+	ne.PutUint32(msg[nlmsgHdrLen+cnMsgLen:], procCnMcastListen)
+	if err := unix.Sendto(fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("openProcConnector subscribe: %w", err)
+	}
+
+	// Wake up regularly so the reader can notice cancellation
+	tv := unix.Timeval{Sec: 1}
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("openProcConnector timeout: %w", err)
+	}
+	return fd, nil
+}
+
+// parseExecEvents extracts the thread group ids of exec events from a netlink datagram
+func parseExecEvents(buf []byte) []int32 {
+	msgs, err := syscall.ParseNetlinkMessage(buf)
+	if err != nil {
+		return nil
+	}
+	ne := binary.NativeEndian
+	var pids []int32
+	for _, m := range msgs {
+		data := m.Data
+		// cn_msg header, then proc_event: what, cpu, timestamp_ns, event data
+		if len(data) < cnMsgLen+16+8 {
+			continue
+		}
+		ev := data[cnMsgLen:]
+		if ne.Uint32(ev[0:]) != procEventExec {
+			continue
+		}
+		// exec_proc_event: process_pid, process_tgid
+		pids = append(pids, int32(ne.Uint32(ev[16+4:])))
+	}
+	return pids
+}
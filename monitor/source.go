@@ -0,0 +1,80 @@
+package monitor
+
+import (
+	"context"
+	"time"
+)
+
+// ProcessInfo describes a running process as reported by a ProcessSource
+type ProcessInfo struct {
+	PID        int32
+	PPID       int32
+	Exe        string
+	Name       string
+	CreateTime time.Time
+}
+
+// EventKind tells whether a process appeared or disappeared
+type EventKind int
+
+const (
+	EventStart EventKind = iota
+	EventExit
+)
+
+// Event is emitted by a ProcessSource when a process starts or a watched process exits
+type Event struct {
+	Kind    EventKind
+	Process ProcessInfo
+	Time    time.Time
+}
+
+// ProcessSource abstracts the OS process table so the monitor can be driven
+// by different backends (gopsutil polling, in-memory fake, ...).
+type ProcessSource interface {
+	// Processes lists the processes currently running.
+	Processes() ([]ProcessInfo, error)
+	// Subscribe streams events until ctx is done. Every process already running
+	// at subscription time is reported once as started, then new processes as
+	// they appear. Exit events are only emitted for processes passed to Watch.
+	Subscribe(ctx context.Context) (<-chan Event, error)
+	// Watch asks the source to report the exit of p.
+	Watch(p ProcessInfo) error
+	// Lookup describes a running process, used to walk up the process tree.
+	Lookup(pid int32) (ProcessInfo, error)
+}
+
+// Tunable is implemented by the sources that poll the process table
+type Tunable interface {
+	// SetIntervals changes the scan and exit check intervals, taking effect
+	// after the current ones
+	SetIntervals(scan, exit time.Duration)
+}
+
+// Terminator is implemented by the sources that can stop a process
+type Terminator interface {
+	// Terminate asks p to exit; its exit is then reported as usual
+	Terminate(p ProcessInfo) error
+}
+
+// sameProcess reports whether two infos designate the same process instance,
+// guarding against PID reuse when both create times are known.
+func sameProcess(a, b ProcessInfo) bool {
+	if a.PID != b.PID {
+		return false
+	}
+	if a.CreateTime.IsZero() || b.CreateTime.IsZero() {
+		return true
+	}
+	return a.CreateTime.Equal(b.CreateTime)
+}
+
+// sendEvent delivers ev unless ctx is cancelled first
+func sendEvent(ctx context.Context, ch chan<- Event, ev Event) bool {
+	select {
+	case ch <- ev:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
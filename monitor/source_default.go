@@ -0,0 +1,8 @@
+//go:build !linux
+
+package monitor
+
+// NewDefaultSource returns the most efficient source for the platform
+func NewDefaultSource() ProcessSource {
+	return NewGopsutilSource()
+}
@@ -0,0 +1,120 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FakeSource is an in-memory ProcessSource. Processes are added and removed
+// explicitly with Start and Exit, which makes the monitor fully deterministic.
+type FakeSource struct {
+	mu      sync.Mutex
+	procs   map[int32]ProcessInfo
+	watched map[int32]struct{}
+	subs    []fakeSub
+}
+
+type fakeSub struct {
+	ctx context.Context
+	ch  chan Event
+}
+
+func NewFakeSource() *FakeSource {
+	return &FakeSource{
+		procs:   make(map[int32]ProcessInfo),
+		watched: make(map[int32]struct{}),
+	}
+}
+
+func (f *FakeSource) Processes() ([]ProcessInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	infos := make([]ProcessInfo, 0, len(f.procs))
+	for _, p := range f.procs {
+		infos = append(infos, p)
+	}
+	return infos, nil
+}
+
+func (f *FakeSource) Subscribe(ctx context.Context) (<-chan Event, error) {
+	f.mu.Lock()
+	initial := make([]ProcessInfo, 0, len(f.procs))
+	for _, p := range f.procs {
+		initial = append(initial, p)
+	}
+	// Room for the initial snapshot so Subscribe never blocks
+	ch := make(chan Event, len(initial)+64)
+	now := time.Now()
+	for _, p := range initial {
+		ch <- Event{Kind: EventStart, Process: p, Time: now}
+	}
+	f.subs = append(f.subs, fakeSub{ctx: ctx, ch: ch})
+	f.mu.Unlock()
+	return ch, nil
+}
+
+func (f *FakeSource) Lookup(pid int32) (ProcessInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.procs[pid]
+	if !ok {
+		return ProcessInfo{}, fmt.Errorf("process %d not found", pid)
+	}
+	return p, nil
+}
+
+func (f *FakeSource) Watch(p ProcessInfo) error {
+	f.mu.Lock()
+	f.watched[p.PID] = struct{}{}
+	f.mu.Unlock()
+	return nil
+}
+
+// Start registers p as running and notifies subscribers
+func (f *FakeSource) Start(p ProcessInfo, at time.Time) {
+	f.mu.Lock()
+	f.procs[p.PID] = p
+	subs := f.activeSubs()
+	f.mu.Unlock()
+	f.broadcast(subs, Event{Kind: EventStart, Process: p, Time: at})
+}
+
+// Exit removes pid and notifies subscribers if it was watched
+func (f *FakeSource) Exit(pid int32, at time.Time) {
+	f.mu.Lock()
+	p, ok := f.procs[pid]
+	delete(f.procs, pid)
+	_, watched := f.watched[pid]
+	delete(f.watched, pid)
+	subs := f.activeSubs()
+	f.mu.Unlock()
+	if ok && watched {
+		f.broadcast(subs, Event{Kind: EventExit, Process: p, Time: at})
+	}
+}
+
+// Terminate implements Terminator: the process exits right away
+func (f *FakeSource) Terminate(p ProcessInfo) error {
+	f.Exit(p.PID, time.Now())
+	return nil
+}
+
+// activeSubs drops cancelled subscriptions; must be called with f.mu held
+func (f *FakeSource) activeSubs() []fakeSub {
+	alive := f.subs[:0]
+	for _, s := range f.subs {
+		if s.ctx.Err() == nil {
+			alive = append(alive, s)
+		}
+	}
+	f.subs = alive
+	return append([]fakeSub(nil), alive...)
+}
+
+func (f *FakeSource) broadcast(subs []fakeSub, ev Event) {
+	for _, s := range subs {
+		sendEvent(s.ctx, s.ch, ev)
+	}
+}
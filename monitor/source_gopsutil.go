@@ -0,0 +1,216 @@
+package monitor
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/process"
+)
+
+// GopsutilSource polls the process table through gopsutil.
+type GopsutilSource struct {
+	// ScanInterval is the delay between two scans of the process table
+	ScanInterval time.Duration
+	// ExitInterval is the delay between two liveness checks of watched processes
+	ExitInterval time.Duration
+
+	mu      sync.Mutex
+	watched map[int32]ProcessInfo
+}
+
+func NewGopsutilSource() *GopsutilSource {
+	return &GopsutilSource{
+		ScanInterval: 1 * time.Second,
+		ExitInterval: 500 * time.Millisecond,
+		watched:      make(map[int32]ProcessInfo),
+	}
+}
+
+func (s *GopsutilSource) Processes() ([]ProcessInfo, error) {
+	procs, err := process.Processes()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]ProcessInfo, 0, len(procs))
+	for _, p := range procs {
+		if p == nil {
+			continue
+		}
+		info, err := processInfo(p)
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// processInfo reads the fields of ProcessInfo, failing when the executable
+// path is not accessible (system processes, insufficient rights, ...)
+func processInfo(p *process.Process) (ProcessInfo, error) {
+	exe, err := p.Exe()
+	if err != nil {
+		return ProcessInfo{}, err
+	}
+	name, err := p.Name()
+	if err != nil || name == "" {
+		name = filepath.Base(exe)
+	}
+	info := ProcessInfo{PID: p.Pid, Exe: exe, Name: name}
+	if ppid, err := p.Ppid(); err == nil {
+		info.PPID = ppid
+	}
+	if ms, err := p.CreateTime(); err == nil {
+		info.CreateTime = time.UnixMilli(ms)
+	}
+	return info, nil
+}
+
+func (s *GopsutilSource) Lookup(pid int32) (ProcessInfo, error) {
+	p, err := process.NewProcess(pid)
+	if err != nil {
+		return ProcessInfo{}, err
+	}
+	return processInfo(p)
+}
+
+func (s *GopsutilSource) Watch(p ProcessInfo) error {
+	s.mu.Lock()
+	s.watched[p.PID] = p
+	s.mu.Unlock()
+	return nil
+}
+
+// Terminate implements Terminator, unless the pid now belongs to another process
+func (s *GopsutilSource) Terminate(info ProcessInfo) error {
+	if !processAlive(info) {
+		return nil
+	}
+	p, err := process.NewProcess(info.PID)
+	if err != nil {
+		return err
+	}
+	return p.Terminate()
+}
+
+// SetIntervals implements Tunable
+func (s *GopsutilSource) SetIntervals(scan, exit time.Duration) {
+	s.mu.Lock()
+	s.ScanInterval = scan
+	s.ExitInterval = exit
+	s.mu.Unlock()
+}
+
+func (s *GopsutilSource) intervals() (scan, exit time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ScanInterval, s.ExitInterval
+}
+
+// resetTicker moves t to the interval d when it differs from *cur
+func resetTicker(t *time.Ticker, cur *time.Duration, d time.Duration) {
+	if d > 0 && d != *cur {
+		*cur = d
+		t.Reset(d)
+	}
+}
+
+func (s *GopsutilSource) Subscribe(ctx context.Context) (<-chan Event, error) {
+	ch := make(chan Event, 64)
+	go func() {
+		defer close(ch)
+		scanEvery, exitEvery := s.intervals()
+		scan := time.NewTicker(scanEvery)
+		defer scan.Stop()
+		exit := time.NewTicker(exitEvery)
+		defer exit.Stop()
+
+		known := make(map[int32]ProcessInfo)
+		if !s.scan(ctx, ch, known) {
+			return
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-scan.C:
+				if !s.scan(ctx, ch, known) {
+					return
+				}
+				d, _ := s.intervals()
+				resetTicker(scan, &scanEvery, d)
+			case <-exit.C:
+				if !s.checkExits(ctx, ch) {
+					return
+				}
+				_, d := s.intervals()
+				resetTicker(exit, &exitEvery, d)
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// scan diffs the process table against known and emits start events for new processes
+func (s *GopsutilSource) scan(ctx context.Context, ch chan<- Event, known map[int32]ProcessInfo) bool {
+	infos, err := s.Processes()
+	if err != nil {
+		return true
+	}
+	now := time.Now()
+	seen := make(map[int32]struct{}, len(infos))
+	for _, info := range infos {
+		seen[info.PID] = struct{}{}
+		if prev, ok := known[info.PID]; ok && sameProcess(prev, info) {
+			continue
+		}
+		known[info.PID] = info
+		if !sendEvent(ctx, ch, Event{Kind: EventStart, Process: info, Time: now}) {
+			return false
+		}
+	}
+	for pid := range known {
+		if _, ok := seen[pid]; !ok {
+			delete(known, pid)
+		}
+	}
+	return true
+}
+
+// checkExits emits exit events for watched processes that are gone
+func (s *GopsutilSource) checkExits(ctx context.Context, ch chan<- Event) bool {
+	s.mu.Lock()
+	var gone []ProcessInfo
+	for pid, info := range s.watched {
+		if !processAlive(info) {
+			gone = append(gone, info)
+			delete(s.watched, pid)
+		}
+	}
+	s.mu.Unlock()
+
+	now := time.Now()
+	for _, info := range gone {
+		if !sendEvent(ctx, ch, Event{Kind: EventExit, Process: info, Time: now}) {
+			return false
+		}
+	}
+	return true
+}
+
+func processAlive(info ProcessInfo) bool {
+	p, err := process.NewProcess(info.PID)
+	if err != nil {
+		return false
+	}
+	if info.CreateTime.IsZero() {
+		return true
+	}
+	ms, err := p.CreateTime()
+	if err != nil {
+		return true
+	}
+	return time.UnixMilli(ms).Equal(info.CreateTime)
+}
@@ -0,0 +1,321 @@
+//go:build linux
+
+package monitor
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/process"
+	"golang.org/x/sys/unix"
+)
+
+// PidfdSource detects process exits with pidfd_open(2) and poll(2), so an exit
+// is reported as soon as it happens without any polling. Process starts come
+// from the netlink proc connector when it is available, otherwise from a
+// regular scan of the process table.
+type PidfdSource struct {
+	// scanner lists processes and takes over when pidfd or netlink fail
+	scanner *GopsutilSource
+
+	mu     sync.Mutex
+	pidfds map[int32]pidfdEntry
+	// wakeFd is the eventfd interrupting poll(2), -1 outside a subscription
+	wakeFd int
+}
+
+type pidfdEntry struct {
+	info ProcessInfo
+	fd   int
+}
+
+func NewPidfdSource() (*PidfdSource, error) {
+	fd, err := unix.PidfdOpen(os.Getpid(), 0)
+	if err != nil {
+		return nil, fmt.Errorf("NewPidfdSource: %w", err)
+	}
+	unix.Close(fd)
+
+	return &PidfdSource{
+		scanner: NewGopsutilSource(),
+		pidfds:  make(map[int32]pidfdEntry),
+		wakeFd:  -1,
+	}, nil
+}
+
+// NewDefaultSource returns the most efficient source for the platform
+func NewDefaultSource() ProcessSource {
+	s, err := NewPidfdSource()
+	if err != nil {
+		log.Println("pidfd indisponible, retour au polling:", err)
+		return NewGopsutilSource()
+	}
+	return s
+}
+
+// SetIntervals implements Tunable; the scan interval only matters when the
+// proc connector is not available
+func (s *PidfdSource) SetIntervals(scan, exit time.Duration) {
+	s.scanner.SetIntervals(scan, exit)
+}
+
+// Terminate implements Terminator
+func (s *PidfdSource) Terminate(p ProcessInfo) error {
+	return s.scanner.Terminate(p)
+}
+
+func (s *PidfdSource) Processes() ([]ProcessInfo, error) {
+	return s.scanner.Processes()
+}
+
+func (s *PidfdSource) Lookup(pid int32) (ProcessInfo, error) {
+	return s.scanner.Lookup(pid)
+}
+
+func (s *PidfdSource) Watch(p ProcessInfo) error {
+	fd, err := unix.PidfdOpen(int(p.PID), 0)
+	if err != nil {
+		// Already gone or not allowed: let the scanner report it
+		return s.scanner.Watch(p)
+	}
+	// The PID may have been reused between the event and pidfd_open
+	if !processAlive(p) {
+		unix.Close(fd)
+		return s.scanner.Watch(p)
+	}
+
+	s.mu.Lock()
+	if old, ok := s.pidfds[p.PID]; ok {
+		unix.Close(old.fd)
+	}
+	s.pidfds[p.PID] = pidfdEntry{info: p, fd: fd}
+	s.mu.Unlock()
+	s.wake()
+	return nil
+}
+
+func (s *PidfdSource) Subscribe(ctx context.Context) (<-chan Event, error) {
+	wake, err := unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("Subscribe eventfd: %w", err)
+	}
+	s.mu.Lock()
+	s.wakeFd = wake
+	s.mu.Unlock()
+
+	ch := make(chan Event, 64)
+	conn, err := openProcConnector()
+	if err != nil {
+		log.Println("proc connector indisponible, détection des lancements par scan:", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.pollExits(ctx, ch)
+	}()
+	go func() {
+		defer wg.Done()
+		if conn >= 0 {
+			s.readExecEvents(ctx, ch, conn)
+		} else {
+			s.scanLoop(ctx, ch)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		s.wake()
+	}()
+	go func() {
+		wg.Wait()
+		s.release()
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// release closes the eventfd and the pidfds once the subscription is over
+func (s *PidfdSource) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unix.Close(s.wakeFd)
+	s.wakeFd = -1
+	for pid, e := range s.pidfds {
+		unix.Close(e.fd)
+		delete(s.pidfds, pid)
+	}
+}
+
+func (s *PidfdSource) wake() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wakeFd < 0 {
+		return
+	}
+	var b [8]byte
+	binary.NativeEndian.PutUint64(b[:], 1)
+	unix.Write(s.wakeFd, b[:])
+}
+
+// pollExits blocks in poll(2) on every watched pidfd plus the wake eventfd,
+// which is signalled when the set changes or the subscription ends.
+func (s *PidfdSource) pollExits(ctx context.Context, ch chan<- Event) {
+	for {
+		s.mu.Lock()
+		fds := []unix.PollFd{{Fd: int32(s.wakeFd), Events: unix.POLLIN}}
+		entries := make([]pidfdEntry, 0, len(s.pidfds))
+		for _, e := range s.pidfds {
+			fds = append(fds, unix.PollFd{Fd: int32(e.fd), Events: unix.POLLIN})
+			entries = append(entries, e)
+		}
+		s.mu.Unlock()
+
+		if ctx.Err() != nil {
+			return
+		}
+		if _, err := unix.Poll(fds, -1); err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+			log.Println("pollExits:", err)
+			return
+		}
+		if fds[0].Revents != 0 {
+			var b [8]byte
+			unix.Read(int(fds[0].Fd), b[:])
+		}
+
+		now := time.Now()
+		for i, e := range entries {
+			if fds[i+1].Revents == 0 {
+				continue
+			}
+			s.mu.Lock()
+			cur, ok := s.pidfds[e.info.PID]
+			owned := ok && cur.fd == e.fd
+			if owned {
+				delete(s.pidfds, e.info.PID)
+			}
+			s.mu.Unlock()
+			if !owned {
+				continue
+			}
+			unix.Close(e.fd)
+			if !sendEvent(ctx, ch, Event{Kind: EventExit, Process: e.info, Time: now}) {
+				return
+			}
+		}
+	}
+}
+
+// readExecEvents reports every running process, then each process that
+// calls exec(2), as notified by the proc connector.
+func (s *PidfdSource) readExecEvents(ctx context.Context, ch chan<- Event, conn int) {
+	defer unix.Close(conn)
+	if !s.emitSnapshot(ctx, ch) {
+		return
+	}
+	_, exitEvery := s.scanner.intervals()
+	exitTicker := time.NewTicker(exitEvery)
+	defer exitTicker.Stop()
+
+	buf := make([]byte, 8192)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-exitTicker.C:
+			if !s.scanner.checkExits(ctx, ch) {
+				return
+			}
+			_, d := s.scanner.intervals()
+			resetTicker(exitTicker, &exitEvery, d)
+		default:
+		}
+
+		n, _, err := unix.Recvfrom(conn, buf, 0)
+		if err != nil {
+			switch {
+			case errors.Is(err, unix.EAGAIN), errors.Is(err, unix.EINTR):
+			case errors.Is(err, unix.ENOBUFS):
+				// Events were dropped by the kernel: resynchronise
+				if !s.emitSnapshot(ctx, ch) {
+					return
+				}
+			default:
+				log.Println("readExecEvents:", err)
+				s.scanLoop(ctx, ch)
+				return
+			}
+			continue
+		}
+
+		now := time.Now()
+		for _, pid := range parseExecEvents(buf[:n]) {
+			p, err := process.NewProcess(pid)
+			if err != nil {
+				continue
+			}
+			info, err := processInfo(p)
+			if err != nil {
+				continue
+			}
+			if !sendEvent(ctx, ch, Event{Kind: EventStart, Process: info, Time: now}) {
+				return
+			}
+		}
+	}
+}
+
+func (s *PidfdSource) emitSnapshot(ctx context.Context, ch chan<- Event) bool {
+	infos, err := s.scanner.Processes()
+	if err != nil {
+		return true
+	}
+	now := time.Now()
+	for _, info := range infos {
+		if !sendEvent(ctx, ch, Event{Kind: EventStart, Process: info, Time: now}) {
+			return false
+		}
+	}
+	return true
+}
+
+// scanLoop detects starts by scanning, and exits of processes that could not get a pidfd
+func (s *PidfdSource) scanLoop(ctx context.Context, ch chan<- Event) {
+	scanEvery, exitEvery := s.scanner.intervals()
+	scan := time.NewTicker(scanEvery)
+	defer scan.Stop()
+	exit := time.NewTicker(exitEvery)
+	defer exit.Stop()
+
+	known := make(map[int32]ProcessInfo)
+	if !s.scanner.scan(ctx, ch, known) {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-scan.C:
+			if !s.scanner.scan(ctx, ch, known) {
+				return
+			}
+			d, _ := s.scanner.intervals()
+			resetTicker(scan, &scanEvery, d)
+		case <-exit.C:
+			if !s.scanner.checkExits(ctx, ch) {
+				return
+			}
+			_, d := s.scanner.intervals()
+			resetTicker(exit, &exitEvery, d)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+//go:build linux
+
+package monitor
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// openFds counts the open file descriptors, once gopsutil's are closed: it
+// leaves the pidfds of os.FindProcess to the finalizers
+func openFds(t *testing.T) int {
+	t.Helper()
+	runtime.GC()
+	time.Sleep(100 * time.Millisecond)
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip(err)
+	}
+	return len(entries)
+}
+
+func TestPidfdSourceReleasesFds(t *testing.T) {
+	s, err := NewPidfdSource()
+	if err != nil {
+		t.Skip(err)
+	}
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	defer func() { cmd.Process.Kill(); cmd.Wait() }()
+	child, err := s.Lookup(int32(cmd.Process.Pid))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := openFds(t)
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		ch, err := s.Subscribe(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Watch(child); err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		for timeout := time.After(5 * time.Second); ; {
+			select {
+			case _, ok := <-ch:
+				if ok {
+					continue
+				}
+			case <-timeout:
+				t.Fatal("the subscription did not end")
+			}
+			break
+		}
+	}
+	if after := openFds(t); after > before {
+		t.Errorf("%d file descriptors open after the subscriptions, %d before", after, before)
+	}
+}
@@ -0,0 +1,203 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"main/events"
+	"main/limits"
+	"main/monitor"
+	"main/query"
+)
+
+// Categories are the kinds of notifications shown
+type Categories struct {
+	// Sessions announces the games starting, and sums the sessions up when they end
+	Sessions bool
+	// Limits warns before a play-time limit is reached, once it is, and when a
+	// game runs outside its allowed windows
+	Limits bool
+	// Milestones congratulates when the total play time of a game crosses a
+	// number of hours
+	Milestones bool
+	// Backups reports the automatic backups that failed
+	Backups bool
+}
+
+// milestoneHours are the total play times of a game worth a notification
+var milestoneHours = []int{1, 10, 25, 50, 100, 250, 500, 1000}
+
+// Dispatcher turns the events of the bus into notifications
+type Dispatcher struct {
+	db       *query.Database
+	bus      *events.Bus
+	notifier Notifier
+
+	mu         sync.Mutex
+	categories Categories
+	// stored is the play time of each running game before its session, to
+	// find the milestones crossed when it ends
+	stored map[int64]float64
+}
+
+// NewDispatcher returns the dispatcher of the events of bus to n; db gives the
+// total play times for the milestones
+func NewDispatcher(db *query.Database, bus *events.Bus, n Notifier) *Dispatcher {
+	return &Dispatcher{db: db, bus: bus, notifier: n, stored: make(map[int64]float64)}
+}
+
+// Configure sets the categories shown, of a running dispatcher too
+func (d *Dispatcher) Configure(c Categories) {
+	d.mu.Lock()
+	d.categories = c
+	d.mu.Unlock()
+}
+
+// Notify shows n whatever the categories, for messages asked by the user
+func (d *Dispatcher) Notify(n Notification) {
+	if err := d.notifier.Notify(n); err != nil {
+		log.Println("Notification:", err)
+	}
+}
+
+// Run notifies the events until ctx is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	sub, cancel := d.bus.Subscribe(64)
+	defer cancel()
+	for {
+		select {
+		case <-ctx.Done():
+			// The events published before, the sessions closed at shutdown
+			// included, are still handled
+			for {
+				select {
+				case ev := <-sub:
+					d.handle(ev)
+				default:
+					return
+				}
+			}
+		case ev := <-sub:
+			d.handle(ev)
+		}
+	}
+}
+
+func (d *Dispatcher) handle(ev events.Event) {
+	d.mu.Lock()
+	c := d.categories
+	d.mu.Unlock()
+
+	switch data := ev.Data.(type) {
+	case monitor.Playing:
+		switch ev.Type {
+		case events.SessionStarted:
+			d.sessionStarted(data, c)
+		case events.SessionEnded:
+			d.sessionEnded(data, c)
+		}
+	case limits.Budget:
+		if !c.Limits {
+			return
+		}
+		switch ev.Type {
+		case events.LimitWarning:
+			d.Notify(Notification{Title: "Plus que " + formatDuration(data.Remaining), Body: data.Message("Limite bientôt atteinte")})
+		case events.LimitExceeded:
+			body := data.Message("Limite atteinte")
+			if data.TerminateAt != nil {
+				body += fmt.Sprintf(", le jeu sera fermé à %s", data.TerminateAt.Format("15:04"))
+			}
+			d.Notify(Notification{Title: "Limite atteinte", Body: body, Urgent: true})
+		}
+	case query.CurfewViolation:
+		if !c.Limits || ev.Type != events.CurfewViolation {
+			return
+		}
+		body := data.Game + " est ouvert en dehors des horaires autorisés"
+		if data.Action == query.ActionTerminate {
+			body += ", il va être fermé"
+		}
+		d.Notify(Notification{Title: "Hors des horaires autorisés", Body: body, Urgent: true})
+	case map[string]string:
+		if c.Backups && ev.Type == events.BackupFailed {
+			d.Notify(Notification{Title: "Échec de la sauvegarde automatique", Body: data["error"], Urgent: true})
+		}
+	}
+}
+
+func (d *Dispatcher) sessionStarted(p monitor.Playing, c Categories) {
+	// The play time before the session, for the milestones
+	if total, err := d.totalTime(p.GameID); err != nil {
+		log.Println("Notification:", err)
+	} else {
+		d.mu.Lock()
+		d.stored[p.GameID] = total
+		d.mu.Unlock()
+	}
+	if c.Sessions {
+		d.Notify(Notification{Title: p.Game, Body: "Session démarrée à " + p.StartTime.Format("15:04")})
+	}
+}
+
+func (d *Dispatcher) sessionEnded(p monitor.Playing, c Categories) {
+	if c.Sessions {
+		body := "Session de " + formatDuration(p.Elapsed)
+		if p.Idle >= 60 {
+			body += fmt.Sprintf(", dont %s d'inactivité", formatDuration(p.Idle))
+		}
+		d.Notify(Notification{Title: p.Game, Body: body})
+	}
+
+	d.mu.Lock()
+	before, ok := d.stored[p.GameID]
+	delete(d.stored, p.GameID)
+	d.mu.Unlock()
+	// Sessions started before the dispatcher are skipped
+	if !ok || !c.Milestones {
+		return
+	}
+	after, err := d.totalTime(p.GameID)
+	if err != nil {
+		log.Println("Notification:", err)
+		return
+	}
+	// The highest milestone crossed by the session, if any
+	reached := 0
+	for _, h := range milestoneHours {
+		if s := float64(h * 3600); before < s && after >= s {
+			reached = h
+		}
+	}
+	if reached > 0 {
+		d.Notify(Notification{Title: "Cap franchi", Body: fmt.Sprintf("%d h de jeu sur %s", reached, p.Game)})
+	}
+}
+
+// totalTime returns the stored play time of the game gameID, in seconds
+func (d *Dispatcher) totalTime(gameID int64) (float64, error) {
+	items, err := d.db.GetSummaryBetween("0001-01-01", "9999-12-31", query.MetricTotal)
+	if err != nil {
+		return 0, err
+	}
+	for _, it := range items {
+		if it.GameID == gameID {
+			return it.Seconds, nil
+		}
+	}
+	return 0, nil
+}
+
+// formatDuration writes seconds as "1 h 05", "12 min" or "40 s"
+func formatDuration(seconds float64) string {
+	if seconds < 60 {
+		return fmt.Sprintf("%d s", int(seconds))
+	}
+	m := int(seconds / 60)
+	if m >= 60 {
+		return fmt.Sprintf("%d h %02d", m/60, m%60)
+	}
+	return fmt.Sprintf("%d min", m)
+}
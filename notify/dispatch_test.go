@@ -0,0 +1,74 @@
+package notify
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"main/entity"
+	"main/events"
+	"main/monitor"
+	"main/query"
+)
+
+func TestDispatcher(t *testing.T) {
+	db, err := query.OpenDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	gameID, err := db.EnsureGame("game.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 3, 2, 10, 0, 0, 0, time.Local)
+	save := func(from, to time.Time) {
+		t.Helper()
+		if err := db.SaveActivity(entity.ActivityRecord{ProcessName: "game.exe", StartTime: from, EndTime: to}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	save(start, start.Add(50*time.Minute))
+
+	fake := &Fake{}
+	d := NewDispatcher(db, events.NewBus(), fake)
+	session := monitor.Playing{GameID: gameID, Game: "Game", StartTime: start.Add(time.Hour)}
+
+	// Nothing is shown without category
+	d.handle(events.Event{Type: events.BackupFailed, Data: map[string]string{"error": "disk full"}})
+	d.handle(events.Event{Type: events.SessionStarted, Data: session})
+	if sent := fake.Sent(); len(sent) != 0 {
+		t.Fatalf("sent %+v without category", sent)
+	}
+
+	d.Configure(Categories{Sessions: true, Milestones: true, Backups: true})
+	d.handle(events.Event{Type: events.SessionStarted, Data: session})
+	// The session crosses the first hour
+	save(session.StartTime, session.StartTime.Add(15*time.Minute))
+	session.Elapsed, session.Idle = 15*60, 2*60
+	d.handle(events.Event{Type: events.SessionEnded, Data: session})
+	d.handle(events.Event{Type: events.BackupFailed, Data: map[string]string{"error": "disk full"}})
+
+	want := []Notification{
+		{Title: "Game", Body: "Session démarrée à 11:00"},
+		{Title: "Game", Body: "Session de 15 min, dont 2 min d'inactivité"},
+		{Title: "Cap franchi", Body: "1 h de jeu sur Game"},
+		{Title: "Échec de la sauvegarde automatique", Body: "disk full", Urgent: true},
+	}
+	sent := fake.Sent()
+	if len(sent) != len(want) {
+		t.Fatalf("sent %+v, want %+v", sent, want)
+	}
+	for i := range want {
+		if sent[i] != want[i] {
+			t.Errorf("notification %d is %+v, want %+v", i, sent[i], want[i])
+		}
+	}
+
+	// A failing notifier is only logged
+	fake.SetError(errors.New("no notification server"))
+	d.Notify(Notification{Title: "À propos"})
+	if len(fake.Sent()) != len(want) {
+		t.Errorf("a failed notification was recorded")
+	}
+}
@@ -0,0 +1,77 @@
+// Package notify shows desktop notifications: session summaries, limit and
+// curfew warnings, play-time milestones and backup failures.
+package notify
+
+import (
+	"errors"
+	"log"
+	"sync"
+)
+
+// ErrUnsupported is returned when the desktop can't show notifications
+var ErrUnsupported = errors.New("desktop notifications unsupported")
+
+// appName is the application shown by the notification server
+const appName = "SteamStracker"
+
+// Notification is a message for the user
+type Notification struct {
+	Title string
+	Body  string
+	// Urgent notifications stay until dismissed where the desktop allows it
+	Urgent bool
+}
+
+// Notifier shows notifications to the user
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// NewDefault returns the notifier of the desktop when there is one, and the
+// log otherwise
+func NewDefault() Notifier {
+	if n, err := NewSystemNotifier(); err == nil {
+		return n
+	}
+	return Log{}
+}
+
+// Log writes the notifications to the log, for systems without notification
+// server or running headless
+type Log struct{}
+
+func (Log) Notify(n Notification) error {
+	log.Printf("Notification: %s - %s\n", n.Title, n.Body)
+	return nil
+}
+
+// Fake records the notifications instead of showing them
+type Fake struct {
+	mu   sync.Mutex
+	sent []Notification
+	err  error
+}
+
+func (f *Fake) Notify(n Notification) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.sent = append(f.sent, n)
+	return nil
+}
+
+// Sent returns the notifications received so far
+func (f *Fake) Sent() []Notification {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Notification(nil), f.sent...)
+}
+
+// SetError makes the next notifications fail with err, nil to accept them again
+func (f *Fake) SetError(err error) {
+	f.mu.Lock()
+	f.err = err
+	f.mu.Unlock()
+}
@@ -0,0 +1,59 @@
+//go:build linux
+
+package notify
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// The freedesktop notification server of the session bus
+const (
+	dbusDest   = "org.freedesktop.Notifications"
+	dbusPath   = "/org/freedesktop/Notifications"
+	dbusMethod = "org.freedesktop.Notifications.Notify"
+)
+
+// DBus sends the notifications to the freedesktop notification server through
+// gdbus, like the idle detection
+type DBus struct{}
+
+// NewSystemNotifier returns the notifier of the session when its notification
+// server answers
+func NewSystemNotifier() (Notifier, error) {
+	err := exec.Command("gdbus", "call", "--session", "--dest", dbusDest, "--object-path", dbusPath,
+		"--method", "org.freedesktop.Notifications.GetServerInformation").Run()
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+	return DBus{}, nil
+}
+
+func (DBus) Notify(n Notification) error {
+	// Low, normal or critical
+	urgency := 1
+	if n.Urgent {
+		urgency = 2
+	}
+	// Notify(app_name, replaces_id, app_icon, summary, body, actions, hints, expire_timeout)
+	args := []string{"call", "--session", "--dest", dbusDest, "--object-path", dbusPath, "--method", dbusMethod,
+		quote(appName), "uint32 0", quote(""), quote(n.Title), quote(n.Body), "@as []",
+		fmt.Sprintf("{'urgency': <byte %d>}", urgency), "int32 -1"}
+	if out, err := exec.Command("gdbus", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("gdbus: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// quote returns s in the GVariant text format, so that gdbus doesn't parse it
+func quote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`)
+	return "'" + r.Replace(s) + "'"
+}
+
+// Dialog is only available on Windows: the notifications of the session are
+// shown instead
+func Dialog(n Notification) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !windows
+
+package notify
+
+func NewSystemNotifier() (Notifier, error) {
+	return nil, ErrUnsupported
+}
+
+// Dialog is only available on Windows
+func Dialog(n Notification) error {
+	return ErrUnsupported
+}
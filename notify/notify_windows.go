@@ -0,0 +1,44 @@
+//go:build windows
+
+package notify
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	user32          = windows.NewLazySystemDLL("user32.dll")
+	procMessageBoxW = user32.NewProc("MessageBoxW")
+)
+
+const (
+	mbIconInformation = 0x40
+	mbSetForeground   = 0x10000
+)
+
+func NewSystemNotifier() (Notifier, error) {
+	return nil, ErrUnsupported
+}
+
+// Dialog shows n in a message box and returns once it is closed
+func Dialog(n Notification) error {
+	if err := procMessageBoxW.Find(); err != nil {
+		return ErrUnsupported
+	}
+	title, err := windows.UTF16PtrFromString(n.Title)
+	if err != nil {
+		return err
+	}
+	body, err := windows.UTF16PtrFromString(n.Body)
+	if err != nil {
+		return err
+	}
+	ret, _, callErr := procMessageBoxW.Call(0, uintptr(unsafe.Pointer(body)), uintptr(unsafe.Pointer(title)), mbIconInformation|mbSetForeground)
+	if ret == 0 {
+		return fmt.Errorf("MessageBoxW: %w", callErr)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package query
+
+import (
+	"fmt"
+	"main/entity"
+	"time"
+)
+
+// operations for in-progress sessions, checkpointed so they survive a crash
+
+type activeSessionRow struct {
+	ID            int64  `db:"id"`
+	ProcessName   string `db:"process_name"`
+	StartTime     string `db:"start_time"`
+	LastHeartbeat string `db:"last_heartbeat"`
+	StartSource   string `db:"start_source"`
+	GameID        int64  `db:"game_id"`
+}
+
+// InsertActiveSession records a running session and returns its id
+func (db *Database) InsertActiveSession(activity entity.ActivityRecord) (int64, error) {
+	var gameID any
+	if activity.GameID > 0 {
+		gameID = activity.GameID
+	}
+	res, err := db.Exec(`INSERT INTO active_sessions (process_name, start_time, last_heartbeat, start_source, game_id) VALUES (?, ?, ?, ?, ?)`,
+		activity.ProcessName,
+		activity.StartTime.Format(time.RFC3339),
+		time.Now().Format(time.RFC3339),
+		activity.StartSource,
+		gameID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// HeartbeatActiveSession moves the checkpoint of a running session to at
+func (db *Database) HeartbeatActiveSession(id int64, at time.Time) error {
+	_, err := db.Exec(`UPDATE active_sessions SET last_heartbeat = ? WHERE id = ?`, at.Format(time.RFC3339), id)
+	return err
+}
+
+func (db *Database) DeleteActiveSession(id int64) error {
+	_, err := db.Exec(`DELETE FROM active_sessions WHERE id = ?`, id)
+	return err
+}
+
+// RecoverActiveSessions turns orphaned active sessions into activities ending at
+// their last heartbeat. It must run before any new session is started.
+func (db *Database) RecoverActiveSessions() (int, error) {
+	rows := []activeSessionRow{}
+	if err := db.Select(&rows, `SELECT id, process_name, start_time, last_heartbeat, COALESCE(start_source,'') AS start_source, COALESCE(game_id,0) AS game_id FROM active_sessions`); err != nil {
+		return 0, fmt.Errorf("RecoverActiveSessions: %w", err)
+	}
+	for _, r := range rows {
+		start, err1 := time.Parse(time.RFC3339, r.StartTime)
+		end, err2 := time.Parse(time.RFC3339, r.LastHeartbeat)
+		if err1 == nil && err2 == nil {
+			err := db.SaveActivity(entity.ActivityRecord{
+				ProcessName: r.ProcessName,
+				StartTime:   start.Local(),
+				EndTime:     end.Local(),
+				Duration:    end.Sub(start),
+				StartSource: r.StartSource,
+				GameID:      r.GameID,
+			})
+			if err != nil {
+				return 0, fmt.Errorf("RecoverActiveSessions: %w", err)
+			}
+		}
+		if err := db.DeleteActiveSession(r.ID); err != nil {
+			return 0, fmt.Errorf("RecoverActiveSessions: %w", err)
+		}
+	}
+	return len(rows), nil
+}
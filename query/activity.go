@@ -1,13 +1,17 @@
 package query
 
 import (
+	"fmt"
 	"main/entity"
 	"time"
 )
 
 // SaveActivity persists an activity. If the session spans across midnight boundaries,
 // it will be split into multiple day-bounded segments so that each segment contributes
-// to the correct calendar day statistics.
+// to the correct calendar day statistics. Activities of the same game overlapping
+// the new one, or closer than SessionRules.MergeGap on a same day, are merged
+// with it; the session it belongs to is discarded when shorter than
+// SessionRules.MinDuration.
 func (db *Database) SaveActivity(activity entity.ActivityRecord) error {
 	start := activity.StartTime
 	end := activity.EndTime
@@ -17,6 +21,19 @@ func (db *Database) SaveActivity(activity entity.ActivityRecord) error {
 	}
 
 	first := !db.processExist(activity.ProcessName)
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("SaveActivity: %w", err)
+	}
+	defer tx.Rollback()
+	// Stats only see activities whose executable belongs to a game
+	var gameID any
+	game := activity.GameID
+	if game > 0 {
+		gameID = game
+	} else if game, err = ensureGame(tx, activity.ProcessName); err != nil {
+		return err
+	}
 
 	currentStart := start
 	for currentStart.Before(end) {
@@ -33,16 +50,21 @@ func (db *Database) SaveActivity(activity entity.ActivityRecord) error {
 		// Guard: avoid zero/negative durations
 		if segmentEnd.After(currentStart) {
 			dateStr := currentStart.Format("2006-01-02")
-			_, err := db.Exec(`
+			_, err := tx.Exec(`
         INSERT INTO activities 
-        (process_name, start_time, end_time, duration, date, first_launch) 
-        VALUES (?, ?, ?, ?, ?, ?)`,
+        (process_name, window_title, start_time, end_time, duration, date, first_launch, start_source, idle_seconds, unfocused_seconds, game_id) 
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 				activity.ProcessName,
+				activity.WindowTitle,
 				currentStart.Format(time.RFC3339),
 				segmentEnd.Format(time.RFC3339),
 				segmentEnd.Sub(currentStart).Seconds(), // seconds
 				dateStr,
 				first,
+				activity.StartSource,
+				entity.OverlapWith(activity.Idle, currentStart, segmentEnd).Seconds(),
+				entity.OverlapWith(activity.Unfocused, currentStart, segmentEnd).Seconds(),
+				gameID,
 			)
 			if err != nil {
 				return err
@@ -55,7 +77,18 @@ func (db *Database) SaveActivity(activity entity.ActivityRecord) error {
 		currentStart = segmentEnd
 	}
 
-	return nil
+	from := start.AddDate(0, 0, -1).Format("2006-01-02")
+	to := end.AddDate(0, 0, 1).Format("2006-01-02")
+	rules := db.SessionRules()
+	if _, err := mergeOverlaps(tx, game, rules.MergeGap, from, to); err != nil {
+		return fmt.Errorf("SaveActivity: %w", err)
+	}
+	if rules.MinDuration > 0 {
+		if _, _, err := discardShort(tx, game, rules, start, end); err != nil {
+			return fmt.Errorf("SaveActivity: %w", err)
+		}
+	}
+	return tx.Commit()
 }
 
 // DeleteActivity deletes a single activity row identified by process_name and exact start/end times (RFC3339)
@@ -64,6 +97,18 @@ func (db *Database) DeleteActivity(processName, startTime, endTime string) error
 	return err
 }
 
+// GameHasActivity tells whether a session of the game gameID was ever saved.
+// Like gameBase, an activity belongs to the game it was attributed to, or else
+// to the game of its executable.
+func (db *Database) GameHasActivity(gameID int64) (bool, error) {
+	var exists bool
+	err := db.Get(&exists, `SELECT EXISTS(
+	  SELECT 1 FROM activities a
+	  LEFT JOIN game_executables ge ON ge.process_name = a.process_name
+	  WHERE COALESCE(a.game_id, ge.game_id) = ?)`, gameID)
+	return exists, err
+}
+
 func (db *Database) processExist(name string) bool {
 	var exist bool
 	query := `SELECT EXISTS(
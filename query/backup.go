@@ -0,0 +1,112 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+	"modernc.org/sqlite"
+)
+
+// consistent copies of the database file, and restoring one into the open database
+
+// BackupSummary describes the content of a backup file
+type BackupSummary struct {
+	// Version is the schema version of the backup
+	Version    int `json:"version"`
+	Activities int `json:"activities"`
+	Games      int `json:"games"`
+}
+
+// BackupTo writes a consistent copy of the database to path, which must not exist
+func (db *Database) BackupTo(path string) error {
+	// VACUUM INTO writes a consistent copy, including the pages still in the WAL
+	if _, err := db.Exec(`VACUUM INTO ?`, path); err != nil {
+		return fmt.Errorf("BackupTo: %w", err)
+	}
+	return nil
+}
+
+// InspectBackup checks the integrity of a backup file and sums up its content
+func InspectBackup(path string) (BackupSummary, error) {
+	var sum BackupSummary
+	if _, err := os.Stat(path); err != nil {
+		return sum, err
+	}
+	raw, err := sqlx.Open("sqlite", path)
+	if err != nil {
+		return sum, err
+	}
+	defer raw.Close()
+	b := NewDatabase(raw)
+	var check string
+	if err := b.Get(&check, `PRAGMA quick_check`); err != nil {
+		return sum, fmt.Errorf("InspectBackup: %w", err)
+	}
+	if check != "ok" {
+		return sum, fmt.Errorf("InspectBackup: damaged file: %s", check)
+	}
+	hasMigrations, err := b.TableExists("schema_migrations")
+	if err != nil {
+		return sum, fmt.Errorf("InspectBackup: %w", err)
+	}
+	if hasMigrations {
+		sum.Version, err = b.GetDbVersion()
+	} else {
+		sum.Version, err = b.legacyVersion()
+	}
+	if err != nil {
+		return sum, fmt.Errorf("InspectBackup: %w", err)
+	}
+	if sum.Version > SchemaVersion() {
+		return sum, fmt.Errorf("InspectBackup: schema version %d is newer than this application (%d)", sum.Version, SchemaVersion())
+	}
+	if err := b.Get(&sum.Activities, `SELECT COUNT(*) FROM activities`); err != nil {
+		return sum, fmt.Errorf("InspectBackup: %w", err)
+	}
+	if ok, _ := b.TableExists("games"); ok {
+		if err := b.Get(&sum.Games, `SELECT COUNT(*) FROM games`); err != nil {
+			return sum, fmt.Errorf("InspectBackup: %w", err)
+		}
+	}
+	return sum, nil
+}
+
+// RestoreFrom replaces the content of the database with the backup at path,
+// then brings it up to date. The other connections of the pool see the
+// restored content; dataDir receives the backup taken before the migrations.
+func (db *Database) RestoreFrom(path, dataDir string) error {
+	if _, err := InspectBackup(path); err != nil {
+		return err
+	}
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return fmt.Errorf("RestoreFrom: %w", err)
+	}
+	err = conn.Raw(func(dc any) error {
+		r, ok := dc.(interface {
+			NewRestore(srcUri string) (*sqlite.Backup, error)
+		})
+		if !ok {
+			return errors.New("the database driver can't restore backups")
+		}
+		bk, err := r.NewRestore(path)
+		if err != nil {
+			return err
+		}
+		for more := true; more; {
+			if more, err = bk.Step(-1); err != nil {
+				bk.Finish()
+				return err
+			}
+		}
+		return bk.Finish()
+	})
+	conn.Close()
+	if err != nil {
+		return fmt.Errorf("RestoreFrom: %w", err)
+	}
+	return db.migrate(dataDir)
+}
@@ -0,0 +1,160 @@
+package query
+
+import (
+	"fmt"
+	"time"
+)
+
+// thresholds cleaning up the many tiny sessions left by crash-restarts and
+// launchers flashing a process
+
+// SessionRules are the thresholds applied to the saved sessions
+type SessionRules struct {
+	// MinDuration discards the sessions shorter than it, 0 keeps them all
+	MinDuration time.Duration
+	// MergeGap merges the sessions of a game of a same day separated by less
+	// than it into one row, whose duration doesn't count the gap. 0 only
+	// merges the overlapping ones.
+	MergeGap time.Duration
+}
+
+// CleanupReport sums up a cleanup pass
+type CleanupReport struct {
+	// Merged is the number of rows merged into another one, overlapping it or
+	// closer than MergeGap
+	Merged int `json:"merged"`
+	// Discarded is the number of rows deleted for being part of a too short session
+	Discarded int `json:"discarded"`
+	// DiscardedSeconds is the time of the deleted sessions
+	DiscardedSeconds float64 `json:"discarded_seconds"`
+}
+
+// CleanupSessions applies rules to the existing activities: the rows of a game
+// overlapping or closer than rules.MergeGap are merged, then the sessions
+// shorter than rules.MinDuration are deleted. With apply false nothing is
+// changed, the report tells what would be done.
+func (db *Database) CleanupSessions(rules SessionRules, apply bool) (CleanupReport, error) {
+	var rep CleanupReport
+	tx, err := db.Beginx()
+	if err != nil {
+		return rep, fmt.Errorf("CleanupSessions: %w", err)
+	}
+	defer tx.Rollback()
+	var ids []int64
+	if err := tx.Select(&ids, `SELECT id FROM games ORDER BY id`); err != nil {
+		return rep, fmt.Errorf("CleanupSessions: %w", err)
+	}
+	for _, id := range ids {
+		o, err := mergeOverlaps(tx, id, rules.MergeGap, "", "")
+		if err != nil {
+			return CleanupReport{}, fmt.Errorf("CleanupSessions: %w", err)
+		}
+		rep.Merged += o.Removed
+		if rules.MinDuration > 0 {
+			n, secs, err := discardShort(tx, id, rules, time.Time{}, time.Time{})
+			if err != nil {
+				return CleanupReport{}, fmt.Errorf("CleanupSessions: %w", err)
+			}
+			rep.Discarded += n
+			rep.DiscardedSeconds += secs
+		}
+	}
+	if !apply {
+		return rep, nil
+	}
+	return rep, tx.Commit()
+}
+
+// discardShort deletes the sessions of a game shorter than rules.MinDuration. A
+// session is a run of rows each starting less than rules.MergeGap after the
+// previous one ended, contiguous rows being the day-bounded parts of a longer
+// one: its rows are kept or deleted together. With start and end set, only the
+// sessions touching them are read and considered. It returns the rows deleted
+// and their time.
+func discardShort(ex execer, gameID int64, rules SessionRules, start, end time.Time) (int, float64, error) {
+	var rows []overlapRow
+	var err error
+	if start.IsZero() {
+		rows, err = gameRows(ex, gameID, "", "")
+	} else {
+		rows, err = rowsAround(ex, gameID, rules.MergeGap, start, end)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	var n int
+	var secs float64
+	for _, session := range splitSessions(rows, rules.MergeGap) {
+		first, last, played := sessionSpan(session)
+		if played >= rules.MinDuration.Seconds() {
+			continue
+		}
+		if !start.IsZero() && (first.After(end) || last.Before(start)) {
+			continue
+		}
+		for _, r := range session {
+			if _, err := ex.Exec(`DELETE FROM activities WHERE id = ?`, r.ID); err != nil {
+				return 0, 0, err
+			}
+			n++
+			secs += r.Duration
+		}
+	}
+	return n, secs, nil
+}
+
+// rowsAround returns the rows of a game from gap before start to gap after
+// end, widened until the sessions touching start and end are read whole
+func rowsAround(ex execer, gameID int64, gap time.Duration, start, end time.Time) ([]overlapRow, error) {
+	from, to := start.Add(-gap), end.Add(gap)
+	for {
+		// Rows are day-bounded and dated by their local start
+		all, err := gameRows(ex, gameID, from.AddDate(0, 0, -1).Format("2006-01-02"), to.AddDate(0, 0, 1).Format("2006-01-02"))
+		if err != nil {
+			return nil, err
+		}
+		rows := all[:0]
+		for _, r := range all {
+			if !r.end.Before(from) && !r.start.After(to) {
+				rows = append(rows, r)
+			}
+		}
+		lo, hi := from, to
+		for _, session := range splitSessions(rows, gap) {
+			if first, last, _ := sessionSpan(session); !first.After(end) && !last.Before(start) {
+				lo, hi = minTime(lo, first.Add(-gap)), maxTime(hi, last.Add(gap))
+			}
+		}
+		if lo.Equal(from) && hi.Equal(to) {
+			return rows, nil
+		}
+		from, to = lo, hi
+	}
+}
+
+// splitSessions cuts rows sorted by start into sessions, a row starting less
+// than gap after the end of the previous ones joining their session
+func splitSessions(rows []overlapRow, gap time.Duration) [][]overlapRow {
+	var sessions [][]overlapRow
+	for i := 0; i < len(rows); {
+		end := rows[i].end
+		j := i + 1
+		for ; j < len(rows) && (!rows[j].start.After(end) || rows[j].start.Sub(end) < gap); j++ {
+			end = maxTime(end, rows[j].end)
+		}
+		sessions = append(sessions, rows[i:j])
+		i = j
+	}
+	return sessions
+}
+
+// sessionSpan returns when a session started and ended, and its played time
+func sessionSpan(session []overlapRow) (time.Time, time.Time, float64) {
+	first, last := session[0].start, session[0].end
+	var played float64
+	for _, r := range session {
+		last = maxTime(last, r.end)
+		played += r.Duration
+	}
+	return first, last, played
+}
@@ -0,0 +1,129 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"main/entity"
+)
+
+func TestSaveActivityMergesGap(t *testing.T) {
+	db, err := OpenDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetSessionRules(SessionRules{MinDuration: 2 * time.Minute, MergeGap: 10 * time.Minute})
+
+	at := func(h, m int) time.Time { return time.Date(2026, 3, 2, h, m, 0, 0, time.Local) }
+	save := func(from, to time.Time, idle ...entity.Interval) {
+		t.Helper()
+		err := db.SaveActivity(entity.ActivityRecord{ProcessName: "game.exe", StartTime: from, EndTime: to, Idle: idle})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 20:00-20:30 with 10 idle minutes, then 1 minute after a 5 minutes gap:
+	// too short alone, but merged into the same session
+	save(at(20, 0), at(20, 30), entity.Interval{Start: at(20, 10), End: at(20, 20)})
+	save(at(20, 35), at(20, 36))
+	// 21:00-21:01 is alone and too short
+	save(at(21, 0), at(21, 1))
+	// 20:25-20:50 overlaps both the first row and the one of 20:35
+	save(at(20, 25), at(20, 50), entity.Interval{Start: at(20, 40), End: at(20, 50)})
+
+	var rows []overlapRow
+	err = db.Select(&rows, `SELECT id, process_name, start_time, end_time, duration, idle_seconds, date FROM activities ORDER BY start_time`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("got %d rows, want 1: %+v", len(rows), rows)
+	}
+	r := rows[0]
+	if r.StartTime != at(20, 0).Format(time.RFC3339) || r.EndTime != at(20, 50).Format(time.RFC3339) {
+		t.Errorf("got %s..%s, want 20:00..20:50", r.StartTime, r.EndTime)
+	}
+	if r.Duration != 50*60 {
+		t.Errorf("duration = %v, want %v", r.Duration, 50*60)
+	}
+	// 10 idle minutes in each of the first and last rows: the last one adds
+	// 14 minutes past the merged row and fills its 5 minutes gap, so 19 of its
+	// 25 minutes and their share of idle time add up
+	if want := 10*60 + 10*60*19/25.0; r.Idle != want {
+		t.Errorf("idle = %v, want %v", r.Idle, want)
+	}
+}
+
+func TestCleanupSessionsDoesNotCountGap(t *testing.T) {
+	db, err := OpenDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	at := func(h, m int) time.Time { return time.Date(2026, 3, 2, h, m, 0, 0, time.Local) }
+	for _, s := range [][2]time.Time{{at(10, 0), at(10, 1)}, {at(10, 5), at(10, 6)}, {at(12, 0), at(12, 1)}, {at(14, 0), at(15, 0)}} {
+		if err := db.SaveActivity(entity.ActivityRecord{ProcessName: "game.exe", StartTime: s[0], EndTime: s[1]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rules := SessionRules{MinDuration: 90 * time.Second, MergeGap: 10 * time.Minute}
+	rep, err := db.CleanupSessions(rules, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 10:00 and 10:05 are merged into a 2 minutes session, 12:00 is discarded
+	if rep.Merged != 1 || rep.Discarded != 1 || rep.DiscardedSeconds != 60 {
+		t.Errorf("report = %+v, want 1 row merged and 1 of 60s discarded", rep)
+	}
+	var rows []overlapRow
+	if err := db.Select(&rows, `SELECT id, process_name, start_time, end_time, duration, date FROM activities ORDER BY start_time`); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want 2: %+v", len(rows), rows)
+	}
+	// The merged row spans the gap without counting it
+	if r := rows[0]; r.StartTime != at(10, 0).Format(time.RFC3339) || r.EndTime != at(10, 6).Format(time.RFC3339) || r.Duration != 2*60 {
+		t.Errorf("merged row %s..%s of %vs, want 10:00..10:06 of 120s", r.StartTime, r.EndTime, r.Duration)
+	}
+	var total float64
+	if err := db.Get(&total, `SELECT SUM(duration) FROM activities`); err != nil {
+		t.Fatal(err)
+	}
+	if total != 62*60 {
+		t.Errorf("total = %v, want %v", total, 62*60)
+	}
+}
+
+func TestRowsAroundReadsWholeSession(t *testing.T) {
+	db, err := OpenDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	at := func(h, m int) time.Time { return time.Date(2026, 3, 2, h, m, 0, 0, time.Local) }
+	// Saved without rules, so that the rows stay apart
+	for _, s := range [][2]time.Time{{at(8, 0), at(9, 0)}, {at(10, 0), at(10, 20)}, {at(10, 25), at(10, 45)}, {at(10, 50), at(10, 55)}, {at(12, 0), at(12, 1)}} {
+		if err := db.SaveActivity(entity.ActivityRecord{ProcessName: "game.exe", StartTime: s[0], EndTime: s[1]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	game, err := db.EnsureGame("game.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := rowsAround(db, game, 10*time.Minute, at(10, 50), at(10, 55))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range rows {
+		got = append(got, r.start.Format("15:04"))
+	}
+	if len(got) != 3 || got[0] != "10:00" || got[2] != "10:50" {
+		t.Errorf("rows starting at %v, want the session from 10:00 to 10:55 only", got)
+	}
+}
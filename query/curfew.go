@@ -0,0 +1,284 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// operations for curfews: the windows of the week when games may run, the
+// days they are lifted and the log of their violations
+
+// Violation kinds
+const (
+	// ViolationStart is a game launched outside its allowed windows
+	ViolationStart = "start"
+	// ViolationRunning is an allowed window ending while the game runs
+	ViolationRunning = "running"
+)
+
+// Curfew is an allowed window of a game, or of all games when GameID is 0. On
+// the days a curfew covers, games only run within the windows of their curfews.
+type Curfew struct {
+	ID     int64  `db:"id" json:"id"`
+	GameID int64  `db:"game_id" json:"game_id,omitempty"`
+	Game   string `db:"game" json:"game,omitempty"`
+	// Weekdays are the days the window starts (0 = Sunday), empty for every day
+	Weekdays Weekdays `db:"weekdays" json:"weekdays"`
+	// Start and End bound the window, HH:MM local time; the window ends the
+	// next day when End is not after Start
+	Start string `db:"window_start" json:"start"`
+	End   string `db:"window_end" json:"end"`
+	// Action is ActionNotify, ActionWarn or ActionTerminate
+	Action       string `db:"action" json:"action"`
+	GraceSeconds int    `db:"grace_seconds" json:"grace_seconds"`
+	Enabled      bool   `db:"enabled" json:"enabled"`
+}
+
+// CurfewException lifts the curfews of a game, or of all games when GameID is
+// 0, between two dates included
+type CurfewException struct {
+	ID        int64  `db:"id" json:"id"`
+	GameID    int64  `db:"game_id" json:"game_id,omitempty"`
+	Game      string `db:"game" json:"game,omitempty"`
+	StartDate string `db:"start_date" json:"start_date"`
+	EndDate   string `db:"end_date" json:"end_date"`
+	Note      string `db:"note" json:"note"`
+}
+
+// CurfewViolation is a game found running outside its allowed windows
+type CurfewViolation struct {
+	ID          int64  `db:"id" json:"id"`
+	GameID      int64  `db:"game_id" json:"game_id,omitempty"`
+	Game        string `db:"game" json:"game"`
+	ProcessName string `db:"process_name" json:"process_name"`
+	CurfewID    int64  `db:"curfew_id" json:"curfew_id"`
+	At          string `db:"at" json:"at"`
+	Kind        string `db:"kind" json:"kind"`
+	Action      string `db:"action" json:"action"`
+	Terminated  bool   `db:"terminated" json:"terminated"`
+}
+
+// ErrCurfewNotFound is returned for an unknown curfew or exception id
+var ErrCurfewNotFound = errors.New("curfew not found")
+
+// parseClock reads a HH:MM time of day
+func parseClock(s string) (h, m int, err error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// Validate normalizes the curfew and checks its fields
+func (c *Curfew) Validate() error {
+	c.Action = strings.ToLower(strings.TrimSpace(c.Action))
+	if c.Action == "" {
+		c.Action = ActionNotify
+	}
+	switch c.Action {
+	case ActionNotify, ActionWarn, ActionTerminate:
+	default:
+		return fmt.Errorf("unknown action %q", c.Action)
+	}
+	for _, f := range []*string{&c.Start, &c.End} {
+		h, m, err := parseClock(*f)
+		if err != nil {
+			return err
+		}
+		*f = fmt.Sprintf("%02d:%02d", h, m)
+	}
+	if c.GraceSeconds < 0 {
+		return errors.New("grace_seconds can't be negative")
+	}
+	for _, d := range c.Weekdays {
+		if d < time.Sunday || d > time.Saturday {
+			return fmt.Errorf("invalid weekday %d", d)
+		}
+	}
+	slices.Sort(c.Weekdays)
+	c.Weekdays = slices.Compact(c.Weekdays)
+	if c.Weekdays == nil {
+		c.Weekdays = Weekdays{}
+	}
+	return nil
+}
+
+// Covers tells whether the curfew restricts the day of t
+func (c Curfew) Covers(t time.Time) bool {
+	return len(c.Weekdays) == 0 || slices.Contains(c.Weekdays, t.Weekday())
+}
+
+// Contains tells whether t is within the window, including a window started
+// the day before that ends after midnight
+func (c Curfew) Contains(t time.Time) bool {
+	sh, sm, err := parseClock(c.Start)
+	if err != nil {
+		return false
+	}
+	eh, em, err := parseClock(c.End)
+	if err != nil {
+		return false
+	}
+	for _, back := range []int{0, -1} {
+		d := t.AddDate(0, 0, back)
+		if !c.Covers(d) {
+			continue
+		}
+		start := time.Date(d.Year(), d.Month(), d.Day(), sh, sm, 0, 0, t.Location())
+		end := time.Date(d.Year(), d.Month(), d.Day(), eh, em, 0, 0, t.Location())
+		if !end.After(start) {
+			end = end.AddDate(0, 0, 1)
+		}
+		if !t.Before(start) && t.Before(end) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate normalizes the exception and checks its dates
+func (x *CurfewException) Validate() error {
+	x.StartDate, x.EndDate = strings.TrimSpace(x.StartDate), strings.TrimSpace(x.EndDate)
+	if x.EndDate == "" {
+		x.EndDate = x.StartDate
+	}
+	start, err := time.Parse("2006-01-02", x.StartDate)
+	if err != nil {
+		return fmt.Errorf("invalid start_date %q, expected YYYY-MM-DD", x.StartDate)
+	}
+	end, err := time.Parse("2006-01-02", x.EndDate)
+	if err != nil {
+		return fmt.Errorf("invalid end_date %q, expected YYYY-MM-DD", x.EndDate)
+	}
+	if end.Before(start) {
+		return errors.New("end_date is before start_date")
+	}
+	x.Note = strings.TrimSpace(x.Note)
+	return nil
+}
+
+// Includes tells whether the day of t is within the exception
+func (x CurfewException) Includes(t time.Time) bool {
+	day := t.Format("2006-01-02")
+	return day >= x.StartDate && day <= x.EndDate
+}
+
+const curfewColumns = `c.id, COALESCE(c.game_id, 0) AS game_id, COALESCE(g.name, '') AS game,
+	c.weekdays, c.window_start, c.window_end, c.action, c.grace_seconds, c.enabled
+	FROM curfews c LEFT JOIN games g ON g.id = c.game_id`
+
+// GetCurfews returns every curfew, the global ones first
+func (db *Database) GetCurfews() ([]Curfew, error) {
+	curfews := []Curfew{}
+	err := db.Select(&curfews, `SELECT `+curfewColumns+` ORDER BY c.game_id IS NOT NULL, g.name COLLATE NOCASE, c.window_start, c.id`)
+	return curfews, err
+}
+
+// SaveCurfew validates c and inserts it, or updates it when c.ID is set
+func (db *Database) SaveCurfew(c Curfew) (Curfew, error) {
+	if err := c.Validate(); err != nil {
+		return Curfew{}, err
+	}
+	var gameID any
+	if c.GameID > 0 {
+		gameID = c.GameID
+	}
+	if c.ID == 0 {
+		res, err := db.Exec(`INSERT INTO curfews (game_id, weekdays, window_start, window_end, action, grace_seconds, enabled) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			gameID, c.Weekdays, c.Start, c.End, c.Action, c.GraceSeconds, c.Enabled)
+		if err != nil {
+			return Curfew{}, fmt.Errorf("SaveCurfew: %w", err)
+		}
+		if c.ID, err = res.LastInsertId(); err != nil {
+			return Curfew{}, fmt.Errorf("SaveCurfew: %w", err)
+		}
+	} else {
+		res, err := db.Exec(`UPDATE curfews SET game_id = ?, weekdays = ?, window_start = ?, window_end = ?, action = ?, grace_seconds = ?, enabled = ? WHERE id = ?`,
+			gameID, c.Weekdays, c.Start, c.End, c.Action, c.GraceSeconds, c.Enabled, c.ID)
+		if err != nil {
+			return Curfew{}, fmt.Errorf("SaveCurfew: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return Curfew{}, ErrCurfewNotFound
+		}
+	}
+	var saved []Curfew
+	if err := db.Select(&saved, `SELECT `+curfewColumns+` WHERE c.id = ?`, c.ID); err != nil {
+		return Curfew{}, fmt.Errorf("SaveCurfew: %w", err)
+	}
+	if len(saved) == 0 {
+		return Curfew{}, ErrCurfewNotFound
+	}
+	return saved[0], nil
+}
+
+// DeleteCurfew removes the curfew id
+func (db *Database) DeleteCurfew(id int64) error {
+	_, err := db.Exec(`DELETE FROM curfews WHERE id = ?`, id)
+	return err
+}
+
+// GetCurfewExceptions returns every exception, the latest first
+func (db *Database) GetCurfewExceptions() ([]CurfewException, error) {
+	out := []CurfewException{}
+	err := db.Select(&out, `SELECT x.id, COALESCE(x.game_id, 0) AS game_id, COALESCE(g.name, '') AS game, x.start_date, x.end_date, x.note
+		FROM curfew_exceptions x LEFT JOIN games g ON g.id = x.game_id ORDER BY x.start_date DESC, x.id`)
+	return out, err
+}
+
+// AddCurfewException validates x and inserts it
+func (db *Database) AddCurfewException(x CurfewException) (CurfewException, error) {
+	if err := x.Validate(); err != nil {
+		return CurfewException{}, err
+	}
+	var gameID any
+	if x.GameID > 0 {
+		gameID = x.GameID
+	}
+	res, err := db.Exec(`INSERT INTO curfew_exceptions (game_id, start_date, end_date, note) VALUES (?, ?, ?, ?)`, gameID, x.StartDate, x.EndDate, x.Note)
+	if err != nil {
+		return CurfewException{}, fmt.Errorf("AddCurfewException: %w", err)
+	}
+	if x.ID, err = res.LastInsertId(); err != nil {
+		return CurfewException{}, fmt.Errorf("AddCurfewException: %w", err)
+	}
+	return x, nil
+}
+
+// DeleteCurfewException removes the exception id
+func (db *Database) DeleteCurfewException(id int64) error {
+	_, err := db.Exec(`DELETE FROM curfew_exceptions WHERE id = ?`, id)
+	return err
+}
+
+// InsertCurfewViolation logs a violation and returns its id
+func (db *Database) InsertCurfewViolation(v CurfewViolation) (int64, error) {
+	var gameID any
+	if v.GameID > 0 {
+		gameID = v.GameID
+	}
+	res, err := db.Exec(`INSERT INTO curfew_violations (game_id, game, process_name, curfew_id, at, kind, action, terminated) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		gameID, v.Game, v.ProcessName, v.CurfewID, v.At, v.Kind, v.Action, v.Terminated)
+	if err != nil {
+		return 0, fmt.Errorf("InsertCurfewViolation: %w", err)
+	}
+	return res.LastInsertId()
+}
+
+// SetViolationTerminated records that the game of the violation id was stopped
+func (db *Database) SetViolationTerminated(id int64) error {
+	_, err := db.Exec(`UPDATE curfew_violations SET terminated = TRUE WHERE id = ?`, id)
+	return err
+}
+
+// GetCurfewViolations returns the last violations, the most recent first
+func (db *Database) GetCurfewViolations(limit int) ([]CurfewViolation, error) {
+	out := []CurfewViolation{}
+	err := db.Select(&out, `SELECT id, COALESCE(game_id, 0) AS game_id, game, process_name, COALESCE(curfew_id, 0) AS curfew_id, at, kind, action, terminated
+		FROM curfew_violations ORDER BY at DESC, id DESC LIMIT ?`, limit)
+	return out, err
+}
@@ -0,0 +1,410 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// export and import of the whole database as a JSON document, shared by the
+// web UI and the command line
+
+// Import modes
+const (
+	// ImportMerge adds the entries missing from the database
+	ImportMerge = "merge"
+	// ImportReplace clears the data tables first
+	ImportReplace = "replace"
+)
+
+type ActivityRow struct {
+	ProcessName string  `db:"process_name" json:"process_name"`
+	StartTime   string  `db:"start_time" json:"start_time"`
+	EndTime     string  `db:"end_time" json:"end_time"`
+	Duration    float64 `db:"duration" json:"duration"`
+	Date        string  `db:"date" json:"date"`
+	FirstLaunch bool    `db:"first_launch" json:"first_launch"`
+	StartSource string  `db:"start_source" json:"start_source,omitempty"`
+	IdleSeconds float64 `db:"idle_seconds" json:"idle_seconds,omitempty"`
+	WindowTitle string  `db:"window_title" json:"window_title,omitempty"`
+	Unfocused   float64 `db:"unfocused_seconds" json:"unfocused_seconds,omitempty"`
+	// Game is set when the activity was attributed to a game by a launcher rule
+	Game string `db:"game" json:"game,omitempty"`
+}
+
+type RenameRow struct {
+	OriginalName string `db:"original_name" json:"original_name"`
+	DisplayName  string `db:"display_name" json:"display_name"`
+}
+
+type FinishedRow struct {
+	Name       string `db:"name" json:"name"`
+	FinishedAt string `db:"finished_at" json:"finished_at"`
+}
+
+type FirstLaunchRow struct {
+	Name      string `db:"name" json:"name"`
+	FirstDate string `db:"first_date" json:"first_date"`
+}
+
+// RuleRow is a typed list rule as exported, its game given by name
+type RuleRow struct {
+	ID              int64  `db:"id" json:"id"`
+	List            string `db:"list" json:"list"`
+	Kind            string `db:"kind" json:"kind"`
+	Pattern         string `db:"pattern" json:"pattern"`
+	CaseInsensitive bool   `db:"case_insensitive" json:"case_insensitive"`
+	Priority        int    `db:"priority" json:"priority"`
+	TrackChildren   bool   `db:"track_children" json:"track_children"`
+	GameID          int64  `db:"game_id" json:"game_id,omitempty"`
+	Game            string `db:"game" json:"game,omitempty"`
+}
+
+type ExportMeta struct {
+	SchemaVersion int    `json:"schema_version"`
+	ExportedAt    string `json:"exported_at"`
+	Timezone      string `json:"timezone"`
+}
+
+// Export is the document written by Export and read by Import
+type Export struct {
+	Mode                 string            `json:"mode,omitempty"`
+	Meta                 ExportMeta        `json:"meta"`
+	Activities           []ActivityRow     `json:"activities"`
+	Whitelist            []string          `json:"whitelist"`
+	Blacklist            []string          `json:"blacklist"`
+	RenameMap            []RenameRow       `json:"rename_map"`
+	FinishedGames        []FinishedRow     `json:"finished_games"`
+	FirstLaunchOverrides []FirstLaunchRow  `json:"first_launch_override"`
+	Rules                []RuleRow         `json:"list_rules,omitempty"`
+	StoreIDs             []StoreID         `json:"store_ids,omitempty"`
+	Limits               []Limit           `json:"play_limits,omitempty"`
+	Curfews              []Curfew          `json:"curfews,omitempty"`
+	CurfewExceptions     []CurfewException `json:"curfew_exceptions,omitempty"`
+}
+
+// Export reads every table into an export document
+func (db *Database) Export() (Export, error) {
+	var p Export
+	if err := db.Select(&p.Activities, `SELECT a.process_name, a.start_time, a.end_time, a.duration, a.date, a.first_launch, COALESCE(a.start_source,'') AS start_source, COALESCE(a.idle_seconds,0) AS idle_seconds, COALESCE(a.window_title,'') AS window_title, COALESCE(a.unfocused_seconds,0) AS unfocused_seconds, COALESCE(g.name,'') AS game FROM activities a LEFT JOIN games g ON g.id = a.game_id ORDER BY a.start_time`); err != nil {
+		return p, fmt.Errorf("Export: %w", err)
+	}
+	var err error
+	if p.Whitelist, err = db.GetAllWhitelisted(); err != nil {
+		return p, fmt.Errorf("Export: %w", err)
+	}
+	if p.Blacklist, err = db.GetAllBlacklisted(); err != nil {
+		return p, fmt.Errorf("Export: %w", err)
+	}
+	// Games are exported in the historical format: executable -> name when they differ
+	if err := db.Select(&p.RenameMap, `SELECT ge.process_name AS original_name, g.name AS display_name FROM game_executables ge JOIN games g ON g.id = ge.game_id WHERE ge.process_name <> g.name ORDER BY ge.process_name`); err != nil {
+		return p, fmt.Errorf("Export: %w", err)
+	}
+	if err := db.Select(&p.FinishedGames, `SELECT name, finished_at FROM games WHERE finished_at IS NOT NULL ORDER BY name`); err != nil {
+		return p, fmt.Errorf("Export: %w", err)
+	}
+	if err := db.Select(&p.FirstLaunchOverrides, `SELECT name, first_date FROM games WHERE first_date IS NOT NULL ORDER BY name`); err != nil {
+		return p, fmt.Errorf("Export: %w", err)
+	}
+	if err := db.Select(&p.Rules, `SELECT r.id, r.list, r.kind, r.pattern, r.case_insensitive, r.priority, COALESCE(r.track_children, FALSE) AS track_children, COALESCE(r.game_id, 0) AS game_id, COALESCE(g.name,'') AS game FROM list_rules r LEFT JOIN games g ON g.id = r.game_id ORDER BY r.id`); err != nil {
+		return p, fmt.Errorf("Export: %w", err)
+	}
+	if p.StoreIDs, err = db.GetAllStoreIDs(); err != nil {
+		return p, fmt.Errorf("Export: %w", err)
+	}
+	if p.Limits, err = db.GetLimits(); err != nil {
+		return p, fmt.Errorf("Export: %w", err)
+	}
+	if p.Curfews, err = db.GetCurfews(); err != nil {
+		return p, fmt.Errorf("Export: %w", err)
+	}
+	if p.CurfewExceptions, err = db.GetCurfewExceptions(); err != nil {
+		return p, fmt.Errorf("Export: %w", err)
+	}
+	ver, _ := db.GetDbVersion()
+	now := time.Now()
+	p.Meta = ExportMeta{SchemaVersion: ver, ExportedAt: now.Format(time.RFC3339), Timezone: now.Format("-0700")}
+	return p, nil
+}
+
+// ImportMode normalizes an import mode, merge unless replace is asked
+func ImportMode(mode string) string {
+	if strings.ToLower(strings.TrimSpace(mode)) == ImportReplace {
+		return ImportReplace
+	}
+	return ImportMerge
+}
+
+// Import loads an export document in a single transaction. The mode is the
+// one of the document unless mode is set; it returns the mode used. Lists
+// held in memory (manager.ListManager) must be refreshed afterwards.
+func (db *Database) Import(p Export, mode string) (string, error) {
+	if strings.TrimSpace(mode) == "" {
+		mode = p.Mode
+	}
+	mode = ImportMode(mode)
+	tx, err := db.Beginx()
+	if err != nil {
+		return mode, fmt.Errorf("Import: %w", err)
+	}
+	defer tx.Rollback()
+	if err := importTx(tx, p, mode); err != nil {
+		return mode, fmt.Errorf("Import: %w", err)
+	}
+	return mode, tx.Commit()
+}
+
+func importTx(tx *sqlx.Tx, p Export, mode string) error {
+	if mode == ImportReplace {
+		// Clear all data tables (keep database_version)
+		stmts := []string{
+			"DELETE FROM activities",
+			"DELETE FROM whitelist",
+			"DELETE FROM blacklist",
+			"DELETE FROM list_rules",
+			"DELETE FROM play_limits",
+			"DELETE FROM curfews",
+			"DELETE FROM curfew_exceptions",
+			// The violations keep the name of their game
+			"UPDATE curfew_violations SET game_id = NULL",
+			"DELETE FROM store_ids",
+			"DELETE FROM game_executables",
+			"UPDATE active_sessions SET game_id = NULL",
+			"DELETE FROM games",
+		}
+		for _, q := range stmts {
+			if _, err := tx.Exec(q); err != nil {
+				return err
+			}
+		}
+	}
+	// Rename map first, so executables join their game before their activities
+	for _, r := range p.RenameMap {
+		orig := strings.TrimSpace(r.OriginalName)
+		disp := strings.TrimSpace(r.DisplayName)
+		if orig == "" || disp == "" {
+			continue
+		}
+		id, err := resolveGame(tx, disp)
+		if err != nil {
+			return err
+		}
+		if err := assignExecutable(tx, orig, id); err != nil {
+			return err
+		}
+	}
+	// Activities (validate and normalize)
+	for _, a := range p.Activities {
+		pname := strings.TrimSpace(a.ProcessName)
+		if pname == "" {
+			continue
+		}
+		st, err1 := time.Parse(time.RFC3339, strings.TrimSpace(a.StartTime))
+		et, err2 := time.Parse(time.RFC3339, strings.TrimSpace(a.EndTime))
+		if err1 != nil || err2 != nil || et.Before(st) {
+			continue
+		}
+		// Normalize to RFC3339 (UTC), the date derived from the UTC start
+		stUTC := st.UTC().Format(time.RFC3339)
+		etUTC := et.UTC().Format(time.RFC3339)
+		dateStr := st.UTC().Format("2006-01-02")
+		if mode == ImportMerge {
+			var exists bool
+			if err := tx.Get(&exists, `SELECT EXISTS(SELECT 1 FROM activities WHERE process_name=? AND start_time=? AND end_time=?)`, pname, stUTC, etUTC); err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+		}
+		var gameID any
+		if game := strings.TrimSpace(a.Game); game != "" {
+			id, err := resolveGame(tx, game)
+			if err != nil {
+				return err
+			}
+			gameID = id
+		} else if _, err := ensureGame(tx, pname); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO activities (process_name, start_time, end_time, duration, date, first_launch, start_source, idle_seconds, window_title, unfocused_seconds, game_id) VALUES (?,?,?,?,?,?,?,?,?,?,?)`,
+			pname, stUTC, etUTC, a.Duration, dateStr, a.FirstLaunch, strings.TrimSpace(a.StartSource), a.IdleSeconds, a.WindowTitle, a.Unfocused, gameID); err != nil {
+			return err
+		}
+	}
+	for _, name := range p.Whitelist {
+		if n := strings.TrimSpace(name); n != "" {
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO whitelist (name) VALUES (?)`, n); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range p.Blacklist {
+		if n := strings.TrimSpace(name); n != "" {
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO blacklist (name) VALUES (?)`, n); err != nil {
+				return err
+			}
+		}
+	}
+	for _, f := range p.FinishedGames {
+		name := strings.TrimSpace(f.Name)
+		if name == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", strings.TrimSpace(f.FinishedAt)); err != nil {
+			continue
+		}
+		id, err := resolveGame(tx, name)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE games SET finished_at = ? WHERE id = ?`, f.FinishedAt, id); err != nil {
+			return err
+		}
+	}
+	for _, fl := range p.FirstLaunchOverrides {
+		name := strings.TrimSpace(fl.Name)
+		if name == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", strings.TrimSpace(fl.FirstDate)); err != nil {
+			continue
+		}
+		id, err := resolveGame(tx, name)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE games SET first_date = ? WHERE id = ?`, fl.FirstDate, id); err != nil {
+			return err
+		}
+	}
+	// Typed rules (ids are reassigned, identical rules are not duplicated)
+	for _, rl := range p.Rules {
+		var exists bool
+		if err := tx.Get(&exists, `SELECT EXISTS(SELECT 1 FROM list_rules WHERE list=? AND kind=? AND pattern=? AND case_insensitive=? AND priority=?)`, rl.List, rl.Kind, rl.Pattern, rl.CaseInsensitive, rl.Priority); err != nil {
+			return err
+		}
+		if exists || strings.TrimSpace(rl.Pattern) == "" {
+			continue
+		}
+		var gameID any
+		if game := strings.TrimSpace(rl.Game); game != "" {
+			id, err := resolveGame(tx, game)
+			if err != nil {
+				return err
+			}
+			gameID = id
+		}
+		if _, err := tx.Exec(`INSERT INTO list_rules (list, kind, pattern, case_insensitive, priority, track_children, game_id) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			rl.List, rl.Kind, rl.Pattern, rl.CaseInsensitive, rl.Priority, rl.TrackChildren && rl.List == "whitelist", gameID); err != nil {
+			return err
+		}
+	}
+	for _, sid := range p.StoreIDs {
+		name, store, id := strings.TrimSpace(sid.Name), strings.TrimSpace(sid.Store), strings.TrimSpace(sid.StoreID)
+		if name == "" || store == "" || id == "" {
+			continue
+		}
+		gameID, err := resolveGame(tx, name)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO store_ids (game_id, store, store_id) VALUES (?, ?, ?) ON CONFLICT(game_id, store) DO UPDATE SET store_id=excluded.store_id`, gameID, store, id); err != nil {
+			return err
+		}
+	}
+	// Limits (ids are reassigned, identical limits are not duplicated)
+	for _, l := range p.Limits {
+		l.ID, l.GameID = 0, 0
+		if l.Validate() != nil {
+			continue
+		}
+		if game := strings.TrimSpace(l.Game); game != "" {
+			id, err := resolveGame(tx, game)
+			if err != nil {
+				return err
+			}
+			l.GameID = id
+		}
+		var exists bool
+		if err := tx.Get(&exists, `SELECT EXISTS(SELECT 1 FROM play_limits WHERE COALESCE(game_id, 0)=? AND period=? AND weekdays=? AND minutes=? AND action=?)`, l.GameID, l.Period, l.Weekdays, l.Minutes, l.Action); err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		var gameID any
+		if l.GameID > 0 {
+			gameID = l.GameID
+		}
+		if _, err := tx.Exec(`INSERT INTO play_limits (game_id, period, weekdays, minutes, action, grace_seconds, enabled) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			gameID, l.Period, l.Weekdays, l.Minutes, l.Action, l.GraceSeconds, l.Enabled); err != nil {
+			return err
+		}
+	}
+	// Curfews and their exceptions (ids are reassigned, identical ones are not duplicated)
+	for _, c := range p.Curfews {
+		c.ID, c.GameID = 0, 0
+		if c.Validate() != nil {
+			continue
+		}
+		if game := strings.TrimSpace(c.Game); game != "" {
+			id, err := resolveGame(tx, game)
+			if err != nil {
+				return err
+			}
+			c.GameID = id
+		}
+		var exists bool
+		if err := tx.Get(&exists, `SELECT EXISTS(SELECT 1 FROM curfews WHERE COALESCE(game_id, 0)=? AND weekdays=? AND window_start=? AND window_end=?)`, c.GameID, c.Weekdays, c.Start, c.End); err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		var gameID any
+		if c.GameID > 0 {
+			gameID = c.GameID
+		}
+		if _, err := tx.Exec(`INSERT INTO curfews (game_id, weekdays, window_start, window_end, action, grace_seconds, enabled) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			gameID, c.Weekdays, c.Start, c.End, c.Action, c.GraceSeconds, c.Enabled); err != nil {
+			return err
+		}
+	}
+	for _, x := range p.CurfewExceptions {
+		x.ID, x.GameID = 0, 0
+		if x.Validate() != nil {
+			continue
+		}
+		if game := strings.TrimSpace(x.Game); game != "" {
+			id, err := resolveGame(tx, game)
+			if err != nil {
+				return err
+			}
+			x.GameID = id
+		}
+		var exists bool
+		if err := tx.Get(&exists, `SELECT EXISTS(SELECT 1 FROM curfew_exceptions WHERE COALESCE(game_id, 0)=? AND start_date=? AND end_date=?)`, x.GameID, x.StartDate, x.EndDate); err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		var gameID any
+		if x.GameID > 0 {
+			gameID = x.GameID
+		}
+		if _, err := tx.Exec(`INSERT INTO curfew_exceptions (game_id, start_date, end_date, note) VALUES (?, ?, ?, ?)`, gameID, x.StartDate, x.EndDate, x.Note); err != nil {
+			return err
+		}
+	}
+	// Games named in the blacklist stay hidden from the stats
+	if err := markBlacklistedGames(tx); err != nil {
+		return err
+	}
+	// Merged data may hold overlapping sessions of the same game
+	_, err := repairOverlaps(tx)
+	return err
+}
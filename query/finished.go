@@ -2,25 +2,24 @@ package query
 
 // operations for finished games
 
-func (db *Database) InsertFinished(name string) error {
+func (db *Database) InsertFinished(gameID int64) error {
 	// record finish date so we can know if it happened during a selected period
-	_, err := db.Exec("INSERT INTO finished_games (name, finished_at) VALUES (?, date('now'))", name)
+	_, err := db.Exec("UPDATE games SET finished_at = date('now') WHERE id = ?", gameID)
 	return err
 }
 
-func (db *Database) UpsertFinishedAt(name, date string) error {
-	_, err := db.Exec(`INSERT INTO finished_games (name, finished_at) VALUES (?, ?) 
-	ON CONFLICT(name) DO UPDATE SET finished_at=excluded.finished_at`, name, date)
+func (db *Database) UpsertFinishedAt(gameID int64, date string) error {
+	_, err := db.Exec(`UPDATE games SET finished_at = ? WHERE id = ?`, date, gameID)
 	return err
 }
 
-func (db *Database) DeleteFinished(name string) error {
-	_, err := db.Exec("DELETE FROM finished_games WHERE name = ?", name)
+func (db *Database) DeleteFinished(gameID int64) error {
+	_, err := db.Exec("UPDATE games SET finished_at = NULL WHERE id = ?", gameID)
 	return err
 }
 
-func (db *Database) IsFinished(name string) (bool, error) {
+func (db *Database) IsFinished(gameID int64) (bool, error) {
 	var exists bool
-	err := db.Get(&exists, "SELECT EXISTS(SELECT 1 FROM finished_games WHERE name = ?)", name)
+	err := db.Get(&exists, "SELECT EXISTS(SELECT 1 FROM games WHERE id = ? AND finished_at IS NOT NULL)", gameID)
 	return exists, err
 }
@@ -0,0 +1,308 @@
+package query
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// operations for games: a game has a stable id and groups one or more
+// executables (process names); stats, finished state, first launch overrides
+// and store ids reference the game id
+
+// Game is a game with its executables
+type Game struct {
+	ID          int64   `db:"id" json:"id"`
+	Name        string  `db:"name" json:"name"`
+	FinishedAt  *string `db:"finished_at" json:"finished_at"`
+	FirstDate   *string `db:"first_date" json:"first_date"`
+	Blacklisted bool    `db:"blacklisted" json:"blacklisted"`
+	Sessions    int     `db:"sessions" json:"sessions"`
+
+	Executables []string          `db:"-" json:"executables"`
+	StoreIDs    map[string]string `db:"-" json:"store_ids,omitempty"`
+}
+
+// ErrGameNotFound is returned when no game has the requested id or name
+var ErrGameNotFound = errors.New("game not found")
+
+// execer is implemented by *Database and *sqlx.Tx
+type execer interface {
+	sqlx.Ext
+	Get(dest any, query string, args ...any) error
+}
+
+// GetGames returns every game with its executables and store ids
+func (db *Database) GetGames() ([]Game, error) {
+	games := []Game{}
+	q := `SELECT g.id, g.name, g.finished_at, g.first_date, g.blacklisted,
+	         (SELECT COUNT(*) FROM activities a LEFT JOIN game_executables ge ON ge.process_name = a.process_name
+	          WHERE COALESCE(a.game_id, ge.game_id) = g.id) AS sessions
+	      FROM games g ORDER BY g.name COLLATE NOCASE`
+	if err := db.Select(&games, q); err != nil {
+		return nil, fmt.Errorf("GetGames: %w", err)
+	}
+	type exeRow struct {
+		GameID      int64  `db:"game_id"`
+		ProcessName string `db:"process_name"`
+	}
+	exes := []exeRow{}
+	if err := db.Select(&exes, `SELECT game_id, process_name FROM game_executables ORDER BY process_name`); err != nil {
+		return nil, fmt.Errorf("GetGames: %w", err)
+	}
+	byGame := map[int64][]string{}
+	for _, e := range exes {
+		byGame[e.GameID] = append(byGame[e.GameID], e.ProcessName)
+	}
+	ids, err := db.GetStoreIDsByGame()
+	if err != nil {
+		return nil, err
+	}
+	for i := range games {
+		games[i].Executables = byGame[games[i].ID]
+		if games[i].Executables == nil {
+			games[i].Executables = []string{}
+		}
+		games[i].StoreIDs = ids[games[i].ID]
+	}
+	return games, nil
+}
+
+// GetGame returns a game by id, without its executables
+func (db *Database) GetGame(id int64) (Game, error) {
+	var g Game
+	err := db.Get(&g, `SELECT id, name, finished_at, first_date, blacklisted, 0 AS sessions FROM games WHERE id = ?`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return g, ErrGameNotFound
+	}
+	return g, err
+}
+
+// FindGame looks a game up by its name, then by one of its executables
+func (db *Database) FindGame(name string) (Game, error) {
+	id, err := findGame(db, name)
+	if err != nil {
+		return Game{}, err
+	}
+	return db.GetGame(id)
+}
+
+// GetGameExecutables returns the process names mapped to a game
+func (db *Database) GetGameExecutables(id int64) ([]string, error) {
+	names := []string{}
+	err := db.Select(&names, `SELECT process_name FROM game_executables WHERE game_id = ? ORDER BY process_name`, id)
+	return names, err
+}
+
+// ResolveGame returns the game named name, or owning the executable name,
+// creating an empty game with that name when there is none
+func (db *Database) ResolveGame(name string) (int64, error) {
+	return resolveGame(db, name)
+}
+
+// EnsureGame returns the game of an executable, mapping it to the game of the
+// same name (created if needed) the first time it is seen
+func (db *Database) EnsureGame(processName string) (int64, error) {
+	return ensureGame(db, processName)
+}
+
+// AssignExecutable maps an executable to a game; the game it leaves is
+// removed when nothing references it anymore
+func (db *Database) AssignExecutable(processName string, gameID int64) error {
+	return assignExecutable(db, processName, gameID)
+}
+
+// RenameGame changes the name of a game. If another game already has that
+// name, both are merged into the existing one, whose id is returned.
+func (db *Database) RenameGame(id int64, name string) (int64, error) {
+	var other int64
+	err := db.Get(&other, `SELECT id FROM games WHERE name = ? AND id <> ?`, name, id)
+	if err == nil {
+		return other, db.MergeGames(id, other)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("RenameGame: %w", err)
+	}
+	res, err := db.Exec(`UPDATE games SET name = ? WHERE id = ?`, name, id)
+	if err != nil {
+		return 0, fmt.Errorf("RenameGame: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, ErrGameNotFound
+	}
+	return id, nil
+}
+
+// MergeGames moves everything of game from into game into, then deletes from.
+// into keeps its name, blacklist flag and dates; missing dates come from from.
+func (db *Database) MergeGames(from, into int64) error {
+	if from == into {
+		return nil
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("MergeGames: %w", err)
+	}
+	stmts := []string{
+		`UPDATE game_executables SET game_id = ? WHERE game_id = ?`,
+		`UPDATE activities SET game_id = ? WHERE game_id = ?`,
+		`UPDATE active_sessions SET game_id = ? WHERE game_id = ?`,
+		`UPDATE list_rules SET game_id = ? WHERE game_id = ?`,
+		`UPDATE play_limits SET game_id = ? WHERE game_id = ?`,
+		`UPDATE curfews SET game_id = ? WHERE game_id = ?`,
+		`UPDATE curfew_exceptions SET game_id = ? WHERE game_id = ?`,
+		`UPDATE curfew_violations SET game_id = ? WHERE game_id = ?`,
+		`INSERT OR IGNORE INTO store_ids (game_id, store, store_id) SELECT ?, store, store_id FROM store_ids WHERE game_id = ?`,
+	}
+	for _, q := range stmts {
+		if _, err := tx.Exec(q, into, from); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("MergeGames: %w", err)
+		}
+	}
+	_, err = tx.Exec(`
+	UPDATE games SET
+	  finished_at = COALESCE(finished_at, (SELECT finished_at FROM games WHERE id = ?)),
+	  first_date = COALESCE(first_date, (SELECT first_date FROM games WHERE id = ?))
+	WHERE id = ?`, from, from, into)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("MergeGames: %w", err)
+	}
+	for _, q := range []string{`DELETE FROM store_ids WHERE game_id = ?`, `DELETE FROM games WHERE id = ?`} {
+		if _, err := tx.Exec(q, from); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("MergeGames: %w", err)
+		}
+	}
+	// Both games may have been running at the same time
+	if _, err := mergeOverlaps(tx, into, 0, "", ""); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("MergeGames: %w", err)
+	}
+	return tx.Commit()
+}
+
+// SetGameBlacklisted hides (or shows again) a game in the stats
+func (db *Database) SetGameBlacklisted(id int64, blacklisted bool) error {
+	_, err := db.Exec(`UPDATE games SET blacklisted = ? WHERE id = ?`, blacklisted, id)
+	return err
+}
+
+// RenameSmart supports renaming when `from` is either a game name or an executable.
+// - If a game is named `from`, the game is renamed (and merged if `to` exists).
+// - Otherwise the executable `from` is moved to the game named `to`.
+func (db *Database) RenameSmart(from, to string) error {
+	var id int64
+	err := db.Get(&id, `SELECT id FROM games WHERE name = ?`, from)
+	if err == nil {
+		_, err = db.RenameGame(id, to)
+		return err
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	target, err := db.ResolveGame(to)
+	if err != nil {
+		return err
+	}
+	return db.AssignExecutable(from, target)
+}
+
+func findGame(ex execer, name string) (int64, error) {
+	var id int64
+	err := ex.Get(&id, `SELECT id FROM games WHERE name = ?`, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ex.Get(&id, `SELECT game_id FROM game_executables WHERE process_name = ?`, name)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrGameNotFound
+	}
+	return id, err
+}
+
+func resolveGame(ex execer, name string) (int64, error) {
+	id, err := findGame(ex, name)
+	if !errors.Is(err, ErrGameNotFound) {
+		return id, err
+	}
+	res, err := ex.Exec(`INSERT INTO games (name) VALUES (?)`, name)
+	if err != nil {
+		return 0, fmt.Errorf("resolveGame: %w", err)
+	}
+	return res.LastInsertId()
+}
+
+func ensureGame(ex execer, processName string) (int64, error) {
+	var id int64
+	err := ex.Get(&id, `SELECT game_id FROM game_executables WHERE process_name = ?`, processName)
+	if err == nil || !errors.Is(err, sql.ErrNoRows) {
+		return id, err
+	}
+	id, err = resolveGame(ex, processName)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := ex.Exec(`INSERT INTO game_executables (process_name, game_id) VALUES (?, ?)`, processName, id); err != nil {
+		return 0, fmt.Errorf("ensureGame: %w", err)
+	}
+	// The executable or the game may have been blacklisted before being seen
+	_, err = ex.Exec(`UPDATE games SET blacklisted = TRUE
+	WHERE id = ? AND EXISTS (SELECT 1 FROM blacklist WHERE name IN (?, games.name))`, id, processName)
+	if err != nil {
+		return 0, fmt.Errorf("ensureGame: %w", err)
+	}
+	return id, nil
+}
+
+func assignExecutable(ex execer, processName string, gameID int64) error {
+	var old int64
+	err := ex.Get(&old, `SELECT game_id FROM game_executables WHERE process_name = ?`, processName)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("assignExecutable: %w", err)
+	}
+	_, err = ex.Exec(`INSERT INTO game_executables (process_name, game_id) VALUES (?, ?)
+	ON CONFLICT(process_name) DO UPDATE SET game_id=excluded.game_id`, processName, gameID)
+	if err != nil {
+		return fmt.Errorf("assignExecutable: %w", err)
+	}
+	if old == gameID {
+		return nil
+	}
+	// The executable may have been running with another one of the game
+	if _, err := mergeOverlaps(ex, gameID, 0, "", ""); err != nil {
+		return fmt.Errorf("assignExecutable: %w", err)
+	}
+	if old != 0 {
+		return deleteBareGame(ex, old)
+	}
+	return nil
+}
+
+// deleteBareGame removes a game left without executables nor any user data
+func deleteBareGame(ex execer, id int64) error {
+	_, err := ex.Exec(`
+	DELETE FROM games WHERE id = ?
+	  AND finished_at IS NULL AND first_date IS NULL AND NOT blacklisted
+	  AND NOT EXISTS (SELECT 1 FROM game_executables WHERE game_id = games.id)
+	  AND NOT EXISTS (SELECT 1 FROM store_ids WHERE game_id = games.id)
+	  AND NOT EXISTS (SELECT 1 FROM activities WHERE game_id = games.id)
+	  AND NOT EXISTS (SELECT 1 FROM active_sessions WHERE game_id = games.id)
+	  AND NOT EXISTS (SELECT 1 FROM list_rules WHERE game_id = games.id)
+	  AND NOT EXISTS (SELECT 1 FROM play_limits WHERE game_id = games.id)
+	  AND NOT EXISTS (SELECT 1 FROM curfews WHERE game_id = games.id)
+	  AND NOT EXISTS (SELECT 1 FROM curfew_exceptions WHERE game_id = games.id)`, id)
+	return err
+}
+
+// markBlacklistedGames flags the games whose name or one of whose executables
+// is in the blacklist
+func markBlacklistedGames(ex execer) error {
+	_, err := ex.Exec(`
+	UPDATE games SET blacklisted = TRUE
+	WHERE name IN (SELECT name FROM blacklist)
+	   OR EXISTS (SELECT 1 FROM game_executables ge JOIN blacklist b ON b.name = ge.process_name WHERE ge.game_id = games.id)`)
+	return err
+}
@@ -1,13 +1,35 @@
 package query
 
-import "github.com/jmoiron/sqlx"
+import (
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
 
 type Database struct {
 	*sqlx.DB
+
+	rulesMu sync.RWMutex
+	// sessionRules are applied to every saved session
+	sessionRules SessionRules
 }
 
 func NewDatabase(db *sqlx.DB) *Database {
 	return &Database{
-		db,
+		DB: db,
 	}
 }
+
+// SessionRules returns the thresholds applied to the saved sessions
+func (db *Database) SessionRules() SessionRules {
+	db.rulesMu.RLock()
+	defer db.rulesMu.RUnlock()
+	return db.sessionRules
+}
+
+// SetSessionRules changes the thresholds applied to the sessions saved from now on
+func (db *Database) SetSessionRules(rules SessionRules) {
+	db.rulesMu.Lock()
+	db.sessionRules = rules
+	db.rulesMu.Unlock()
+}
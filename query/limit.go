@@ -0,0 +1,204 @@
+package query
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// operations for play-time limits: a limit caps the time played on a game,
+// or on all games together, per day or per week
+
+// Limit periods
+const (
+	LimitDay  = "day"
+	LimitWeek = "week"
+)
+
+// Actions of the limits and curfews, each one doing what the previous ones do
+const (
+	// ActionNotify publishes the warning and breach events
+	ActionNotify = "notify"
+	// ActionWarn shows the breach in the tray too
+	ActionWarn = "warn"
+	// ActionTerminate stops the game once the grace period after the breach is over
+	ActionTerminate = "terminate"
+)
+
+// Weekdays lists days of the week (0 = Sunday), stored as "1,2,3"
+type Weekdays []time.Weekday
+
+// Value implements driver.Valuer
+func (w Weekdays) Value() (driver.Value, error) {
+	parts := make([]string, len(w))
+	for i, d := range w {
+		parts[i] = strconv.Itoa(int(d))
+	}
+	return strings.Join(parts, ","), nil
+}
+
+// Scan implements sql.Scanner
+func (w *Weekdays) Scan(src any) error {
+	var s string
+	switch v := src.(type) {
+	case nil:
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("Weekdays: unsupported type %T", src)
+	}
+	*w = Weekdays{}
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		d, err := strconv.Atoi(p)
+		if err != nil {
+			return fmt.Errorf("Weekdays: %w", err)
+		}
+		*w = append(*w, time.Weekday(d))
+	}
+	return nil
+}
+
+// Limit caps the play time of a game, or of all games when GameID is 0
+type Limit struct {
+	ID     int64  `db:"id" json:"id"`
+	GameID int64  `db:"game_id" json:"game_id,omitempty"`
+	Game   string `db:"game" json:"game,omitempty"`
+	// Period is LimitDay or LimitWeek
+	Period string `db:"period" json:"period"`
+	// Weekdays restricts a daily limit to some days (0 = Sunday), empty for every day
+	Weekdays Weekdays `db:"weekdays" json:"weekdays"`
+	Minutes  int      `db:"minutes" json:"minutes"`
+	// Action is ActionNotify, ActionWarn or ActionTerminate
+	Action string `db:"action" json:"action"`
+	// GraceSeconds is the delay between the breach and the termination
+	GraceSeconds int  `db:"grace_seconds" json:"grace_seconds"`
+	Enabled      bool `db:"enabled" json:"enabled"`
+}
+
+// ErrLimitNotFound is returned for an unknown limit id
+var ErrLimitNotFound = errors.New("limit not found")
+
+// Validate normalizes the limit and checks its fields
+func (l *Limit) Validate() error {
+	l.Period = strings.ToLower(strings.TrimSpace(l.Period))
+	l.Action = strings.ToLower(strings.TrimSpace(l.Action))
+	if l.Action == "" {
+		l.Action = ActionNotify
+	}
+	switch l.Period {
+	case LimitDay:
+	case LimitWeek:
+		if len(l.Weekdays) > 0 {
+			return errors.New("weekdays only apply to daily limits")
+		}
+	default:
+		return fmt.Errorf("unknown period %q", l.Period)
+	}
+	switch l.Action {
+	case ActionNotify, ActionWarn, ActionTerminate:
+	default:
+		return fmt.Errorf("unknown action %q", l.Action)
+	}
+	if l.Minutes <= 0 {
+		return errors.New("minutes must be positive")
+	}
+	if l.GraceSeconds < 0 {
+		return errors.New("grace_seconds can't be negative")
+	}
+	for _, d := range l.Weekdays {
+		if d < time.Sunday || d > time.Saturday {
+			return fmt.Errorf("invalid weekday %d", d)
+		}
+	}
+	slices.Sort(l.Weekdays)
+	l.Weekdays = slices.Compact(l.Weekdays)
+	if l.Weekdays == nil {
+		l.Weekdays = Weekdays{}
+	}
+	return nil
+}
+
+// AppliesOn tells whether the limit counts on the day of t
+func (l Limit) AppliesOn(t time.Time) bool {
+	return l.Period != LimitDay || len(l.Weekdays) == 0 || slices.Contains(l.Weekdays, t.Weekday())
+}
+
+// PeriodStart returns the start of the period of the limit containing t:
+// midnight for a daily limit, Monday midnight for a weekly one
+func (l Limit) PeriodStart(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if l.Period == LimitWeek {
+		// Weeks start on Monday
+		return day.AddDate(0, 0, -(int(t.Weekday())+6)%7)
+	}
+	return day
+}
+
+const limitColumns = `l.id, COALESCE(l.game_id, 0) AS game_id, COALESCE(g.name, '') AS game,
+	l.period, l.weekdays, l.minutes, l.action, l.grace_seconds, l.enabled
+	FROM play_limits l LEFT JOIN games g ON g.id = l.game_id`
+
+// GetLimits returns every limit, the global ones first
+func (db *Database) GetLimits() ([]Limit, error) {
+	limits := []Limit{}
+	err := db.Select(&limits, `SELECT `+limitColumns+` ORDER BY l.game_id IS NOT NULL, g.name COLLATE NOCASE, l.id`)
+	return limits, err
+}
+
+// GetLimit returns the limit id
+func (db *Database) GetLimit(id int64) (Limit, error) {
+	var limits []Limit
+	if err := db.Select(&limits, `SELECT `+limitColumns+` WHERE l.id = ?`, id); err != nil {
+		return Limit{}, err
+	}
+	if len(limits) == 0 {
+		return Limit{}, ErrLimitNotFound
+	}
+	return limits[0], nil
+}
+
+// SaveLimit validates l and inserts it, or updates it when l.ID is set
+func (db *Database) SaveLimit(l Limit) (Limit, error) {
+	if err := l.Validate(); err != nil {
+		return Limit{}, err
+	}
+	var gameID any
+	if l.GameID > 0 {
+		gameID = l.GameID
+	}
+	if l.ID == 0 {
+		res, err := db.Exec(`INSERT INTO play_limits (game_id, period, weekdays, minutes, action, grace_seconds, enabled) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			gameID, l.Period, l.Weekdays, l.Minutes, l.Action, l.GraceSeconds, l.Enabled)
+		if err != nil {
+			return Limit{}, fmt.Errorf("SaveLimit: %w", err)
+		}
+		if l.ID, err = res.LastInsertId(); err != nil {
+			return Limit{}, fmt.Errorf("SaveLimit: %w", err)
+		}
+	} else {
+		res, err := db.Exec(`UPDATE play_limits SET game_id = ?, period = ?, weekdays = ?, minutes = ?, action = ?, grace_seconds = ?, enabled = ? WHERE id = ?`,
+			gameID, l.Period, l.Weekdays, l.Minutes, l.Action, l.GraceSeconds, l.Enabled, l.ID)
+		if err != nil {
+			return Limit{}, fmt.Errorf("SaveLimit: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return Limit{}, ErrLimitNotFound
+		}
+	}
+	return db.GetLimit(l.ID)
+}
+
+// DeleteLimit removes the limit id
+func (db *Database) DeleteLimit(id int64) error {
+	_, err := db.Exec(`DELETE FROM play_limits WHERE id = ?`, id)
+	return err
+}
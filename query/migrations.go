@@ -0,0 +1,533 @@
+package query
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// schema migrations: each one runs in its own transaction and is recorded in
+// schema_migrations. A new database is the initial schema brought up to date
+// by the same migrations, so fresh and upgraded databases can't diverge.
+
+// BackupFolder is the folder of the backups, in the data folder
+const BackupFolder = "backups"
+
+type migration struct {
+	Version int
+	Name    string
+	up      func(tx *sqlx.Tx) error
+}
+
+// initialSchema is the schema of version 0, before any migration
+const initialSchema = `
+	CREATE TABLE IF NOT EXISTS activities (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		process_name TEXT NOT NULL,
+		window_title TEXT,
+		start_time DATETIME NOT NULL,
+		end_time DATETIME NOT NULL,
+		duration INTEGER NOT NULL,
+		date TEXT NOT NULL
+	);`
+
+const migrationsSchema = `
+	CREATE TABLE IF NOT EXISTS database_version (
+		db_version INTEGER default 0
+	);
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		-- NULL for the versions applied before the migrations were recorded
+		applied_at TEXT
+	);`
+
+// migrations are applied in order, Version is the schema version once applied
+var migrations = []migration{
+	{1, "activities first_launch", func(tx *sqlx.Tx) error {
+		if err := dropColumn(tx, "activities", "window_title"); err != nil {
+			return err
+		}
+		return addColumn(tx, "activities", "first_launch", "BOOLEAN DEFAULT FALSE")
+	}},
+	{2, "whitelist and blacklist", execSQL(`
+		CREATE TABLE IF NOT EXISTS whitelist (
+			name TEXT PRIMARY KEY
+		);
+		CREATE TABLE IF NOT EXISTS blacklist (
+			name TEXT PRIMARY KEY
+		);`)},
+	{3, "rename_map", execSQL(`
+		CREATE TABLE IF NOT EXISTS rename_map (
+			original_name TEXT PRIMARY KEY,
+			display_name TEXT NOT NULL
+		);`)},
+	{4, "finished_games", execSQL(`
+		CREATE TABLE IF NOT EXISTS finished_games (
+			name TEXT PRIMARY KEY
+		);`)},
+	{5, "finished_games finished_at", func(tx *sqlx.Tx) error {
+		return addColumn(tx, "finished_games", "finished_at", "TEXT")
+	}},
+	{6, "first_launch_override", execSQL(`
+		CREATE TABLE IF NOT EXISTS first_launch_override (
+			name TEXT PRIMARY KEY,
+			first_date TEXT
+		);`)},
+	{7, "activities unique index", execSQL(`
+		CREATE INDEX IF NOT EXISTS idx_activities_unique ON activities(process_name, start_time, end_time);`)},
+	{8, "split sessions at midnight", splitAtMidnight},
+	{9, "activities start_source", func(tx *sqlx.Tx) error {
+		return addColumn(tx, "activities", "start_source", "TEXT")
+	}},
+	{10, "active_sessions", execSQL(`
+		CREATE TABLE IF NOT EXISTS active_sessions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			process_name TEXT NOT NULL,
+			start_time DATETIME NOT NULL,
+			last_heartbeat DATETIME NOT NULL,
+			start_source TEXT
+		);`)},
+	{11, "activities idle_seconds", func(tx *sqlx.Tx) error {
+		return addColumn(tx, "activities", "idle_seconds", "REAL DEFAULT 0")
+	}},
+	// window_title was dropped in v1, it is now filled by focus tracking
+	{12, "activities window_title and unfocused_seconds", func(tx *sqlx.Tx) error {
+		if err := addColumn(tx, "activities", "window_title", "TEXT"); err != nil {
+			return err
+		}
+		return addColumn(tx, "activities", "unfocused_seconds", "REAL DEFAULT 0")
+	}},
+	{13, "list_rules", execSQL(`
+		CREATE TABLE IF NOT EXISTS list_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			list TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			pattern TEXT NOT NULL,
+			case_insensitive BOOLEAN DEFAULT FALSE,
+			priority INTEGER DEFAULT 0
+		);`)},
+	{14, "store_ids", execSQL(`
+		CREATE TABLE IF NOT EXISTS store_ids (
+			name TEXT NOT NULL,
+			store TEXT NOT NULL,
+			store_id TEXT NOT NULL,
+			PRIMARY KEY (name, store)
+		);`)},
+	// Games are built from the rename map: one game per display name, and
+	// one per process name that was never renamed
+	{15, "games", execSQL(gamesSchema + `
+		INSERT OR IGNORE INTO games (name)
+		  SELECT display_name FROM rename_map
+		  UNION SELECT process_name FROM activities WHERE process_name NOT IN (SELECT original_name FROM rename_map)
+		  UNION SELECT process_name FROM active_sessions WHERE process_name NOT IN (SELECT original_name FROM rename_map);
+		INSERT OR IGNORE INTO game_executables (process_name, game_id)
+		  SELECT r.original_name, g.id FROM rename_map r JOIN games g ON g.name = r.display_name;
+		INSERT OR IGNORE INTO game_executables (process_name, game_id)
+		  SELECT DISTINCT p.process_name, g.id
+		  FROM (SELECT process_name FROM activities UNION SELECT process_name FROM active_sessions) p
+		  JOIN games g ON g.name = p.process_name;
+
+		-- Finished state and overrides were keyed by display name or process name
+		UPDATE games SET finished_at = COALESCE(
+		  (SELECT COALESCE(f.finished_at, '') FROM finished_games f WHERE f.name = games.name),
+		  (SELECT COALESCE(MAX(f.finished_at), '') FROM finished_games f
+		   JOIN game_executables ge ON ge.process_name = f.name WHERE ge.game_id = games.id HAVING COUNT(*) > 0));
+		UPDATE games SET first_date = COALESCE(
+		  (SELECT o.first_date FROM first_launch_override o WHERE o.name = games.name),
+		  (SELECT MIN(o.first_date) FROM first_launch_override o
+		   JOIN game_executables ge ON ge.process_name = o.name WHERE ge.game_id = games.id));
+		-- whitelist and blacklist keep their names: they match process paths,
+		-- before any game exists; the blacklist only flags the games here
+		UPDATE games SET blacklisted = TRUE
+		WHERE name IN (SELECT name FROM blacklist)
+		   OR EXISTS (SELECT 1 FROM game_executables ge JOIN blacklist b ON b.name = ge.process_name WHERE ge.game_id = games.id);
+
+		-- Store ids move from process names to games
+		CREATE TABLE store_ids_game AS
+		  SELECT DISTINCT ge.game_id AS game_id, s.store AS store, s.store_id AS store_id
+		  FROM store_ids s JOIN game_executables ge ON ge.process_name = s.name;
+		DROP TABLE store_ids;
+		CREATE TABLE store_ids (
+			game_id INTEGER NOT NULL,
+			store TEXT NOT NULL,
+			store_id TEXT NOT NULL,
+			PRIMARY KEY (game_id, store)
+		);
+		INSERT OR IGNORE INTO store_ids (game_id, store, store_id) SELECT game_id, store, store_id FROM store_ids_game;
+		DROP TABLE store_ids_game;
+
+		DROP TABLE rename_map;
+		DROP TABLE finished_games;
+		DROP TABLE first_launch_override;`)},
+	{16, "launcher rules and attributed activities", func(tx *sqlx.Tx) error {
+		for _, c := range [][3]string{
+			{"list_rules", "track_children", "BOOLEAN DEFAULT FALSE"},
+			{"list_rules", "game_id", "INTEGER"},
+			{"activities", "game_id", "INTEGER"},
+			{"active_sessions", "game_id", "INTEGER"},
+		} {
+			if err := addColumn(tx, c[0], c[1], c[2]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{17, "settings", execSQL(`
+		CREATE TABLE IF NOT EXISTS settings (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		);`)},
+	// The index used to be created for new databases only
+	{18, "activities date index", execSQL(`
+		CREATE INDEX IF NOT EXISTS idx_activities_date ON activities(date);`)},
+	{19, "play limits", execSQL(`
+		CREATE TABLE IF NOT EXISTS play_limits (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			-- NULL for a limit on all games together
+			game_id INTEGER REFERENCES games(id) ON DELETE CASCADE,
+			period TEXT NOT NULL,
+			-- comma separated days (0 = Sunday) a daily limit applies to, empty for every day
+			weekdays TEXT NOT NULL DEFAULT '',
+			minutes INTEGER NOT NULL,
+			action TEXT NOT NULL DEFAULT 'notify',
+			grace_seconds INTEGER NOT NULL DEFAULT 0,
+			enabled BOOLEAN NOT NULL DEFAULT TRUE
+		);`)},
+	{20, "curfews", execSQL(`
+		CREATE TABLE IF NOT EXISTS curfews (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			-- NULL for a curfew on all games
+			game_id INTEGER REFERENCES games(id) ON DELETE CASCADE,
+			-- comma separated days (0 = Sunday) of the window, empty for every day
+			weekdays TEXT NOT NULL DEFAULT '',
+			-- allowed window, HH:MM local time; ends the next day when window_end <= window_start
+			window_start TEXT NOT NULL,
+			window_end TEXT NOT NULL,
+			action TEXT NOT NULL DEFAULT 'notify',
+			grace_seconds INTEGER NOT NULL DEFAULT 0,
+			enabled BOOLEAN NOT NULL DEFAULT TRUE
+		);
+		CREATE TABLE IF NOT EXISTS curfew_exceptions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			-- NULL for an exception lifting the curfews of all games
+			game_id INTEGER REFERENCES games(id) ON DELETE CASCADE,
+			start_date TEXT NOT NULL,
+			end_date TEXT NOT NULL,
+			note TEXT NOT NULL DEFAULT ''
+		);
+		CREATE TABLE IF NOT EXISTS curfew_violations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			game_id INTEGER,
+			-- the name is kept with the violation, which outlives its game
+			game TEXT NOT NULL,
+			process_name TEXT NOT NULL,
+			curfew_id INTEGER,
+			at TEXT NOT NULL,
+			-- start: launched outside the allowed windows, running: a window ended during the session
+			kind TEXT NOT NULL,
+			action TEXT NOT NULL,
+			terminated BOOLEAN NOT NULL DEFAULT FALSE
+		);
+		CREATE INDEX IF NOT EXISTS idx_curfew_violations_at ON curfew_violations(at);`)},
+	{21, "webhooks", execSQL(`
+		CREATE TABLE IF NOT EXISTS webhooks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			url TEXT NOT NULL,
+			-- key of the HMAC-SHA256 signature of the payloads, empty for unsigned ones
+			secret TEXT NOT NULL DEFAULT '',
+			-- comma separated events sent, empty for all of them
+			events TEXT NOT NULL DEFAULT '',
+			enabled BOOLEAN NOT NULL DEFAULT TRUE
+		);
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			webhook_id INTEGER NOT NULL,
+			event TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			created_at TEXT NOT NULL,
+			-- pending until delivered, or failed once every attempt is used
+			state TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at TEXT,
+			status_code INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT '',
+			delivered_at TEXT
+		);
+		CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_state ON webhook_deliveries(state, next_attempt_at);`)},
+}
+
+// SchemaVersion is the version of the schema once every migration is applied
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// migrate brings the schema up to date. An existing database with pending
+// migrations is first copied into the backup folder of dataDir.
+func (db *Database) migrate(dataDir string) error {
+	version, fresh, err := db.currentVersion()
+	if err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+	if version >= SchemaVersion() {
+		return nil
+	}
+	if !fresh {
+		path, err := db.backupBeforeMigration(dataDir, version)
+		if err != nil {
+			return fmt.Errorf("migrate: backup: %w", err)
+		}
+		fmt.Printf("Sauvegarde avant migration: %s\n", path)
+	}
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+		if err := db.apply(m); err != nil {
+			return fmt.Errorf("migrate version %d (%s): %w", m.Version, m.Name, err)
+		}
+		if !fresh {
+			fmt.Printf("db version up to %d (%s)\n", m.Version, m.Name)
+		}
+	}
+	if fresh {
+		fmt.Printf("Base de données créée (version %d)\n", SchemaVersion())
+	}
+	return nil
+}
+
+// apply runs a migration and records it in the same transaction
+func (db *Database) apply(m migration) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := m.up(tx); err != nil {
+		return err
+	}
+	if err := recordVersion(tx, m.Version, m.Name, time.Now().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// recordVersion adds a row to schema_migrations and keeps database_version,
+// read by older versions of the application, in sync
+func recordVersion(tx *sqlx.Tx, version int, name string, appliedAt any) error {
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`, version, name, appliedAt); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`DELETE FROM database_version; INSERT INTO database_version (db_version) VALUES (?)`, version)
+	return err
+}
+
+// currentVersion returns the version of the schema, creating the initial one
+// for a new database. Databases from before schema_migrations get their past
+// versions recorded without a date.
+func (db *Database) currentVersion() (version int, fresh bool, err error) {
+	hasMigrations, err := db.TableExists("schema_migrations")
+	if err != nil {
+		return 0, false, err
+	}
+	if hasMigrations {
+		err := db.Get(&version, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`)
+		return version, false, err
+	}
+	hasActivities, err := db.TableExists("activities")
+	if err != nil {
+		return 0, false, err
+	}
+	if !hasActivities {
+		fresh = true
+	} else if version, err = db.legacyVersion(); err != nil {
+		return 0, false, err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+	stmts := migrationsSchema
+	if fresh {
+		stmts = initialSchema + migrationsSchema
+	}
+	if _, err := tx.Exec(stmts); err != nil {
+		return 0, false, err
+	}
+	for _, m := range migrations {
+		if m.Version > version {
+			break
+		}
+		if err := recordVersion(tx, m.Version, m.Name, nil); err != nil {
+			return 0, false, err
+		}
+	}
+	return version, fresh, tx.Commit()
+}
+
+// legacyVersion reads database_version. New databases used to be created
+// without its row: their version is then deduced from the schema.
+func (db *Database) legacyVersion() (int, error) {
+	hasVersion, err := db.TableExists(TableDatabaseVersion)
+	if err != nil {
+		return 0, err
+	}
+	if hasVersion {
+		var version int
+		err := db.Get(&version, `SELECT db_version FROM database_version LIMIT 1`)
+		if err == nil {
+			return version, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+	}
+	// Newest first: each is the last migration creating that table or column
+	probes := []struct {
+		version       int
+		table, column string
+	}{
+		{17, "settings", ""},
+		{16, "activities", "game_id"},
+		{15, "games", ""},
+		{14, "store_ids", ""},
+		{13, "list_rules", ""},
+		{12, "activities", "unfocused_seconds"},
+		{11, "activities", "idle_seconds"},
+		{10, "active_sessions", ""},
+		{9, "activities", "start_source"},
+		{6, "first_launch_override", ""},
+		{5, "finished_games", "finished_at"},
+		{4, "finished_games", ""},
+		{3, "rename_map", ""},
+		{2, "whitelist", ""},
+		{1, "activities", "first_launch"},
+	}
+	for _, p := range probes {
+		var ok bool
+		var err error
+		if p.column == "" {
+			ok, err = db.TableExists(p.table)
+		} else {
+			ok, err = columnExists(db, p.table, p.column)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return p.version, nil
+		}
+	}
+	return 0, nil
+}
+
+// backupBeforeMigration copies the database into the backup folder, named
+// after its version
+func (db *Database) backupBeforeMigration(dataDir string, version int) (string, error) {
+	dir := filepath.Join(dataDir, BackupFolder)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("pre-migration-v%d-%s.db", version, time.Now().Format("20060102-150405")))
+	if err := db.BackupTo(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func execSQL(stmts string) func(tx *sqlx.Tx) error {
+	return func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(stmts)
+		return err
+	}
+}
+
+func columnExists(ex sqlx.Queryer, table, column string) (bool, error) {
+	var n int
+	err := sqlx.Get(ex, &n, `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column)
+	return n > 0, err
+}
+
+// addColumn adds a column unless the table already has it, as tables created
+// by older versions for new databases sometimes do
+func addColumn(tx *sqlx.Tx, table, column, decl string) error {
+	ok, err := columnExists(tx, table, column)
+	if err != nil || ok {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, decl))
+	return err
+}
+
+func dropColumn(tx *sqlx.Tx, table, column string) error {
+	ok, err := columnExists(tx, table, column)
+	if err != nil || !ok {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE %s DROP COLUMN %s`, table, column))
+	return err
+}
+
+// splitAtMidnight splits the historical sessions spanning midnight into
+// day-bounded segments
+func splitAtMidnight(tx *sqlx.Tx) error {
+	type actRow struct {
+		ID          int64   `db:"id"`
+		ProcessName string  `db:"process_name"`
+		Start       string  `db:"start_time"`
+		End         string  `db:"end_time"`
+		Duration    float64 `db:"duration"`
+		DateStr     string  `db:"date"`
+		FirstLaunch bool    `db:"first_launch"`
+	}
+	rows := []actRow{}
+	q := `SELECT id, process_name, start_time, end_time, duration, date, first_launch
+	      FROM activities
+	      WHERE substr(start_time,1,10) != substr(end_time,1,10)
+	         OR date != substr(start_time,1,10)`
+	if err := tx.Select(&rows, q); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		start, err1 := time.Parse(time.RFC3339, r.Start)
+		end, err2 := time.Parse(time.RFC3339, r.End)
+		if err1 != nil || err2 != nil || !end.After(start) {
+			// If parse fails or invalid, skip splitting; keep original row
+			continue
+		}
+		currentStart := start
+		firstFlag := r.FirstLaunch
+		for currentStart.Before(end) {
+			year, month, day := currentStart.Date()
+			loc := currentStart.Location()
+			nextDayStart := time.Date(year, month, day, 0, 0, 0, 0, loc).Add(24 * time.Hour)
+			segmentEnd := end
+			if end.After(nextDayStart) {
+				segmentEnd = nextDayStart
+			}
+			if segmentEnd.After(currentStart) {
+				_, err := tx.Exec(`
+					INSERT INTO activities (process_name, start_time, end_time, duration, date, first_launch)
+					VALUES (?, ?, ?, ?, ?, ?)
+				`, r.ProcessName, currentStart.Format(time.RFC3339), segmentEnd.Format(time.RFC3339), segmentEnd.Sub(currentStart).Seconds(), currentStart.Format("2006-01-02"), firstFlag)
+				if err != nil {
+					return err
+				}
+				firstFlag = false
+			}
+			currentStart = segmentEnd
+		}
+		// Delete original row after inserting the split segments
+		if _, err := tx.Exec(`DELETE FROM activities WHERE id = ?`, r.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package query
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"main/datadir"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// schemaOf describes every table and index of db: its columns from
+// pragma_table_info, or its indexed columns from pragma_index_info
+func schemaOf(t *testing.T, db *sqlx.DB) map[string][]string {
+	t.Helper()
+	var objects []struct {
+		Type  string `db:"type"`
+		Name  string `db:"name"`
+		Table string `db:"tbl_name"`
+	}
+	err := db.Select(&objects, `SELECT type, name, tbl_name FROM sqlite_master WHERE type IN ('table', 'index') ORDER BY name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := map[string][]string{}
+	for _, o := range objects {
+		var cols []string
+		if o.Type == "table" {
+			err = db.Select(&cols, `SELECT name || ' ' || type || ' notnull=' || "notnull" || ' default=' || COALESCE(dflt_value, 'NULL') || ' pk=' || pk
+				FROM pragma_table_info(?) ORDER BY cid`, o.Name)
+		} else {
+			err = db.Select(&cols, `SELECT COALESCE(name, '') FROM pragma_index_info(?) ORDER BY seqno`, o.Name)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		schema[fmt.Sprintf("%s %s on %s", o.Type, o.Name, o.Table)] = cols
+	}
+	return schema
+}
+
+func TestMigrationsMatchFreshSchema(t *testing.T) {
+	fresh, err := OpenDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fresh.Close()
+	want := schemaOf(t, fresh.DB)
+
+	for _, tc := range []struct {
+		name string
+		// setup builds the database of version 0
+		setup string
+	}{
+		{"versioned", initialSchema + `
+			CREATE TABLE database_version (db_version INTEGER default 0);
+			INSERT INTO database_version (db_version) VALUES (0);`},
+		{"without version", initialSchema},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			old, err := sqlx.Open("sqlite", filepath.Join(dir, datadir.DatabaseFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = old.Exec(tc.setup + `
+				INSERT INTO activities (process_name, window_title, start_time, end_time, duration, date)
+				VALUES ('game.exe', 'Game', '2024-05-01T23:30:00Z', '2024-05-02T00:30:00Z', 3600, '2024-05-01');`)
+			old.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			db, err := OpenDatabase(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			got := schemaOf(t, db.DB)
+			for name, cols := range want {
+				if !reflect.DeepEqual(got[name], cols) {
+					t.Errorf("%s:\n got  %v\n want %v", name, got[name], cols)
+				}
+			}
+			for name := range got {
+				if _, ok := want[name]; !ok {
+					t.Errorf("%s only exists in the migrated database", name)
+				}
+			}
+
+			var versions []int
+			if err := db.Select(&versions, `SELECT version FROM schema_migrations ORDER BY version`); err != nil {
+				t.Fatal(err)
+			}
+			if len(versions) != SchemaVersion() || versions[len(versions)-1] != SchemaVersion() {
+				t.Errorf("recorded versions %v, want 1..%d", versions, SchemaVersion())
+			}
+			// The session is kept, split at midnight
+			var total float64
+			if err := db.Get(&total, `SELECT SUM(duration) FROM activities WHERE process_name = 'game.exe'`); err != nil {
+				t.Fatal(err)
+			}
+			if total != 3600 {
+				t.Errorf("activities total %v, want 3600", total)
+			}
+		})
+	}
+}
+
+func TestForeignKeysCascade(t *testing.T) {
+	db, err := OpenDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	id, err := db.EnsureGame("game.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+		INSERT INTO play_limits (game_id, period, minutes) VALUES (?, 'daily', 60);
+		INSERT INTO curfews (game_id, window_start, window_end) VALUES (?, '08:00', '20:00');
+		INSERT INTO curfew_exceptions (game_id, start_date, end_date) VALUES (?, '2026-01-01', '2026-01-02');
+		DELETE FROM game_executables WHERE game_id = ?;
+		DELETE FROM games WHERE id = ?;`, id, id, id, id, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"play_limits", "curfews", "curfew_exceptions"} {
+		var n int
+		if err := db.Get(&n, `SELECT COUNT(*) FROM `+table); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("%d rows left in %s once their game is deleted", n, table)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package query
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// overlapping activities of a game are merged into a single row covering the
+// union of their intervals, so that a second is never counted twice for a game
+
+// OverlapReport sums up a de-duplication pass
+type OverlapReport struct {
+	// Games is the number of games that had overlapping activities
+	Games int `json:"games"`
+	// Removed is the number of rows merged into another one
+	Removed int `json:"removed"`
+	// Seconds is the double-counted time removed from the totals
+	Seconds float64 `json:"seconds"`
+}
+
+func (r *OverlapReport) add(o OverlapReport) {
+	r.Games += o.Games
+	r.Removed += o.Removed
+	r.Seconds += o.Seconds
+}
+
+type overlapRow struct {
+	ID          int64   `db:"id"`
+	ProcessName string  `db:"process_name"`
+	WindowTitle string  `db:"window_title"`
+	StartTime   string  `db:"start_time"`
+	EndTime     string  `db:"end_time"`
+	Duration    float64 `db:"duration"`
+	FirstLaunch bool    `db:"first_launch"`
+	Idle        float64 `db:"idle_seconds"`
+	Unfocused   float64 `db:"unfocused_seconds"`
+	Date        string  `db:"date"`
+	GameID      *int64  `db:"game_id"`
+
+	start, end time.Time
+}
+
+// MergeOverlaps merges the overlapping activities of a game
+func (db *Database) MergeOverlaps(gameID int64) (OverlapReport, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return OverlapReport{}, fmt.Errorf("MergeOverlaps: %w", err)
+	}
+	rep, err := mergeOverlaps(tx, gameID, 0, "", "")
+	if err != nil {
+		tx.Rollback()
+		return OverlapReport{}, fmt.Errorf("MergeOverlaps: %w", err)
+	}
+	return rep, tx.Commit()
+}
+
+// RepairOverlaps merges the overlapping activities of every game. With apply
+// false nothing is changed, the report tells what would be merged.
+func (db *Database) RepairOverlaps(apply bool) (OverlapReport, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return OverlapReport{}, fmt.Errorf("RepairOverlaps: %w", err)
+	}
+	defer tx.Rollback()
+	rep, err := repairOverlaps(tx)
+	if err != nil {
+		return OverlapReport{}, fmt.Errorf("RepairOverlaps: %w", err)
+	}
+	if !apply {
+		return rep, nil
+	}
+	return rep, tx.Commit()
+}
+
+func repairOverlaps(ex execer) (OverlapReport, error) {
+	var rep OverlapReport
+	var ids []int64
+	if err := sqlx.Select(ex, &ids, `SELECT id FROM games ORDER BY id`); err != nil {
+		return rep, err
+	}
+	for _, id := range ids {
+		r, err := mergeOverlaps(ex, id, 0, "", "")
+		if err != nil {
+			return OverlapReport{}, err
+		}
+		rep.add(r)
+	}
+	return rep, nil
+}
+
+// mergeOverlaps merges the overlapping activities of a game, and those of a
+// same day separated by less than gap, restricted to the days between from and
+// to (YYYY-MM-DD) when they are set. A merged row spans the gaps, but its
+// duration only counts the played time. Activities are day-bounded, so rows of
+// different days are never merged.
+func mergeOverlaps(ex execer, gameID int64, gap time.Duration, from, to string) (OverlapReport, error) {
+	var rep OverlapReport
+	valid, err := gameRows(ex, gameID, from, to)
+	if err != nil {
+		return rep, err
+	}
+	for i := 0; i < len(valid); {
+		group := valid[i : i+1]
+		end := valid[i].end
+		j := i + 1
+		for ; j < len(valid); j++ {
+			next := valid[j]
+			if !next.start.Before(end) && (gap <= 0 || next.Date != valid[i].Date || next.start.Sub(end) >= gap) {
+				break
+			}
+			group = valid[i : j+1]
+			if next.end.After(end) {
+				end = next.end
+			}
+		}
+		if len(group) > 1 {
+			saved, err := mergeGroup(ex, gameID, group, end)
+			if err != nil {
+				return rep, err
+			}
+			rep.Removed += len(group) - 1
+			rep.Seconds += saved
+		}
+		i = j
+	}
+	if rep.Removed > 0 {
+		rep.Games = 1
+	}
+	return rep, nil
+}
+
+// gameRows returns the valid activities of a game between from and to when
+// they are set, sorted by start
+func gameRows(ex execer, gameID int64, from, to string) ([]overlapRow, error) {
+	q := `SELECT a.id, a.process_name, COALESCE(a.window_title,'') AS window_title, a.start_time, a.end_time, a.duration,
+	         COALESCE(a.first_launch, FALSE) AS first_launch, COALESCE(a.idle_seconds,0) AS idle_seconds,
+	         COALESCE(a.unfocused_seconds,0) AS unfocused_seconds, a.date, a.game_id
+	      FROM activities a
+	      LEFT JOIN game_executables ge ON ge.process_name = a.process_name
+	      WHERE COALESCE(a.game_id, ge.game_id) = ?`
+	args := []any{gameID}
+	if from != "" && to != "" {
+		q += ` AND a.date BETWEEN ? AND ?`
+		args = append(args, from, to)
+	}
+	rows := []overlapRow{}
+	if err := sqlx.Select(ex, &rows, q, args...); err != nil {
+		return nil, err
+	}
+	valid := rows[:0]
+	for _, r := range rows {
+		var err1, err2 error
+		r.start, err1 = time.Parse(time.RFC3339, r.StartTime)
+		r.end, err2 = time.Parse(time.RFC3339, r.EndTime)
+		if err1 == nil && err2 == nil && r.end.After(r.start) {
+			valid = append(valid, r)
+		}
+	}
+	// Times may have different offsets, so they are compared once parsed
+	sort.Slice(valid, func(i, j int) bool {
+		if !valid[i].start.Equal(valid[j].start) {
+			return valid[i].start.Before(valid[j].start)
+		}
+		return valid[i].ID < valid[j].ID
+	})
+	return valid, nil
+}
+
+// mergeGroup keeps the earliest row of a group of activities, extended to
+// end, and deletes the others. The duration is the played time of the union of
+// their intervals, without the gaps between them. It returns the double-counted
+// seconds removed.
+func mergeGroup(ex execer, gameID int64, group []overlapRow, end time.Time) (float64, error) {
+	keep := group[0]
+	var total, longest float64
+	duration, idle, unfocused := keep.Duration, keep.Idle, keep.Unfocused
+	title, first, gameOf := keep.WindowTitle, false, keep.GameID
+	spanEnd := keep.end
+	// unplayed is the time not played between keep.start and spanEnd: the
+	// gaps between the rows, and those inside rows merged before
+	unplayed := keep.end.Sub(keep.start).Seconds() - keep.Duration
+	for i, r := range group {
+		total += r.Duration
+		span := r.end.Sub(r.start).Seconds()
+		if i > 0 && span > 0 {
+			// The played, idle and unfocused times are assumed spread over the
+			// row. Its part past the rows before it adds up, its part within
+			// them can only fill their unplayed time.
+			fresh := 0.0
+			if r.end.After(spanEnd) {
+				unplayed += max(r.start.Sub(spanEnd).Seconds(), 0)
+				fresh = r.end.Sub(maxTime(r.start, spanEnd)).Seconds()
+				spanEnd = r.end
+			}
+			filled := min(r.Duration*(span-fresh)/span, max(unplayed, 0))
+			played := r.Duration * fresh / span
+			duration += played + filled
+			unplayed += fresh - played - filled
+			if part := played + filled; part > 0 {
+				idle += r.Idle * part / r.Duration
+				unfocused += r.Unfocused * part / r.Duration
+			}
+		}
+		first = first || r.FirstLaunch
+		if r.WindowTitle != "" && r.Duration > longest {
+			title, longest = r.WindowTitle, r.Duration
+		}
+		// Rows of several executables stay with the game they were merged into
+		if r.ProcessName != keep.ProcessName {
+			gameOf = &gameID
+		}
+	}
+	duration = min(duration, end.Sub(keep.start).Seconds())
+	_, err := ex.Exec(`UPDATE activities SET end_time = ?, duration = ?, idle_seconds = ?, unfocused_seconds = ?,
+	    window_title = ?, first_launch = ?, game_id = ? WHERE id = ?`,
+		end.In(keep.start.Location()).Format(time.RFC3339), duration,
+		min(idle, duration), min(unfocused, duration), title, first, gameOf, keep.ID)
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range group[1:] {
+		if _, err := ex.Exec(`DELETE FROM activities WHERE id = ?`, r.ID); err != nil {
+			return 0, err
+		}
+	}
+	return total - duration, nil
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
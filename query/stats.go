@@ -6,56 +6,100 @@ import (
 )
 
 type SummaryItem struct {
-	Name     string  `db:"name" json:"name"`
-	Seconds  float64 `db:"seconds" json:"seconds"`
+	GameID           int64   `db:"game_id" json:"game_id"`
+	Name             string  `db:"name" json:"name"`
+	Seconds          float64 `db:"seconds" json:"seconds"`
+	IdleSeconds      float64 `db:"idle_seconds" json:"idle_seconds"`
+	ActiveSeconds    float64 `db:"active_seconds" json:"active_seconds"`
+	UnfocusedSeconds float64 `db:"unfocused_seconds" json:"unfocused_seconds"`
+	FocusedSeconds   float64 `db:"focused_seconds" json:"focused_seconds"`
 }
 
+// Metric selects which part of the sessions is counted as play time
+type Metric string
+
+const (
+	MetricTotal   Metric = "total"
+	MetricActive  Metric = "active"  // excluding idle time
+	MetricFocused Metric = "focused" // excluding time with the game in the background
+)
+
+// durationExpr returns the SQL expression summed as play time for a metric
+func durationExpr(metric Metric) string {
+	switch metric {
+	case MetricActive:
+		return "(b.duration - COALESCE(b.idle_seconds, 0))"
+	case MetricFocused:
+		return "(b.duration - COALESCE(b.unfocused_seconds, 0))"
+	}
+	return "b.duration"
+}
+
+// gameBase joins every activity to its game: the one it was attributed to, or
+// else the game of its executable. SaveActivity, the import and the migration
+// to version 15 map each process name to a game, so no activity is lost.
+const gameBase = `
+	WITH base AS (
+	  SELECT a.id, a.process_name, a.window_title, a.start_time, a.end_time, a.duration, a.date,
+	         a.first_launch, a.start_source, a.idle_seconds, a.unfocused_seconds,
+	         substr(a.start_time,1,10) AS sdate,
+	         g.id AS game_id, g.name AS game_name, g.blacklisted AS game_blacklisted,
+	         g.finished_at AS game_finished_at, g.first_date AS game_first_date
+	  FROM activities a
+	  LEFT JOIN game_executables ge ON ge.process_name = a.process_name
+	  JOIN games g ON g.id = COALESCE(a.game_id, ge.game_id)
+	)`
+
 // GameMeta represents flags for games within a period
 type GameMeta struct {
+	GameID           int64  `db:"game_id" json:"game_id"`
 	Name             string `db:"name" json:"name"`
 	IsNew            bool   `db:"is_new" json:"is_new"`
 	FinishedInPeriod bool   `db:"finished_in_period" json:"finished_in_period"`
 }
 
-// KnownProc summarizes a known (display) process with flags
+// KnownProc summarizes a known game with flags
 type KnownProc struct {
+	GameID      int64  `db:"game_id" json:"game_id"`
 	Name        string `db:"name" json:"name"`
 	Original    string `db:"original" json:"original"`
 	Sessions    int    `db:"sessions" json:"sessions"`
 	Whitelisted bool   `db:"whitelisted" json:"whitelisted"`
 	Blacklisted bool   `db:"blacklisted" json:"blacklisted"`
+	// StoreIDs maps a store (steam, gog, epic) to the id of the game there
+	StoreIDs map[string]string `db:"-" json:"store_ids,omitempty"`
 }
 
 // SessionItem represents a single recorded session (non-aggregated)
 type SessionItem struct {
+	GameID      int64   `db:"game_id" json:"game_id"`
 	Name        string  `db:"name" json:"name"`
 	Original    string  `db:"original" json:"original"`
 	Date        string  `db:"date" json:"date"`
 	Start       string  `db:"start_time" json:"start_time"`
 	End         string  `db:"end_time" json:"end_time"`
 	Seconds     float64 `db:"duration" json:"seconds"`
+	StartSource string  `db:"start_source" json:"start_source"`
+	WindowTitle string  `db:"window_title" json:"window_title"`
 	Finished    bool    `db:"finished" json:"finished"`
 	Blacklisted bool    `db:"blacklisted" json:"blacklisted"`
 }
 
-// GetSummaryBetween returns aggregated durations per (renamed) process between inclusive dates (YYYY-MM-DD)
-func (db *Database) GetSummaryBetween(startDate, endDate string) ([]SummaryItem, error) {
+// GetSummaryBetween returns aggregated durations per game between inclusive dates (YYYY-MM-DD).
+// seconds follows metric; idle/active and focused/unfocused splits are always reported.
+func (db *Database) GetSummaryBetween(startDate, endDate string, metric Metric) ([]SummaryItem, error) {
 	items := []SummaryItem{}
-	q := `
-	WITH base AS (
-	  SELECT a.*, substr(a.start_time,1,10) AS sdate
-	  FROM activities a
-	)
-	SELECT COALESCE(r.display_name, b.process_name) AS name,
-	       SUM(b.duration) as seconds
+	q := gameBase + `
+	SELECT b.game_id AS game_id, b.game_name AS name,
+	       SUM(` + durationExpr(metric) + `) as seconds,
+	       SUM(COALESCE(b.idle_seconds, 0)) as idle_seconds,
+	       SUM(b.duration - COALESCE(b.idle_seconds, 0)) as active_seconds,
+	       SUM(COALESCE(b.unfocused_seconds, 0)) as unfocused_seconds,
+	       SUM(b.duration - COALESCE(b.unfocused_seconds, 0)) as focused_seconds
 	FROM base b
-	LEFT JOIN rename_map r ON r.original_name = b.process_name
 	WHERE b.sdate >= ? AND b.sdate <= ?
-	  AND NOT EXISTS (
-	    SELECT 1 FROM blacklist bx
-	    WHERE bx.name = b.process_name OR bx.name = COALESCE(r.display_name, b.process_name)
-	  )
-	GROUP BY COALESCE(r.display_name, b.process_name)
+	  AND NOT b.game_blacklisted
+	GROUP BY b.game_id
 	ORDER BY seconds DESC`
 	err := db.Select(&items, q, startDate, endDate)
 	return items, err
@@ -65,33 +109,24 @@ func (db *Database) GetSummaryBetween(startDate, endDate string) ([]SummaryItem,
 func (db *Database) GetHistory(hideBlacklisted bool) ([]SessionItem, error) {
 	items := []SessionItem{}
 	// base query selecting flags
-	q := `
-	WITH base AS (
-	  SELECT a.*, substr(a.start_time,1,10) AS sdate
-	  FROM activities a
-	)
+	q := gameBase + `
 	SELECT
-	  COALESCE(r.display_name, b.process_name) AS name,
+	  b.game_id AS game_id,
+	  b.game_name AS name,
 	  b.process_name AS original,
 	  b.sdate AS date,
 	  b.start_time AS start_time,
 	  b.end_time AS end_time,
 	  b.duration AS duration,
-	  CASE WHEN fg.name IS NOT NULL THEN 1 ELSE 0 END AS finished,
-	  CASE WHEN bl1.name IS NOT NULL OR bl2.name IS NOT NULL THEN 1 ELSE 0 END AS blacklisted
+	  COALESCE(b.start_source, '') AS start_source,
+	  COALESCE(b.window_title, '') AS window_title,
+	  CASE WHEN b.game_finished_at IS NOT NULL THEN 1 ELSE 0 END AS finished,
+	  CASE WHEN b.game_blacklisted THEN 1 ELSE 0 END AS blacklisted
 	FROM base b
-	LEFT JOIN rename_map r ON r.original_name = b.process_name
-	LEFT JOIN finished_games fg ON fg.name = COALESCE(r.display_name, b.process_name)
-	LEFT JOIN blacklist bl1 ON bl1.name = b.process_name
-	LEFT JOIN blacklist bl2 ON bl2.name = COALESCE(r.display_name, b.process_name)
 	`
 	if hideBlacklisted {
-		// Exclude rows that are blacklisted either by original or display name.
 		q += `
-	WHERE NOT EXISTS (
-	  SELECT 1 FROM blacklist bx 
-	  WHERE bx.name = b.process_name OR bx.name = COALESCE(r.display_name, b.process_name)
-	)`
+	WHERE NOT b.game_blacklisted`
 	}
 	q += `
 	ORDER BY b.start_time ASC`
@@ -99,7 +134,7 @@ func (db *Database) GetHistory(hideBlacklisted bool) ([]SessionItem, error) {
 		return nil, err
 	}
 
-	// Merge contiguous segments for the same display name when they are back-to-back in time
+	// Merge contiguous segments for the same game when they are back-to-back in time
 	if len(items) == 0 {
 		return items, nil
 	}
@@ -108,10 +143,10 @@ func (db *Database) GetHistory(hideBlacklisted bool) ([]SessionItem, error) {
 	cur := items[0]
 	for i := 1; i < len(items); i++ {
 		next := items[i]
-		// Only merge if same display name and same blacklist visibility status
-		if cur.Name == next.Name && cur.Blacklisted == next.Blacklisted {
-			// Check exact continuity: cur.End == next.Start
-			if cur.End == next.Start {
+		// Only merge if same game and same blacklist visibility status
+		if cur.GameID == next.GameID && cur.Blacklisted == next.Blacklisted {
+			// Check continuity: cur.End == next.Start, or a gap under the merge gap
+			if cur.End == next.Start || db.withinMergeGap(cur.End, next.Start) {
 				// Extend current segment
 				cur.End = next.End
 				cur.Seconds += next.Seconds
@@ -136,128 +171,83 @@ func (db *Database) GetHistory(hideBlacklisted bool) ([]SessionItem, error) {
 	return merged, nil
 }
 
-// UpsertRename sets the display name for an original process_name
-func (db *Database) UpsertRename(original, display string) error {
-	_, err := db.Exec(`INSERT INTO rename_map (original_name, display_name) VALUES (?, ?) 
-	ON CONFLICT(original_name) DO UPDATE SET display_name=excluded.display_name`, original, display)
-	return err
-}
-
-// RenameSmart supports renaming when `from` is either an original_name or an existing display_name.
-// - If there are rows having display_name = from, we update them to display_name = to.
-// - Otherwise, we upsert a mapping original_name = from -> display_name = to.
-func (db *Database) RenameSmart(from, to string) error {
-	res, err := db.Exec(`UPDATE rename_map SET display_name = ? WHERE display_name = ?`, to, from)
-	if err != nil { return err }
-	if res != nil {
-		if n, _ := res.RowsAffected(); n > 0 { return nil }
+// withinMergeGap tells whether a session starting at next follows one ending
+// at end by less than the merge gap
+func (db *Database) withinMergeGap(end, next string) bool {
+	gap := db.SessionRules().MergeGap
+	if gap <= 0 {
+		return false
 	}
-	return db.UpsertRename(from, to)
-}
-
-// GetOriginalsForDisplay returns original process names mapped to a given display name
-func (db *Database) GetOriginalsForDisplay(display string) ([]string, error) {
-	var names []string
-	err := db.Select(&names, `SELECT original_name FROM rename_map WHERE display_name = ?`, display)
-	return names, err
+	e, err1 := time.Parse(time.RFC3339, end)
+	n, err2 := time.Parse(time.RFC3339, next)
+	return err1 == nil && err2 == nil && !n.Before(e) && n.Sub(e) < gap
 }
 
 // SeriesRow is a single bucketed record used for bar chart
 type SeriesRow struct {
 	Bucket  string  `db:"bucket" json:"bucket"`
+	GameID  int64   `db:"game_id" json:"game_id"`
 	Name    string  `db:"name" json:"name"`
 	Seconds float64 `db:"seconds" json:"seconds"`
 }
 
 // GetSeries returns bucketed rows between start and end.
 // period determines bucket granularity: for "year", use monthly (YYYY-MM) or weekly (YYYY-MM-DD Monday) depending on by; otherwise by day (YYYY-MM-DD).
-func (db *Database) GetSeries(period, startDate, endDate, by string) ([]SeriesRow, error) {
+// metric selects which part of the sessions is counted.
+func (db *Database) GetSeries(period, startDate, endDate, by string, metric Metric) ([]SeriesRow, error) {
 	rows := []SeriesRow{}
-	var q string
+	bucket := "b.sdate"
 	if period == "year" {
 		if by == "week" {
-			q = `
-			WITH base AS (
-			  SELECT a.*, substr(a.start_time,1,10) AS sdate
-			  FROM activities a
-			)
-			SELECT date(b.sdate,'weekday 1','-7 days') AS bucket,
-			       COALESCE(r.display_name, b.process_name) AS name,
-			       SUM(b.duration) AS seconds
-			FROM base b
-			LEFT JOIN rename_map r ON r.original_name = b.process_name
-			WHERE b.sdate >= ? AND b.sdate <= ?
-			  AND NOT EXISTS (
-			    SELECT 1 FROM blacklist bx
-			    WHERE bx.name = b.process_name OR bx.name = COALESCE(r.display_name, b.process_name)
-			  )
-			GROUP BY date(b.sdate,'weekday 1','-7 days'), COALESCE(r.display_name, b.process_name)
-			ORDER BY bucket`
+			bucket = "date(b.sdate,'weekday 1','-7 days')"
 		} else {
-			q = `
-			WITH base AS (
-			  SELECT a.*, substr(a.start_time,1,10) AS sdate
-			  FROM activities a
-			)
-			SELECT substr(b.sdate,1,7) AS bucket,
-			       COALESCE(r.display_name, b.process_name) AS name,
-			       SUM(b.duration) AS seconds
-			FROM base b
-			LEFT JOIN rename_map r ON r.original_name = b.process_name
-			WHERE b.sdate >= ? AND b.sdate <= ?
-			  AND NOT EXISTS (
-			    SELECT 1 FROM blacklist bx
-			    WHERE bx.name = b.process_name OR bx.name = COALESCE(r.display_name, b.process_name)
-			  )
-			GROUP BY substr(b.sdate,1,7), COALESCE(r.display_name, b.process_name)
-			ORDER BY bucket`
+			bucket = "substr(b.sdate,1,7)"
 		}
-	} else {
-		q = `
-		WITH base AS (
-		  SELECT a.*, substr(a.start_time,1,10) AS sdate
-		  FROM activities a
-		)
-		SELECT b.sdate AS bucket,
-		       COALESCE(r.display_name, b.process_name) AS name,
-		       SUM(b.duration) AS seconds
-		FROM base b
-		LEFT JOIN rename_map r ON r.original_name = b.process_name
-		WHERE b.sdate >= ? AND b.sdate <= ?
-		  AND NOT EXISTS (
-		    SELECT 1 FROM blacklist bx
-		    WHERE bx.name = b.process_name OR bx.name = COALESCE(r.display_name, b.process_name)
-		  )
-		GROUP BY b.sdate, COALESCE(r.display_name, b.process_name)
-		ORDER BY bucket`
 	}
+	q := gameBase + `
+	SELECT ` + bucket + ` AS bucket,
+	       b.game_id AS game_id,
+	       b.game_name AS name,
+	       SUM(` + durationExpr(metric) + `) AS seconds
+	FROM base b
+	WHERE b.sdate >= ? AND b.sdate <= ?
+	  AND NOT b.game_blacklisted
+	GROUP BY ` + bucket + `, b.game_id
+	ORDER BY bucket`
 	if err := db.Select(&rows, q, startDate, endDate); err != nil {
 		return nil, fmt.Errorf("GetSeries: %w", err)
 	}
 	return rows, nil
 }
 
-// GetAllKnownProcesses returns distinct display names seen in activities with flags and session counts
+// GetAllKnownProcesses returns the games seen in activities with flags and session counts
 func (db *Database) GetAllKnownProcesses() ([]KnownProc, error) {
 	rows := []KnownProc{}
-	q := `
+	q := gameBase + `
 	SELECT
-	  COALESCE(r.display_name, a.process_name) AS name,
-	  MIN(a.process_name) AS original,
+	  b.game_id AS game_id,
+	  b.game_name AS name,
+	  MIN(b.process_name) AS original,
 	  COUNT(*) AS sessions,
-	  CASE WHEN wl1.name IS NOT NULL OR wl2.name IS NOT NULL THEN 1 ELSE 0 END AS whitelisted,
-	  CASE WHEN bl1.name IS NOT NULL OR bl2.name IS NOT NULL THEN 1 ELSE 0 END AS blacklisted
-	FROM activities a
-	LEFT JOIN rename_map r ON r.original_name = a.process_name
-	LEFT JOIN whitelist wl1 ON wl1.name = a.process_name
-	LEFT JOIN whitelist wl2 ON wl2.name = COALESCE(r.display_name, a.process_name)
-	LEFT JOIN blacklist bl1 ON bl1.name = a.process_name
-	LEFT JOIN blacklist bl2 ON bl2.name = COALESCE(r.display_name, a.process_name)
-	GROUP BY COALESCE(r.display_name, a.process_name)
+	  CASE WHEN EXISTS (
+	    SELECT 1 FROM whitelist w
+	    WHERE w.name = b.game_name
+	       OR w.name IN (SELECT process_name FROM game_executables WHERE game_id = b.game_id)
+	  ) THEN 1 ELSE 0 END AS whitelisted,
+	  CASE WHEN b.game_blacklisted THEN 1 ELSE 0 END AS blacklisted
+	FROM base b
+	GROUP BY b.game_id
 	ORDER BY name COLLATE NOCASE`
 	if err := db.Select(&rows, q); err != nil {
 		return nil, fmt.Errorf("GetAllKnownProcesses: %w", err)
 	}
+	ids, err := db.GetStoreIDsByGame()
+	if err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		rows[i].StoreIDs = ids[rows[i].GameID]
+	}
 	return rows, nil
 }
 
@@ -282,34 +272,23 @@ func PeriodRange(period string, now time.Time) (string, string) {
 // GetGamesMetaBetween returns list of games played in [start,end] with flags
 func (db *Database) GetGamesMetaBetween(startDate, endDate string) ([]GameMeta, error) {
 	rows := []GameMeta{}
-	q := `
-	WITH base AS (
-	    SELECT a.*, substr(a.start_time,1,10) AS sdate
-	    FROM activities a
-	), games_in_period AS (
-	    SELECT DISTINCT COALESCE(r.display_name, b.process_name) AS name
+	q := gameBase + `, games_in_period AS (
+	    SELECT DISTINCT b.game_id
 	    FROM base b
-	    LEFT JOIN rename_map r ON r.original_name = b.process_name
 	    WHERE b.sdate >= ? AND b.sdate <= ?
-	      AND NOT EXISTS (
-	        SELECT 1 FROM blacklist bx
-	        WHERE bx.name = b.process_name OR bx.name = COALESCE(r.display_name, b.process_name)
-	      )
+	      AND NOT b.game_blacklisted
 	), first_ever AS (
-	    SELECT COALESCE(r.display_name, b.process_name) AS name,
-	           MIN(b.sdate) AS first_date
+	    SELECT b.game_id, MIN(b.sdate) AS first_date
 	    FROM base b
-	    LEFT JOIN rename_map r ON r.original_name = b.process_name
-	    GROUP BY COALESCE(r.display_name, b.process_name)
+	    GROUP BY b.game_id
 	)
-	SELECT gip.name AS name,
-	       CASE WHEN COALESCE(ov.first_date, fe.first_date) >= ? AND COALESCE(ov.first_date, fe.first_date) <= ? THEN 1 ELSE 0 END AS is_new,
-	       CASE WHEN fg.finished_at IS NOT NULL AND fg.finished_at >= ? AND fg.finished_at <= ? THEN 1 ELSE 0 END AS finished_in_period
+	SELECT g.id AS game_id, g.name AS name,
+	       CASE WHEN COALESCE(g.first_date, fe.first_date) >= ? AND COALESCE(g.first_date, fe.first_date) <= ? THEN 1 ELSE 0 END AS is_new,
+	       CASE WHEN g.finished_at IS NOT NULL AND g.finished_at >= ? AND g.finished_at <= ? THEN 1 ELSE 0 END AS finished_in_period
 	FROM games_in_period gip
-	LEFT JOIN first_ever fe ON fe.name = gip.name
-	LEFT JOIN first_launch_override ov ON ov.name = gip.name
-	LEFT JOIN finished_games fg ON fg.name = gip.name
-	ORDER BY gip.name COLLATE NOCASE
+	JOIN games g ON g.id = gip.game_id
+	LEFT JOIN first_ever fe ON fe.game_id = gip.game_id
+	ORDER BY g.name COLLATE NOCASE
 	`
 	if err := db.Select(&rows, q, startDate, endDate, startDate, endDate, startDate, endDate); err != nil {
 		return nil, fmt.Errorf("GetGamesMetaBetween: %w", err)
@@ -328,44 +307,32 @@ type CalendarDay struct {
 
 // GetCalendarDays returns, for each day in [startDate,endDate],
 // the total seconds played (excluding blacklisted) and CSV lists of
-// display names that are first played that day (new) and games finished that day.
+// games that are first played that day (new) and games finished that day.
 func (db *Database) GetCalendarDays(startDate, endDate string) ([]CalendarDay, error) {
 	rows := []CalendarDay{}
-	q := `
-	WITH base AS (
-	    SELECT a.*, substr(a.start_time,1,10) AS sdate
-	    FROM activities a
-	), daily AS (
+	q := gameBase + `, daily AS (
 	    SELECT b.sdate AS day, SUM(b.duration) AS seconds
 	    FROM base b
-	    LEFT JOIN rename_map r ON r.original_name = b.process_name
 	    WHERE b.sdate >= ? AND b.sdate <= ?
-	      AND NOT EXISTS (
-	        SELECT 1 FROM blacklist bx
-	        WHERE bx.name = b.process_name OR bx.name = COALESCE(r.display_name, b.process_name)
-	      )
+	      AND NOT b.game_blacklisted
 	    GROUP BY b.sdate
 	), first_ever AS (
-	    SELECT COALESCE(r.display_name, b.process_name) AS name,
-	           MIN(b.sdate) AS first_date
+	    SELECT b.game_id, MIN(b.sdate) AS first_date
 	    FROM base b
-	    LEFT JOIN rename_map r ON r.original_name = b.process_name
-	    GROUP BY COALESCE(r.display_name, b.process_name)
+	    GROUP BY b.game_id
 	), newd AS (
-	    SELECT COALESCE(ov.first_date, fe.first_date) AS day,
-	           GROUP_CONCAT(fe.name, '||') AS new_csv
+	    SELECT COALESCE(g.first_date, fe.first_date) AS day,
+	           GROUP_CONCAT(g.name, '||') AS new_csv
 	    FROM first_ever fe
-	    LEFT JOIN first_launch_override ov ON ov.name = fe.name
-	    LEFT JOIN blacklist bl ON bl.name = fe.name
-	    WHERE COALESCE(ov.first_date, fe.first_date) >= ? AND COALESCE(ov.first_date, fe.first_date) <= ? AND bl.name IS NULL
-	    GROUP BY COALESCE(ov.first_date, fe.first_date)
+	    JOIN games g ON g.id = fe.game_id
+	    WHERE COALESCE(g.first_date, fe.first_date) >= ? AND COALESCE(g.first_date, fe.first_date) <= ? AND NOT g.blacklisted
+	    GROUP BY COALESCE(g.first_date, fe.first_date)
 	), fin AS (
-	    SELECT fg.finished_at AS day,
-	           GROUP_CONCAT(fg.name, '||') AS finished_csv
-	    FROM finished_games fg
-	    LEFT JOIN blacklist bl ON bl.name = fg.name
-	    WHERE fg.finished_at >= ? AND fg.finished_at <= ? AND bl.name IS NULL
-	    GROUP BY fg.finished_at
+	    SELECT g.finished_at AS day,
+	           GROUP_CONCAT(g.name, '||') AS finished_csv
+	    FROM games g
+	    WHERE g.finished_at >= ? AND g.finished_at <= ? AND NOT g.blacklisted
+	    GROUP BY g.finished_at
 	), days AS (
 	    SELECT day FROM daily
 	    UNION
@@ -390,33 +357,37 @@ func (db *Database) GetCalendarDays(startDate, endDate string) ([]CalendarDay, e
 
 // DayIntervalRow represents raw activity intervals for a specific date with display names
 type DayIntervalRow struct {
+	GameID    int64  `db:"game_id" json:"game_id"`
 	Name      string `db:"name" json:"name"`
 	StartTime string `db:"start_time" json:"start_time"`
 	EndTime   string `db:"end_time" json:"end_time"`
+	// Seconds is the played time, shorter than the interval for sessions
+	// merged over a gap
+	Seconds float64 `db:"seconds" json:"seconds"`
 }
 
 // GetIntervalsForDate returns all activity intervals for the given date (by activities.date),
-// with display names applied and excluding blacklisted items. Intervals will be clipped by the caller if needed.
+// with game names applied and excluding blacklisted games. Intervals will be clipped by the caller if needed.
 func (db *Database) GetIntervalsForDate(date string) ([]DayIntervalRow, error) {
+	return db.GetIntervalsBetween(date, date)
+}
+
+// GetIntervalsBetween returns the activity intervals of the days between
+// startDate and endDate included, like GetIntervalsForDate
+func (db *Database) GetIntervalsBetween(startDate, endDate string) ([]DayIntervalRow, error) {
 	rows := []DayIntervalRow{}
-	q := `
-	WITH base AS (
-	  SELECT a.*, substr(a.start_time,1,10) AS sdate
-	  FROM activities a
-	)
-	SELECT COALESCE(r.display_name, b.process_name) AS name,
+	q := gameBase + `
+	SELECT b.game_id AS game_id,
+	       b.game_name AS name,
 	       b.start_time AS start_time,
-	       b.end_time AS end_time
+	       b.end_time AS end_time,
+	       b.duration AS seconds
 	FROM base b
-	LEFT JOIN rename_map r ON r.original_name = b.process_name
-	WHERE b.sdate = ?
-	  AND NOT EXISTS (
-	    SELECT 1 FROM blacklist bx
-	    WHERE bx.name = b.process_name OR bx.name = COALESCE(r.display_name, b.process_name)
-	  )
+	WHERE b.sdate >= ? AND b.sdate <= ?
+	  AND NOT b.game_blacklisted
 	ORDER BY b.start_time`
-	if err := db.Select(&rows, q, date); err != nil {
-		return nil, fmt.Errorf("GetIntervalsForDate: %w", err)
+	if err := db.Select(&rows, q, startDate, endDate); err != nil {
+		return nil, fmt.Errorf("GetIntervalsBetween: %w", err)
 	}
 	return rows, nil
 }
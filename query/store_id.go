@@ -0,0 +1,60 @@
+package query
+
+import "fmt"
+
+// operations for store identifiers (Steam AppID, GOG product id...) of games
+
+// Stores with identifiers
+const (
+	StoreSteam = "steam"
+	StoreGOG   = "gog"
+	StoreEpic  = "epic"
+)
+
+// StoreID links a game to its id in a store
+type StoreID struct {
+	GameID  int64  `db:"game_id" json:"game_id,omitempty"`
+	Name    string `db:"name" json:"name"`
+	Store   string `db:"store" json:"store"`
+	StoreID string `db:"store_id" json:"store_id"`
+}
+
+// UpsertStoreID sets the id of a game in a store
+func (db *Database) UpsertStoreID(gameID int64, store, storeID string) error {
+	_, err := db.Exec(`INSERT INTO store_ids (game_id, store, store_id) VALUES (?, ?, ?)
+	ON CONFLICT(game_id, store) DO UPDATE SET store_id=excluded.store_id`, gameID, store, storeID)
+	return err
+}
+
+func (db *Database) DeleteStoreID(gameID int64, store string) error {
+	_, err := db.Exec(`DELETE FROM store_ids WHERE game_id = ? AND store = ?`, gameID, store)
+	return err
+}
+
+// GetAllStoreIDs returns every store id with the name of its game
+func (db *Database) GetAllStoreIDs() ([]StoreID, error) {
+	rows := []StoreID{}
+	q := `SELECT s.game_id, g.name, s.store, s.store_id
+	FROM store_ids s JOIN games g ON g.id = s.game_id
+	ORDER BY g.name, s.store`
+	if err := db.Select(&rows, q); err != nil {
+		return nil, fmt.Errorf("GetAllStoreIDs: %w", err)
+	}
+	return rows, nil
+}
+
+// GetStoreIDsByGame returns the store ids per game id
+func (db *Database) GetStoreIDsByGame() (map[int64]map[string]string, error) {
+	rows, err := db.GetAllStoreIDs()
+	if err != nil {
+		return nil, err
+	}
+	out := map[int64]map[string]string{}
+	for _, r := range rows {
+		if out[r.GameID] == nil {
+			out[r.GameID] = map[string]string{}
+		}
+		out[r.GameID][r.Store] = r.StoreID
+	}
+	return out, nil
+}
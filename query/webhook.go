@@ -0,0 +1,247 @@
+package query
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// operations for webhooks: the URLs notified of the sessions and the log of
+// the deliveries to them
+
+// Delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// EventList lists the events of a webhook, stored as "a,b"
+type EventList []string
+
+// Value implements driver.Valuer
+func (l EventList) Value() (driver.Value, error) {
+	return strings.Join(l, ","), nil
+}
+
+// Scan implements sql.Scanner
+func (l *EventList) Scan(src any) error {
+	var s string
+	switch v := src.(type) {
+	case nil:
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("EventList: unsupported type %T", src)
+	}
+	*l = EventList{}
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			*l = append(*l, e)
+		}
+	}
+	return nil
+}
+
+// Webhook is a URL receiving the events of the tracker
+type Webhook struct {
+	ID  int64  `db:"id" json:"id"`
+	URL string `db:"url" json:"url"`
+	// Secret signs the payloads, empty to send them unsigned. It is never
+	// sent back: HasSecret tells whether there is one.
+	Secret    string `db:"secret" json:"-"`
+	HasSecret bool   `db:"has_secret" json:"has_secret"`
+	// Events are the events sent, empty for all of them
+	Events  EventList `db:"events" json:"events"`
+	Enabled bool      `db:"enabled" json:"enabled"`
+}
+
+// ErrWebhookNotFound is returned for an unknown webhook id
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// Validate normalizes the webhook and checks its URL and events, known
+// listing the events that can be sent
+func (w *Webhook) Validate(known []string) error {
+	w.URL = strings.TrimSpace(w.URL)
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url %q, expected http(s)://host/path", w.URL)
+	}
+	for i, e := range w.Events {
+		w.Events[i] = strings.TrimSpace(e)
+		if !slices.Contains(known, w.Events[i]) {
+			return fmt.Errorf("unknown event %q", e)
+		}
+	}
+	slices.Sort(w.Events)
+	w.Events = slices.Compact(w.Events)
+	if w.Events == nil {
+		w.Events = EventList{}
+	}
+	return nil
+}
+
+// Wants tells whether the webhook receives the event
+func (w Webhook) Wants(event string) bool {
+	return w.Enabled && (len(w.Events) == 0 || slices.Contains(w.Events, event))
+}
+
+// Delivery is a payload sent, or to be sent, to a webhook
+type Delivery struct {
+	ID        int64  `db:"id" json:"id"`
+	WebhookID int64  `db:"webhook_id" json:"webhook_id"`
+	Event     string `db:"event" json:"event"`
+	Payload   string `db:"payload" json:"payload"`
+	CreatedAt string `db:"created_at" json:"created_at"`
+	// State is DeliveryPending, DeliveryDelivered or DeliveryFailed
+	State    string `db:"state" json:"state"`
+	Attempts int    `db:"attempts" json:"attempts"`
+	// NextAttemptAt is when a pending delivery is tried again
+	NextAttemptAt string `db:"next_attempt_at" json:"next_attempt_at,omitempty"`
+	// StatusCode and Error are the outcome of the last attempt
+	StatusCode  int    `db:"status_code" json:"status_code"`
+	Error       string `db:"error" json:"error"`
+	DeliveredAt string `db:"delivered_at" json:"delivered_at,omitempty"`
+}
+
+const webhookColumns = `id, url, secret, secret <> '' AS has_secret, events, enabled FROM webhooks`
+
+// GetWebhooks returns every webhook
+func (db *Database) GetWebhooks() ([]Webhook, error) {
+	hooks := []Webhook{}
+	err := db.Select(&hooks, `SELECT `+webhookColumns+` ORDER BY id`)
+	return hooks, err
+}
+
+// GetWebhook returns the webhook id
+func (db *Database) GetWebhook(id int64) (Webhook, error) {
+	var hooks []Webhook
+	if err := db.Select(&hooks, `SELECT `+webhookColumns+` WHERE id = ?`, id); err != nil {
+		return Webhook{}, err
+	}
+	if len(hooks) == 0 {
+		return Webhook{}, ErrWebhookNotFound
+	}
+	return hooks[0], nil
+}
+
+// SaveWebhook validates w against the known events and inserts it, or updates
+// it when w.ID is set. An update with an empty secret keeps the stored one.
+func (db *Database) SaveWebhook(w Webhook, known []string) (Webhook, error) {
+	if err := w.Validate(known); err != nil {
+		return Webhook{}, err
+	}
+	if w.ID == 0 {
+		res, err := db.Exec(`INSERT INTO webhooks (url, secret, events, enabled) VALUES (?, ?, ?, ?)`, w.URL, w.Secret, w.Events, w.Enabled)
+		if err != nil {
+			return Webhook{}, fmt.Errorf("SaveWebhook: %w", err)
+		}
+		if w.ID, err = res.LastInsertId(); err != nil {
+			return Webhook{}, fmt.Errorf("SaveWebhook: %w", err)
+		}
+		w.HasSecret = w.Secret != ""
+		return w, nil
+	}
+	res, err := db.Exec(`UPDATE webhooks SET url = ?, secret = CASE WHEN ? = '' THEN secret ELSE ? END, events = ?, enabled = ? WHERE id = ?`,
+		w.URL, w.Secret, w.Secret, w.Events, w.Enabled, w.ID)
+	if err != nil {
+		return Webhook{}, fmt.Errorf("SaveWebhook: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Webhook{}, ErrWebhookNotFound
+	}
+	return db.GetWebhook(w.ID)
+}
+
+// DeleteWebhook removes the webhook id, its pending deliveries being dropped
+// with it
+func (db *Database) DeleteWebhook(id int64) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM webhooks WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("DeleteWebhook: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE webhook_deliveries SET state = ?, next_attempt_at = NULL, error = 'webhook deleted' WHERE webhook_id = ? AND state = ?`,
+		DeliveryFailed, id, DeliveryPending); err != nil {
+		return fmt.Errorf("DeleteWebhook: %w", err)
+	}
+	return tx.Commit()
+}
+
+// InsertDelivery queues a payload for the webhook of d, to be sent right away
+func (db *Database) InsertDelivery(d Delivery) (int64, error) {
+	res, err := db.Exec(`INSERT INTO webhook_deliveries (webhook_id, event, payload, created_at, state, next_attempt_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		d.WebhookID, d.Event, d.Payload, d.CreatedAt, DeliveryPending, d.CreatedAt)
+	if err != nil {
+		return 0, fmt.Errorf("InsertDelivery: %w", err)
+	}
+	return res.LastInsertId()
+}
+
+// RecordAttempt stores the outcome of an attempt to send the delivery d: its
+// state, attempts, status code and error. next is when it is tried again,
+// zero once it is over.
+func (db *Database) RecordAttempt(d Delivery, next time.Time) error {
+	var nextAt, deliveredAt any
+	if !next.IsZero() {
+		nextAt = next.UTC().Format(time.RFC3339)
+	}
+	if d.DeliveredAt != "" {
+		deliveredAt = d.DeliveredAt
+	}
+	_, err := db.Exec(`UPDATE webhook_deliveries SET state = ?, attempts = ?, next_attempt_at = ?, status_code = ?, error = ?, delivered_at = ? WHERE id = ?`,
+		d.State, d.Attempts, nextAt, d.StatusCode, d.Error, deliveredAt, d.ID)
+	return err
+}
+
+const deliveryColumns = `id, webhook_id, event, payload, created_at, state, attempts,
+	COALESCE(next_attempt_at, '') AS next_attempt_at, status_code, error, COALESCE(delivered_at, '') AS delivered_at
+	FROM webhook_deliveries`
+
+// GetDueDeliveries returns the pending deliveries to try again at now, the
+// oldest first
+func (db *Database) GetDueDeliveries(now time.Time) ([]Delivery, error) {
+	out := []Delivery{}
+	err := db.Select(&out, `SELECT `+deliveryColumns+` WHERE state = ? AND next_attempt_at <= ? ORDER BY next_attempt_at, id`,
+		DeliveryPending, now.UTC().Format(time.RFC3339))
+	return out, err
+}
+
+// NextDeliveryAttempt returns when the next pending delivery is due, zero if none
+func (db *Database) NextDeliveryAttempt() (time.Time, error) {
+	var next string
+	err := db.Get(&next, `SELECT COALESCE(MIN(next_attempt_at), '') FROM webhook_deliveries WHERE state = ?`, DeliveryPending)
+	if err != nil || next == "" {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, next)
+}
+
+// GetDeliveries returns the last deliveries, of every webhook when webhookID
+// is 0, the most recent first
+func (db *Database) GetDeliveries(webhookID int64, limit int) ([]Delivery, error) {
+	out := []Delivery{}
+	err := db.Select(&out, `SELECT `+deliveryColumns+` WHERE ? = 0 OR webhook_id = ? ORDER BY id DESC LIMIT ?`, webhookID, webhookID, limit)
+	return out, err
+}
+
+// PruneDeliveries deletes the delivered and failed deliveries created before
+// before, and those past the keep most recent ones. Pending ones are kept.
+func (db *Database) PruneDeliveries(before time.Time, keep int) (int64, error) {
+	res, err := db.Exec(`DELETE FROM webhook_deliveries WHERE state <> ? AND (created_at < ? OR id NOT IN
+		(SELECT id FROM webhook_deliveries WHERE state <> ? ORDER BY id DESC LIMIT ?))`,
+		DeliveryPending, before.UTC().Format(time.RFC3339), DeliveryPending, keep)
+	if err != nil {
+		return 0, fmt.Errorf("PruneDeliveries: %w", err)
+	}
+	return res.RowsAffected()
+}
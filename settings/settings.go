@@ -0,0 +1,385 @@
+package settings
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"main/datadir"
+)
+
+// Settings are the tunables of the application. Each one comes, by increasing
+// precedence, from its default, the settings table, the config file and the
+// WGT_<KEY> environment variable (e.g. WGT_LISTEN_ADDR).
+type Settings struct {
+	// ListenAddr is the address of the web UI, applied on restart
+	ListenAddr string `json:"listen_addr"`
+	// DataDir is the folder of the database, empty for the default one. It
+	// can't be stored in the database, only in the config file or environment.
+	DataDir string `json:"data_dir"`
+	// ScanIntervalMs is the delay between two scans of the process table
+	ScanIntervalMs int `json:"scan_interval_ms"`
+	// ExitIntervalMs is the delay between two liveness checks of tracked processes
+	ExitIntervalMs int `json:"exit_interval_ms"`
+	// HeartbeatSeconds is the delay between two checkpoints of running sessions
+	HeartbeatSeconds int `json:"heartbeat_seconds"`
+	// StartPolicy applies to games already running at startup: create_time or tracker_start
+	StartPolicy string `json:"start_policy"`
+	// IdleMinutes is the inactivity after which time is counted as idle, 0 disables it
+	IdleMinutes int `json:"idle_minutes"`
+	// MinSessionSeconds discards shorter sessions, 0 keeps them all
+	MinSessionSeconds int `json:"min_session_seconds"`
+	// MergeGapMinutes merges the sessions of a game closer than it, 0 disables it
+	MergeGapMinutes int `json:"merge_gap_minutes"`
+	// Timezone is the IANA zone used to display times, empty for the system one
+	Timezone string `json:"timezone"`
+	// BackupIntervalHours is the delay between two automatic backups, 0 disables them
+	BackupIntervalHours int `json:"backup_interval_hours"`
+	// BackupKeepDaily, BackupKeepWeekly and BackupKeepMonthly are the number of
+	// days, weeks and months whose last automatic backup is kept
+	BackupKeepDaily   int `json:"backup_keep_daily"`
+	BackupKeepWeekly  int `json:"backup_keep_weekly"`
+	BackupKeepMonthly int `json:"backup_keep_monthly"`
+	// NotifySessions, NotifyLimits, NotifyMilestones and NotifyBackups enable
+	// the desktop notifications of the sessions, the limits and curfews, the
+	// play-time milestones and the failed backups
+	NotifySessions   bool `json:"notify_sessions"`
+	NotifyLimits     bool `json:"notify_limits"`
+	NotifyMilestones bool `json:"notify_milestones"`
+	NotifyBackups    bool `json:"notify_backups"`
+}
+
+// Defaults returns the settings used when nothing overrides them
+func Defaults() Settings {
+	return Settings{
+		ListenAddr:       "127.0.0.1:8080",
+		ScanIntervalMs:   1000,
+		ExitIntervalMs:   500,
+		HeartbeatSeconds: 30,
+		StartPolicy:      "create_time",
+		IdleMinutes:      5,
+
+		BackupIntervalHours: 24,
+		BackupKeepDaily:     7,
+		BackupKeepWeekly:    4,
+		BackupKeepMonthly:   6,
+
+		NotifyLimits:     true,
+		NotifyMilestones: true,
+		NotifyBackups:    true,
+	}
+}
+
+// RestartKeys lists the settings only applied on restart
+var RestartKeys = []string{"listen_addr", "data_dir"}
+
+// fileOnlyKeys can't be stored in the database
+var fileOnlyKeys = map[string]bool{"data_dir": true}
+
+// ConfigFileName is the name of the optional config file, in the default data folder
+const ConfigFileName = "config.json"
+
+// Validate checks every setting
+func (s Settings) Validate() error {
+	if _, _, err := net.SplitHostPort(s.ListenAddr); err != nil {
+		return fmt.Errorf("listen_addr: %w", err)
+	}
+	if s.ScanIntervalMs < 100 {
+		return errors.New("scan_interval_ms: must be at least 100")
+	}
+	if s.ExitIntervalMs < 100 {
+		return errors.New("exit_interval_ms: must be at least 100")
+	}
+	if s.HeartbeatSeconds < 5 {
+		return errors.New("heartbeat_seconds: must be at least 5")
+	}
+	if s.StartPolicy != "create_time" && s.StartPolicy != "tracker_start" {
+		return fmt.Errorf("start_policy: unknown policy %q", s.StartPolicy)
+	}
+	if s.IdleMinutes < 0 || s.MinSessionSeconds < 0 || s.MergeGapMinutes < 0 {
+		return errors.New("idle_minutes, min_session_seconds and merge_gap_minutes can't be negative")
+	}
+	if s.BackupIntervalHours < 0 || s.BackupKeepDaily < 0 || s.BackupKeepWeekly < 0 || s.BackupKeepMonthly < 0 {
+		return errors.New("backup settings can't be negative")
+	}
+	if s.BackupIntervalHours > 0 && s.BackupKeepDaily+s.BackupKeepWeekly+s.BackupKeepMonthly == 0 {
+		return errors.New("backup_keep_daily, backup_keep_weekly or backup_keep_monthly must keep at least one backup")
+	}
+	if s.Timezone != "" {
+		if _, err := time.LoadLocation(s.Timezone); err != nil {
+			return fmt.Errorf("timezone: %w", err)
+		}
+	}
+	return nil
+}
+
+// Store holds the current settings and persists the changes in the settings table
+type Store struct {
+	db         *sqlx.DB
+	configFile string
+
+	mu  sync.RWMutex
+	cur Settings
+	// locked maps the keys set by the config file or the environment to their origin
+	locked    map[string]string
+	listeners []func(Settings)
+}
+
+// Bootstrap reads the settings needed before the database is opened, from the
+// config file (if any) and the environment
+func Bootstrap(configFile string) (Settings, error) {
+	s := Defaults()
+	if _, err := overrides(&s, configFile); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// Load reads the settings from the database, the config file and the
+// environment. An invalid stored value is logged and ignored.
+func Load(db *sqlx.DB, configFile string) (*Store, error) {
+	s, locked, err := read(db, configFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db, configFile: configFile, cur: s, locked: locked}, nil
+}
+
+// Reload reads the settings again, after the settings table was replaced
+func (st *Store) Reload() error {
+	s, locked, err := read(st.db, st.configFile)
+	if err != nil {
+		return err
+	}
+	st.mu.Lock()
+	st.cur, st.locked = s, locked
+	listeners := append([]func(Settings){}, st.listeners...)
+	st.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(s)
+	}
+	return nil
+}
+
+func read(db *sqlx.DB, configFile string) (Settings, map[string]string, error) {
+	s := Defaults()
+	rows := []struct {
+		Key   string `db:"key"`
+		Value string `db:"value"`
+	}{}
+	if err := db.Select(&rows, `SELECT key, value FROM settings`); err != nil {
+		return s, nil, fmt.Errorf("settings.Load: %w", err)
+	}
+	for _, r := range rows {
+		next := s
+		if err := apply(&next, map[string]json.RawMessage{r.Key: json.RawMessage(r.Value)}); err != nil || next.Validate() != nil {
+			fmt.Printf("Réglage %s ignoré: %s\n", r.Key, r.Value)
+			continue
+		}
+		s = next
+	}
+	locked, err := overrides(&s, configFile)
+	if err != nil {
+		return s, nil, err
+	}
+	if err := s.Validate(); err != nil {
+		return s, nil, fmt.Errorf("settings.Load: %w", err)
+	}
+	return s, locked, nil
+}
+
+// Get returns the current settings
+func (st *Store) Get() Settings {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.cur
+}
+
+// Locked returns the keys that can't be changed, with the file or variable setting them
+func (st *Store) Locked() map[string]string {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	out := make(map[string]string, len(st.locked))
+	for k, v := range st.locked {
+		out[k] = v
+	}
+	return out
+}
+
+// OnChange registers fn, called with the new settings after each update
+func (st *Store) OnChange(fn func(Settings)) {
+	st.mu.Lock()
+	st.listeners = append(st.listeners, fn)
+	st.mu.Unlock()
+}
+
+// Update changes the given keys, validates the result and persists it
+func (st *Store) Update(patch map[string]json.RawMessage) (Settings, error) {
+	st.mu.Lock()
+	next := st.cur
+	if err := apply(&next, patch); err != nil {
+		st.mu.Unlock()
+		return Settings{}, err
+	}
+	cur, _ := toMap(st.cur)
+	want, _ := toMap(next)
+	keys := make([]string, 0, len(patch))
+	for k := range patch {
+		if bytes.Equal(cur[k], want[k]) {
+			continue
+		}
+		if origin, ok := st.locked[k]; ok {
+			st.mu.Unlock()
+			return Settings{}, fmt.Errorf("%s is set by %s", k, origin)
+		}
+		if fileOnlyKeys[k] {
+			st.mu.Unlock()
+			return Settings{}, fmt.Errorf("%s can only be set in %s or the environment", k, ConfigFileName)
+		}
+		keys = append(keys, k)
+	}
+	if err := next.Validate(); err != nil {
+		st.mu.Unlock()
+		return Settings{}, err
+	}
+	sort.Strings(keys)
+	tx, err := st.db.Beginx()
+	if err != nil {
+		st.mu.Unlock()
+		return Settings{}, err
+	}
+	for _, k := range keys {
+		_, err := tx.Exec(`INSERT INTO settings (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value=excluded.value`, k, string(want[k]))
+		if err != nil {
+			tx.Rollback()
+			st.mu.Unlock()
+			return Settings{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		st.mu.Unlock()
+		return Settings{}, err
+	}
+	st.cur = next
+	listeners := append([]func(Settings){}, st.listeners...)
+	st.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(next)
+	}
+	return next, nil
+}
+
+// ConfigFile returns the path of the config file: WGT_CONFIG, or else
+// config.json in dir
+func ConfigFile(dir string) string {
+	if p := os.Getenv("WGT_CONFIG"); p != "" {
+		return p
+	}
+	return filepath.Join(dir, ConfigFileName)
+}
+
+// overrides applies the config file then the environment to s, returning the
+// keys they set
+func overrides(s *Settings, configFile string) (map[string]string, error) {
+	locked := map[string]string{}
+	if configFile != "" {
+		data, err := os.ReadFile(configFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("settings: %w", err)
+		}
+		if err == nil {
+			patch := map[string]json.RawMessage{}
+			if err := json.Unmarshal(data, &patch); err != nil {
+				return nil, fmt.Errorf("settings: %s: %w", configFile, err)
+			}
+			if err := apply(s, patch); err != nil {
+				return nil, fmt.Errorf("settings: %s: %w", configFile, err)
+			}
+			for k := range patch {
+				locked[k] = configFile
+			}
+		}
+	}
+	defaults, _ := toMap(*s)
+	patch := map[string]json.RawMessage{}
+	for k, def := range defaults {
+		name := "WGT_" + strings.ToUpper(k)
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		// String settings take the raw value, numbers and booleans are already valid JSON
+		if len(def) > 0 && def[0] == '"' {
+			raw, _ := json.Marshal(v)
+			patch[k] = raw
+		} else {
+			patch[k] = json.RawMessage(v)
+		}
+		locked[k] = name
+	}
+	if err := apply(s, patch); err != nil {
+		return nil, fmt.Errorf("settings: environment: %w", err)
+	}
+	return locked, nil
+}
+
+// apply sets the keys of patch on s, rejecting unknown keys and bad types
+func apply(s *Settings, patch map[string]json.RawMessage) error {
+	known, _ := toMap(*s)
+	for k := range patch {
+		if _, ok := known[k]; !ok {
+			return fmt.Errorf("unknown setting %q", k)
+		}
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return fmt.Errorf("bad setting value: %w", err)
+	}
+	return nil
+}
+
+func toMap(s Settings) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]json.RawMessage{}
+	return m, json.Unmarshal(data, &m)
+}
+
+// ResolveDataDir returns the data folder, where it comes from (--data-dir,
+// WGT_DATA_DIR, data_dir of the config file or the folder of the platform) and
+// the config file read, which stays in the folder where data_dir was found.
+// The folder is created, and the legacy one migrated, if needed.
+func ResolveDataDir(flagDir string) (dir, origin, configFile string, err error) {
+	dir, origin = datadir.Resolve(flagDir)
+	configFile = ConfigFile(dir)
+	boot, err := Bootstrap(configFile)
+	if err != nil {
+		return "", "", "", err
+	}
+	if origin == datadir.SourceDefault && boot.DataDir != "" {
+		dir, origin = boot.DataDir, datadir.SourceConfig
+	}
+	if err := datadir.Prepare(dir, origin); err != nil {
+		return "", "", "", err
+	}
+	return dir, origin, configFile, nil
+}
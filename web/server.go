@@ -3,62 +3,125 @@ package web
 import (
 	"embed"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"main/backup"
+	"main/curfew"
+	"main/discovery"
+	"main/events"
+	"main/limits"
 	"main/manager"
+	"main/monitor"
 	"main/query"
+	"main/settings"
+	"main/webhook"
 )
 
 //go:embed static/*
 var staticFS embed.FS
 
 type Server struct {
-	db *query.Database
-	lm *manager.ListManager
-}
-
-func StartServer(db *query.Database, lm *manager.ListManager) {
-	s := &Server{db: db, lm: lm}
-
-	http.HandleFunc("/", s.handleIndex)
-	http.HandleFunc("/history", s.handleHistoryPage)
-	http.HandleFunc("/config", s.handleConfigPage)
-	http.Handle("/static/", http.FileServer(http.FS(staticFS)))
-
-	http.HandleFunc("/api/summary", s.handleSummary)
-	http.HandleFunc("/api/history", s.handleHistory)
-	http.HandleFunc("/api/blacklist", s.handleBlacklist)
-	http.HandleFunc("/api/unblacklist", s.handleUnblacklist)
-	http.HandleFunc("/api/whitelist", s.handleWhitelist)
-	http.HandleFunc("/api/unwhitelist", s.handleUnwhitelist)
-	http.HandleFunc("/api/known_processes", s.handleKnownProcesses)
-	http.HandleFunc("/api/rename", s.handleRename)
-	http.HandleFunc("/api/finished", s.handleFinished)
-	http.HandleFunc("/api/series", s.handleSeries)
-	http.HandleFunc("/api/games_meta", s.handleGamesMeta)
-	http.HandleFunc("/api/calendar", s.handleCalendar)
-	http.HandleFunc("/api/set_first_launch_date", s.handleSetFirstLaunchDate)
-	http.HandleFunc("/api/set_finished_date", s.handleSetFinishedDate)
+	db       *query.Database
+	lm       *manager.ListManager
+	settings *settings.Store
+	backups  *backup.Manager
+	monitor  *monitor.ProcessMonitor
+	events   *events.Bus
+	limits   *limits.Enforcer
+	curfews  *curfew.Enforcer
+	webhooks *webhook.Sender
+	// closing is closed when the server shuts down, ending the event streams
+	closing chan struct{}
+}
+
+// sseKeepAlive is the delay between two comments keeping an idle event stream open
+const sseKeepAlive = 30 * time.Second
+
+// StartServer serves the web UI in the background on the configured address;
+// the returned server is meant to be stopped with Shutdown
+func StartServer(db *query.Database, lm *manager.ListManager, st *settings.Store, backups *backup.Manager, pm *monitor.ProcessMonitor, bus *events.Bus, enforcer *limits.Enforcer, curfews *curfew.Enforcer, webhooks *webhook.Sender) *http.Server {
+	s := &Server{db: db, lm: lm, settings: st, backups: backups, monitor: pm, events: bus, limits: enforcer, curfews: curfews, webhooks: webhooks, closing: make(chan struct{})}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/history", s.handleHistoryPage)
+	mux.HandleFunc("/config", s.handleConfigPage)
+	mux.Handle("/static/", http.FileServer(http.FS(staticFS)))
+
+	mux.HandleFunc("/api/summary", s.handleSummary)
+	mux.HandleFunc("/api/history", s.handleHistory)
+	mux.HandleFunc("/api/blacklist", s.handleBlacklist)
+	mux.HandleFunc("/api/unblacklist", s.handleUnblacklist)
+	mux.HandleFunc("/api/whitelist", s.handleWhitelist)
+	mux.HandleFunc("/api/unwhitelist", s.handleUnwhitelist)
+	mux.HandleFunc("/api/rules", s.handleRules)
+	mux.HandleFunc("/api/rules_delete", s.handleRulesDelete)
+	mux.HandleFunc("/api/rules_test", s.handleRulesTest)
+	mux.HandleFunc("/api/discovery", s.handleDiscovery)
+	mux.HandleFunc("/api/discovery_accept", s.handleDiscoveryAccept)
+	mux.HandleFunc("/api/known_processes", s.handleKnownProcesses)
+	mux.HandleFunc("/api/store_ids", s.handleStoreIDs)
+	mux.HandleFunc("/api/rename", s.handleRename)
+	mux.HandleFunc("/api/games", s.handleGames)
+	mux.HandleFunc("/api/games_assign", s.handleGamesAssign)
+	mux.HandleFunc("/api/games_merge", s.handleGamesMerge)
+	mux.HandleFunc("/api/repair_overlaps", s.handleRepairOverlaps)
+	mux.HandleFunc("/api/sessions_cleanup", s.handleSessionsCleanup)
+	mux.HandleFunc("/api/finished", s.handleFinished)
+	mux.HandleFunc("/api/series", s.handleSeries)
+	mux.HandleFunc("/api/games_meta", s.handleGamesMeta)
+	mux.HandleFunc("/api/calendar", s.handleCalendar)
+	mux.HandleFunc("/api/set_first_launch_date", s.handleSetFirstLaunchDate)
+	mux.HandleFunc("/api/set_finished_date", s.handleSetFinishedDate)
 	// Export / Import API
-	http.HandleFunc("/api/export", s.handleExport)
-	http.HandleFunc("/api/import", s.handleImport)
+	mux.HandleFunc("/api/export", s.handleExport)
+	mux.HandleFunc("/api/import", s.handleImport)
 	// History delete API
-	http.HandleFunc("/api/history_delete", s.handleHistoryDelete)
+	mux.HandleFunc("/api/history_delete", s.handleHistoryDelete)
 	// Day timeline API
-	http.HandleFunc("/api/day_timeline", s.handleDayTimeline)
+	mux.HandleFunc("/api/day_timeline", s.handleDayTimeline)
+	// Settings API
+	mux.HandleFunc("/api/settings", s.handleSettings)
+	// Backups API
+	mux.HandleFunc("/api/backups", s.handleBackups)
+	mux.HandleFunc("/api/backups_restore", s.handleBackupsRestore)
+
+	mux.HandleFunc("/api/limits", s.handleLimits)
+	mux.HandleFunc("/api/limits_delete", s.handleLimitsDelete)
+	mux.HandleFunc("/api/limits_status", s.handleLimitsStatus)
+
+	mux.HandleFunc("/api/curfews", s.handleCurfews)
+	mux.HandleFunc("/api/curfews_delete", s.handleCurfewsDelete)
+	mux.HandleFunc("/api/curfew_exceptions", s.handleCurfewExceptions)
+	mux.HandleFunc("/api/curfew_exceptions_delete", s.handleCurfewExceptionsDelete)
+	mux.HandleFunc("/api/curfew_violations", s.handleCurfewViolations)
 
+	mux.HandleFunc("/api/webhooks", s.handleWebhooks)
+	mux.HandleFunc("/api/webhooks_delete", s.handleWebhooksDelete)
+	mux.HandleFunc("/api/webhooks/test", s.handleWebhooksTest)
+	mux.HandleFunc("/api/webhooks/deliveries", s.handleWebhookDeliveries)
+
+	mux.HandleFunc("/api/now", s.handleNow)
+	mux.HandleFunc("/api/events", s.handleEvents)
+
+	// The default address binds explicitly to localhost to avoid Windows Firewall prompts
+	srv := &http.Server{Addr: st.Get().ListenAddr, Handler: mux}
+	srv.RegisterOnShutdown(func() { close(s.closing) })
 	go func() {
-		// Bind explicitly to localhost to avoid Windows Firewall prompts
-		addr := "127.0.0.1:8080"
-		log.Printf("Web UI disponible sur http://%v\n", addr)
-		if err := http.ListenAndServe(addr, nil); err != nil {
+		log.Printf("Web UI disponible sur http://%v\n", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Println("Erreur serveur web:", err)
 		}
 	}()
+	return srv
 }
 
 func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
@@ -87,7 +150,7 @@ func (s *Server) handleSummary(w http.ResponseWriter, r *http.Request) {
 	if start == "" || end == "" {
 		start, end = query.PeriodRange(period, time.Now())
 	}
-	items, err := s.db.GetSummaryBetween(start, end)
+	items, err := s.db.GetSummaryBetween(start, end, metricParam(r))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError); return
 	}
@@ -110,31 +173,43 @@ func (s *Server) handleBlacklist(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, names); return
 	}
 	if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
-	type req struct{ Name string `json:"name"` }
+	type req struct{ Name string `json:"name"`; GameID int64 `json:"game_id"` }
 	var body req
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil { http.Error(w, "bad request", http.StatusBadRequest); return }
 	name := strings.TrimSpace(body.Name)
-	if name == "" { http.Error(w, "name empty", http.StatusBadRequest); return }
-	// Try to also blacklist original executable names if this is a display name
-	if err := s.lm.AddToBlacklist(name); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
-	if originals, err := s.db.GetOriginalsForDisplay(name); err == nil {
-		for _, o := range originals { _ = s.lm.AddToBlacklist(o) }
+	if name == "" && body.GameID <= 0 { http.Error(w, "name empty", http.StatusBadRequest); return }
+	// A game is hidden from the stats and its executables are no longer tracked
+	game, err := s.findGame(body.GameID, name)
+	if err != nil && !errors.Is(err, query.ErrGameNotFound) { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	if err == nil {
+		if err := s.db.SetGameBlacklisted(game.ID, true); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+		name = game.Name
+		if exes, err := s.db.GetGameExecutables(game.ID); err == nil {
+			for _, o := range exes { _ = s.lm.AddToBlacklist(o) }
+		}
 	}
+	if err := s.lm.AddToBlacklist(name); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
 	writeJSON(w, map[string]string{"status":"ok"})
 }
 
 func (s *Server) handleUnblacklist(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
-	type req struct{ Name string `json:"name"` }
+	type req struct{ Name string `json:"name"`; GameID int64 `json:"game_id"` }
 	var body req
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil { http.Error(w, "bad request", http.StatusBadRequest); return }
 	name := strings.TrimSpace(body.Name)
-	if name == "" { http.Error(w, "name empty", http.StatusBadRequest); return }
-	// Remove both the provided name and any originals mapped to it (if it is a display name)
-	if err := s.lm.RemoveFromBlacklist(name); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
-	if originals, err := s.db.GetOriginalsForDisplay(name); err == nil {
-		for _, o := range originals { _ = s.lm.RemoveFromBlacklist(o) }
+	if name == "" && body.GameID <= 0 { http.Error(w, "name empty", http.StatusBadRequest); return }
+	// Remove both the provided name and the executables of the game
+	game, err := s.findGame(body.GameID, name)
+	if err != nil && !errors.Is(err, query.ErrGameNotFound) { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	if err == nil {
+		if err := s.db.SetGameBlacklisted(game.ID, false); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+		name = game.Name
+		if exes, err := s.db.GetGameExecutables(game.ID); err == nil {
+			for _, o := range exes { _ = s.lm.RemoveFromBlacklist(o) }
+		}
 	}
+	if err := s.lm.RemoveFromBlacklist(name); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
 	writeJSON(w, map[string]string{"status":"ok"})
 }
 
@@ -165,46 +240,548 @@ func (s *Server) handleUnwhitelist(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, map[string]string{"status":"ok"})
 }
 
+// handleRules lists the typed rules (GET) in evaluation order or adds one (POST)
+func (s *Server) handleRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet { writeJSON(w, s.lm.Rules()); return }
+	if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
+	var body manager.Rule
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil { http.Error(w, "bad request", http.StatusBadRequest); return }
+	body.List = strings.TrimSpace(body.List)
+	body.Kind = strings.TrimSpace(body.Kind)
+	// The game of a launcher rule is given by name, created if needed
+	body.GameID = 0
+	if game := strings.TrimSpace(body.Game); game != "" {
+		if !body.TrackChildren { http.Error(w, "game requires track_children", http.StatusBadRequest); return }
+		id, err := s.db.ResolveGame(game)
+		if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+		body.GameID = id
+	}
+	rule, err := s.lm.AddRule(body)
+	if err != nil { http.Error(w, err.Error(), http.StatusBadRequest); return }
+	writeJSON(w, rule)
+}
+
+func (s *Server) handleRulesDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
+	type req struct{ ID int64 `json:"id"` }
+	var body req
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ID <= 0 { http.Error(w, "bad request", http.StatusBadRequest); return }
+	if err := s.lm.RemoveRule(body.ID); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	writeJSON(w, map[string]string{"status":"ok"})
+}
+
+// handleRulesTest tells which rule decides for a path (?path=...)
+func (s *Server) handleRulesTest(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSpace(r.URL.Query().Get("path"))
+	if path == "" { http.Error(w, "missing path", http.StatusBadRequest); return }
+	rule, ok := s.lm.Match(path)
+	resp := map[string]any{"path": path, "matched": ok, "tracked": ok && rule.List == manager.ListWhitelist}
+	if ok { resp["rule"] = rule }
+	writeJSON(w, resp)
+}
+
+// handleDiscovery lists the games installed by the known launchers, with
+// whether they are already tracked
+func (s *Server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	type item struct {
+		discovery.Candidate
+		Tracked bool `json:"tracked"`
+	}
+	cands, errs := discovery.Discover(discovery.DefaultProviders())
+	items := make([]item, 0, len(cands))
+	for _, c := range cands {
+		it := item{Candidate: c}
+		for _, exe := range c.Executables {
+			if s.lm.IsWhitelisted(exe) { it.Tracked = true; break }
+		}
+		items = append(items, it)
+	}
+	errMsgs := map[string]string{}
+	for name, err := range errs { errMsgs[name] = err.Error() }
+	writeJSON(w, map[string]any{"candidates": items, "errors": errMsgs})
+}
+
+// handleDiscoveryAccept whitelists the install directory of a discovered game
+// and maps its executables to a game of that name, keeping its store id
+func (s *Server) handleDiscoveryAccept(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
+	type req struct {
+		Name        string   `json:"name"`
+		Source      string   `json:"source"`
+		ID          string   `json:"id"`
+		InstallDir  string   `json:"install_dir"`
+		Executables []string `json:"executables"`
+	}
+	var body req
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil { http.Error(w, "bad request", http.StatusBadRequest); return }
+	body.Name = strings.TrimSpace(body.Name)
+	body.InstallDir = strings.TrimSpace(body.InstallDir)
+	if body.InstallDir == "" { http.Error(w, "missing install_dir", http.StatusBadRequest); return }
+	prefix := strings.TrimRight(body.InstallDir, `/\`) + "/"
+	rule, err := s.lm.AddRule(manager.Rule{List: manager.ListWhitelist, Kind: manager.KindPrefix, Pattern: prefix, CaseInsensitive: true})
+	if err != nil { http.Error(w, err.Error(), http.StatusBadRequest); return }
+	if body.Name == "" { writeJSON(w, rule); return }
+	gameID, err := s.db.ResolveGame(body.Name)
+	if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	for _, exe := range body.Executables {
+		base := filepath.Base(strings.ReplaceAll(exe, `\`, "/"))
+		if base == "" || base == "." { continue }
+		if err := s.db.AssignExecutable(base, gameID); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	}
+	if body.ID != "" && (body.Source == query.StoreSteam || body.Source == query.StoreGOG || body.Source == query.StoreEpic) {
+		if err := s.db.UpsertStoreID(gameID, body.Source, body.ID); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	}
+	writeJSON(w, rule)
+}
+
 func (s *Server) handleKnownProcesses(w http.ResponseWriter, r *http.Request) {
 	rows, err := s.db.GetAllKnownProcesses()
 	if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
 	writeJSON(w, rows)
 }
 
+// handleStoreIDs lists the store ids (GET) or sets one by hand (POST), an
+// empty store_id removing it
+func (s *Server) handleStoreIDs(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		rows, err := s.db.GetAllStoreIDs()
+		if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+		writeJSON(w, rows); return
+	}
+	if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
+	var body query.StoreID
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil { http.Error(w, "bad request", http.StatusBadRequest); return }
+	body.Name = strings.TrimSpace(body.Name)
+	body.Store = strings.ToLower(strings.TrimSpace(body.Store))
+	body.StoreID = strings.TrimSpace(body.StoreID)
+	if (body.Name == "" && body.GameID <= 0) || body.Store == "" { http.Error(w, "missing game or store", http.StatusBadRequest); return }
+	game, err := s.findGame(body.GameID, body.Name)
+	if errors.Is(err, query.ErrGameNotFound) { http.Error(w, err.Error(), http.StatusNotFound); return }
+	if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	if body.StoreID == "" { err = s.db.DeleteStoreID(game.ID, body.Store) } else { err = s.db.UpsertStoreID(game.ID, body.Store, body.StoreID) }
+	if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	writeJSON(w, map[string]string{"status":"ok"})
+}
+
 func (s *Server) handleRename(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
-	type req struct{ From string `json:"from"`; To string `json:"to"` }
+	type req struct{ From string `json:"from"`; To string `json:"to"`; GameID int64 `json:"game_id"` }
 	var body req
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil { http.Error(w, "bad request", http.StatusBadRequest); return }
 	from := strings.TrimSpace(body.From)
 	to := strings.TrimSpace(body.To)
-	if from == "" || to == "" { http.Error(w, "from/to empty", http.StatusBadRequest); return }
+	if (from == "" && body.GameID <= 0) || to == "" { http.Error(w, "from/to empty", http.StatusBadRequest); return }
+	if body.GameID > 0 {
+		id, err := s.db.RenameGame(body.GameID, to)
+		if errors.Is(err, query.ErrGameNotFound) { http.Error(w, err.Error(), http.StatusNotFound); return }
+		if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+		writeJSON(w, map[string]any{"status":"ok","game_id":id}); return
+	}
 	if err := s.db.RenameSmart(from, to); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
 	writeJSON(w, map[string]string{"status":"ok"})
 }
 
+// handleGames lists the games with their executables
+func (s *Server) handleGames(w http.ResponseWriter, r *http.Request) {
+	games, err := s.db.GetGames()
+	if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	writeJSON(w, games)
+}
+
+// handleGamesAssign moves an executable to a game, given by id or by name (created if needed)
+func (s *Server) handleGamesAssign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
+	type req struct{ Executable string `json:"executable"`; GameID int64 `json:"game_id"`; Game string `json:"game"` }
+	var body req
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil { http.Error(w, "bad request", http.StatusBadRequest); return }
+	exe := strings.TrimSpace(body.Executable)
+	game := strings.TrimSpace(body.Game)
+	if exe == "" || (body.GameID <= 0 && game == "") { http.Error(w, "missing executable or game", http.StatusBadRequest); return }
+	id := body.GameID
+	if id > 0 {
+		if _, err := s.db.GetGame(id); err != nil { http.Error(w, err.Error(), http.StatusNotFound); return }
+	} else {
+		var err error
+		if id, err = s.db.ResolveGame(game); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	}
+	if err := s.db.AssignExecutable(exe, id); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	writeJSON(w, map[string]any{"status":"ok","game_id":id})
+}
+
+// handleGamesMerge merges game "from" into game "into"
+func (s *Server) handleGamesMerge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
+	type req struct{ From int64 `json:"from"`; Into int64 `json:"into"` }
+	var body req
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.From <= 0 || body.Into <= 0 { http.Error(w, "bad request", http.StatusBadRequest); return }
+	for _, id := range []int64{body.From, body.Into} {
+		if _, err := s.db.GetGame(id); err != nil { http.Error(w, err.Error(), http.StatusNotFound); return }
+	}
+	if err := s.db.MergeGames(body.From, body.Into); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	writeJSON(w, map[string]string{"status":"ok"})
+}
+
+// handleSettings returns the settings (GET) or changes some of them (PUT, a
+// partial object). Keys set by the config file or the environment are locked.
+func (s *Server) handleSettings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		patch := map[string]json.RawMessage{}
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil { http.Error(w, "bad request", http.StatusBadRequest); return }
+		if _, err := s.settings.Update(patch); err != nil { http.Error(w, err.Error(), http.StatusBadRequest); return }
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return
+	}
+	writeJSON(w, map[string]any{
+		"settings": s.settings.Get(),
+		"locked": s.settings.Locked(),
+		"restart_required": settings.RestartKeys,
+	})
+}
+
+// handleBackups lists the backups (GET) or takes a manual one (POST)
+func (s *Server) handleBackups(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if _, err := s.backups.Create(backup.KindManual); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return
+	}
+	list, err := s.backups.List()
+	if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	writeJSON(w, map[string]any{"dir": s.backups.Dir(), "policy": s.backups.Policy(), "backups": list})
+}
+
+// handleBackupsRestore restores a backup in two steps: {"name"} checks it and
+// returns a confirmation token, {"name","confirm":token} replaces the database
+func (s *Server) handleBackupsRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
+	type req struct{ Name string `json:"name"`; Confirm string `json:"confirm"` }
+	var body req
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || strings.TrimSpace(body.Name) == "" { http.Error(w, "bad request", http.StatusBadRequest); return }
+	if body.Confirm == "" {
+		c, err := s.backups.PrepareRestore(body.Name)
+		if errors.Is(err, backup.ErrNotFound) { http.Error(w, err.Error(), http.StatusNotFound); return }
+		if err != nil { http.Error(w, err.Error(), http.StatusBadRequest); return }
+		writeJSON(w, c); return
+	}
+	pre, err := s.backups.Restore(body.Name, body.Confirm)
+	if errors.Is(err, backup.ErrNotConfirmed) { http.Error(w, err.Error(), http.StatusConflict); return }
+	if errors.Is(err, backup.ErrNotFound) { http.Error(w, err.Error(), http.StatusNotFound); return }
+	if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	// The restored lists and settings replace the ones in memory
+	if err := s.lm.RefreshLists(); err != nil { log.Println("RefreshLists:", err) }
+	if err := s.settings.Reload(); err != nil { log.Println("settings.Reload:", err) }
+	// The checkpoints of the running sessions were replaced too
+	if err := s.monitor.ResumeSessions(); err != nil { log.Println(err) }
+	s.events.Publish(events.DataImported, map[string]string{"source": "restore", "backup": body.Name})
+	writeJSON(w, map[string]any{"status": "ok", "restored": body.Name, "previous": pre})
+}
+
+// handleLimits lists the play-time limits (GET), adds one or updates the one
+// with the given id (POST). The game is given by name, none for all games.
+func (s *Server) handleLimits(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		list, err := s.db.GetLimits()
+		if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+		writeJSON(w, list); return
+	}
+	if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
+	body := query.Limit{Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil { http.Error(w, "bad request", http.StatusBadRequest); return }
+	var ok bool
+	if body.GameID, ok = s.gameByName(w, body.Game); !ok { return }
+	l, err := s.db.SaveLimit(body)
+	if errors.Is(err, query.ErrLimitNotFound) { http.Error(w, err.Error(), http.StatusNotFound); return }
+	if err != nil { http.Error(w, err.Error(), http.StatusBadRequest); return }
+	s.limits.Changed()
+	writeJSON(w, l)
+}
+
+func (s *Server) handleLimitsDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
+	type req struct{ ID int64 `json:"id"` }
+	var body req
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ID <= 0 { http.Error(w, "bad request", http.StatusBadRequest); return }
+	if err := s.db.DeleteLimit(body.ID); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	s.limits.Changed()
+	writeJSON(w, map[string]string{"status":"ok"})
+}
+
+// handleLimitsStatus returns the consumption of each limit over its current period
+func (s *Server) handleLimitsStatus(w http.ResponseWriter, r *http.Request) {
+	budgets, err := s.limits.Status(time.Now())
+	if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	writeJSON(w, budgets)
+}
+
+// gameByName returns the id of the game name, 0 for an empty name meaning all
+// games; it answers the request itself and returns false on error
+func (s *Server) gameByName(w http.ResponseWriter, name string) (int64, bool) {
+	if name = strings.TrimSpace(name); name == "" { return 0, true }
+	g, err := s.db.FindGame(name)
+	if errors.Is(err, query.ErrGameNotFound) { http.Error(w, err.Error(), http.StatusBadRequest); return 0, false }
+	if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return 0, false }
+	return g.ID, true
+}
+
+// handleCurfews lists the allowed windows (GET), adds one or updates the one
+// with the given id (POST). The game is given by name, none for all games.
+func (s *Server) handleCurfews(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		list, err := s.db.GetCurfews()
+		if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+		writeJSON(w, list); return
+	}
+	if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
+	body := query.Curfew{Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil { http.Error(w, "bad request", http.StatusBadRequest); return }
+	var ok bool
+	if body.GameID, ok = s.gameByName(w, body.Game); !ok { return }
+	c, err := s.db.SaveCurfew(body)
+	if errors.Is(err, query.ErrCurfewNotFound) { http.Error(w, err.Error(), http.StatusNotFound); return }
+	if err != nil { http.Error(w, err.Error(), http.StatusBadRequest); return }
+	s.curfews.Changed()
+	writeJSON(w, c)
+}
+
+func (s *Server) handleCurfewsDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
+	type req struct{ ID int64 `json:"id"` }
+	var body req
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ID <= 0 { http.Error(w, "bad request", http.StatusBadRequest); return }
+	if err := s.db.DeleteCurfew(body.ID); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	s.curfews.Changed()
+	writeJSON(w, map[string]string{"status":"ok"})
+}
+
+// handleCurfewExceptions lists the days the curfews are lifted (GET) or adds
+// some (POST {"game","start_date","end_date","note"})
+func (s *Server) handleCurfewExceptions(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		list, err := s.db.GetCurfewExceptions()
+		if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+		writeJSON(w, list); return
+	}
+	if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
+	var body query.CurfewException
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil { http.Error(w, "bad request", http.StatusBadRequest); return }
+	var ok bool
+	if body.GameID, ok = s.gameByName(w, body.Game); !ok { return }
+	x, err := s.db.AddCurfewException(body)
+	if err != nil { http.Error(w, err.Error(), http.StatusBadRequest); return }
+	s.curfews.Changed()
+	writeJSON(w, x)
+}
+
+func (s *Server) handleCurfewExceptionsDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
+	type req struct{ ID int64 `json:"id"` }
+	var body req
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ID <= 0 { http.Error(w, "bad request", http.StatusBadRequest); return }
+	if err := s.db.DeleteCurfewException(body.ID); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	s.curfews.Changed()
+	writeJSON(w, map[string]string{"status":"ok"})
+}
+
+// handleCurfewViolations returns the last violations, 50 unless ?limit= is given
+func (s *Server) handleCurfewViolations(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 { http.Error(w, "bad limit", http.StatusBadRequest); return }
+		limit = n
+	}
+	list, err := s.db.GetCurfewViolations(limit)
+	if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	writeJSON(w, list)
+}
+
+// handleWebhooks lists the webhooks (GET), adds one or updates the one with
+// the given id (POST {"url","secret","events","enabled"}). The secret is write
+// only: the webhooks listed tell has_secret, and an empty one keeps the stored one.
+func (s *Server) handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		list, err := s.db.GetWebhooks()
+		if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+		writeJSON(w, map[string]any{"webhooks": list, "events": webhook.Events}); return
+	}
+	if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
+	var body struct {
+		query.Webhook
+		Secret string `json:"secret"`
+	}
+	body.Enabled = true
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil { http.Error(w, "bad request", http.StatusBadRequest); return }
+	body.Webhook.Secret = body.Secret
+	hook, err := s.db.SaveWebhook(body.Webhook, webhook.Events)
+	if errors.Is(err, query.ErrWebhookNotFound) { http.Error(w, err.Error(), http.StatusNotFound); return }
+	if err != nil { http.Error(w, err.Error(), http.StatusBadRequest); return }
+	writeJSON(w, hook)
+}
+
+func (s *Server) handleWebhooksDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
+	type req struct{ ID int64 `json:"id"` }
+	var body req
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ID <= 0 { http.Error(w, "bad request", http.StatusBadRequest); return }
+	if err := s.db.DeleteWebhook(body.ID); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	writeJSON(w, map[string]string{"status":"ok"})
+}
+
+// handleWebhooksTest queues a ping for the webhook {"id"}, its outcome showing
+// in the deliveries
+func (s *Server) handleWebhooksTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
+	type req struct{ ID int64 `json:"id"` }
+	var body req
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ID <= 0 { http.Error(w, "bad request", http.StatusBadRequest); return }
+	id, err := s.webhooks.Ping(body.ID)
+	if errors.Is(err, query.ErrWebhookNotFound) { http.Error(w, err.Error(), http.StatusNotFound); return }
+	if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	writeJSON(w, map[string]any{"status": "queued", "delivery": id})
+}
+
+// handleWebhookDeliveries returns the last deliveries, of the webhook
+// ?webhook_id= if given, 50 unless ?limit= is given
+func (s *Server) handleWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, hookID := 50, int64(0)
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 { http.Error(w, "bad limit", http.StatusBadRequest); return }
+		limit = n
+	}
+	if v := q.Get("webhook_id"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 { http.Error(w, "bad webhook_id", http.StatusBadRequest); return }
+		hookID = n
+	}
+	list, err := s.db.GetDeliveries(hookID, limit)
+	if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	writeJSON(w, list)
+}
+
+// handleNow returns the games running right now with their elapsed time
+func (s *Server) handleNow(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	writeJSON(w, map[string]any{"time": now, "playing": s.monitor.NowPlaying(now)})
+}
+
+// handleEvents streams the events of the bus as Server-Sent Events: the event
+// name is the type of the event and the data its JSON encoding
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok { http.Error(w, "streaming unsupported", http.StatusInternalServerError); return }
+	ch, cancel := s.events.Subscribe(64)
+	defer cancel()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Tell EventSource to retry after 3s, and send the headers right away
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.closing:
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case ev := <-ch:
+			data, err := json.Marshal(ev)
+			if err != nil { log.Println("handleEvents:", err); continue }
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// handleRepairOverlaps merges the overlapping activities of each game (POST),
+// GET only reports what would be merged
+func (s *Server) handleRepairOverlaps(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
+	rep, err := s.db.RepairOverlaps(r.Method == http.MethodPost)
+	if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	writeJSON(w, rep)
+}
+
+// handleSessionsCleanup applies the session thresholds to the existing
+// activities. GET returns the configured thresholds and what a cleanup would
+// do; POST runs it, with the configured thresholds unless the body overrides them.
+func (s *Server) handleSessionsCleanup(w http.ResponseWriter, r *http.Request) {
+	type req struct {
+		MinSeconds      *int `json:"min_seconds"`
+		MergeGapMinutes *int `json:"merge_gap_minutes"`
+		Apply           bool `json:"apply"`
+	}
+	rules := s.db.SessionRules()
+	var body req
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil { http.Error(w, "bad request", http.StatusBadRequest); return }
+		if body.MinSeconds != nil { if *body.MinSeconds < 0 { http.Error(w, "bad min_seconds", http.StatusBadRequest); return }; rules.MinDuration = time.Duration(*body.MinSeconds) * time.Second }
+		if body.MergeGapMinutes != nil { if *body.MergeGapMinutes < 0 { http.Error(w, "bad merge_gap_minutes", http.StatusBadRequest); return }; rules.MergeGap = time.Duration(*body.MergeGapMinutes) * time.Minute }
+	} else if r.Method != http.MethodGet { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
+	rep, err := s.db.CleanupSessions(rules, body.Apply)
+	if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	writeJSON(w, map[string]any{
+		"min_seconds": int(rules.MinDuration / time.Second),
+		"merge_gap_minutes": int(rules.MergeGap / time.Minute),
+		"applied": body.Apply,
+		"report": rep,
+	})
+}
+
+// findGame looks a game up by id, or else by game or executable name
+func (s *Server) findGame(id int64, name string) (query.Game, error) {
+	if id > 0 { return s.db.GetGame(id) }
+	return s.db.FindGame(name)
+}
+
+// resolveGame is findGame creating a game for an unknown name
+func (s *Server) resolveGame(id int64, name string) (int64, error) {
+	if id > 0 {
+		_, err := s.db.GetGame(id)
+		return id, err
+	}
+	return s.db.ResolveGame(name)
+}
+
 func (s *Server) handleFinished(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
-	type req struct{ Name string `json:"name"`; Done *bool `json:"done"` }
+	type req struct{ Name string `json:"name"`; GameID int64 `json:"game_id"`; Done *bool `json:"done"` }
 	var body req
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil { http.Error(w, "bad request", http.StatusBadRequest); return }
 	name := strings.TrimSpace(body.Name)
-	if name == "" { http.Error(w, "name empty", http.StatusBadRequest); return }
+	if name == "" && body.GameID <= 0 { http.Error(w, "name empty", http.StatusBadRequest); return }
+	id, err := s.resolveGame(body.GameID, name)
+	if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	wasFinished, err := s.db.IsFinished(id)
+	if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
 	// If Done is nil, toggle; else set state
 	if body.Done == nil {
-		if finished, err := s.db.IsFinished(name); err == nil {
-			if finished { _ = s.db.DeleteFinished(name) } else { _ = s.db.InsertFinished(name) }
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError); return
-		}
+		if wasFinished { _ = s.db.DeleteFinished(id) } else { _ = s.db.InsertFinished(id) }
 	} else if *body.Done {
-		if err := s.db.InsertFinished(name); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+		if err := s.db.InsertFinished(id); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
 	} else {
-		if err := s.db.DeleteFinished(name); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+		if err := s.db.DeleteFinished(id); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
 	}
+	if !wasFinished { s.publishFinished(id) }
 	writeJSON(w, map[string]string{"status":"ok"})
 }
 
+// publishFinished publishes GameFinished if the game id is now finished
+func (s *Server) publishFinished(id int64) {
+	g, err := s.db.GetGame(id)
+	if err != nil || g.FinishedAt == nil { return }
+	s.events.Publish(events.GameFinished, map[string]any{"game_id": g.ID, "game": g.Name, "finished_at": *g.FinishedAt})
+}
+
 // handleSeries builds a matrix suitable for stacked bar chart
 func (s *Server) handleSeries(w http.ResponseWriter, r *http.Request) {
 	period := r.URL.Query().Get("period")
@@ -216,7 +793,7 @@ func (s *Server) handleSeries(w http.ResponseWriter, r *http.Request) {
 	if start == "" || end == "" {
 		start, end = query.PeriodRange(period, time.Now())
 	}
-	rows, err := s.db.GetSeries(period, start, end, by)
+	rows, err := s.db.GetSeries(period, start, end, by, metricParam(r))
 	if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
 	// Build full labels between start and end (inclusive) with appropriate step
 	var labels []string
@@ -330,28 +907,35 @@ func (s *Server) handleCalendar(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handleSetFirstLaunchDate(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
-	type req struct{ Name string `json:"name"`; Date string `json:"date"` }
+	type req struct{ Name string `json:"name"`; GameID int64 `json:"game_id"`; Date string `json:"date"` }
 	var body req
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil { http.Error(w, "bad request", http.StatusBadRequest); return }
 	name := strings.TrimSpace(body.Name)
 	date := strings.TrimSpace(body.Date)
-	if name == "" || date == "" { http.Error(w, "name/date empty", http.StatusBadRequest); return }
+	if (name == "" && body.GameID <= 0) || date == "" { http.Error(w, "name/date empty", http.StatusBadRequest); return }
 	// Validate date format YYYY-MM-DD
 	if _, err := time.Parse("2006-01-02", date); err != nil { http.Error(w, "bad date", http.StatusBadRequest); return }
-	if err := s.db.UpsertFirstLaunchOverride(name, date); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	id, err := s.resolveGame(body.GameID, name)
+	if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	if err := s.db.UpsertFirstLaunchOverride(id, date); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
 	writeJSON(w, map[string]string{"status":"ok"})
 }
 
 func (s *Server) handleSetFinishedDate(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost { http.Error(w, "method not allowed", http.StatusMethodNotAllowed); return }
-	type req struct{ Name string `json:"name"`; Date string `json:"date"` }
+	type req struct{ Name string `json:"name"`; GameID int64 `json:"game_id"`; Date string `json:"date"` }
 	var body req
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil { http.Error(w, "bad request", http.StatusBadRequest); return }
 	name := strings.TrimSpace(body.Name)
 	date := strings.TrimSpace(body.Date)
-	if name == "" || date == "" { http.Error(w, "name/date empty", http.StatusBadRequest); return }
+	if (name == "" && body.GameID <= 0) || date == "" { http.Error(w, "name/date empty", http.StatusBadRequest); return }
 	if _, err := time.Parse("2006-01-02", date); err != nil { http.Error(w, "bad date", http.StatusBadRequest); return }
-	if err := s.db.UpsertFinishedAt(name, date); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	id, err := s.resolveGame(body.GameID, name)
+	if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	wasFinished, err := s.db.IsFinished(id)
+	if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	if err := s.db.UpsertFinishedAt(id, date); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	if !wasFinished { s.publishFinished(id) }
 	writeJSON(w, map[string]string{"status":"ok"})
 }
 
@@ -383,8 +967,9 @@ func (s *Server) handleDayTimeline(w http.ResponseWriter, r *http.Request) {
 	if _, err := time.Parse("2006-01-02", date); err != nil { http.Error(w, "bad date", http.StatusBadRequest); return }
 	rows, err := s.db.GetIntervalsForDate(date)
 	if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
-	// Determine timezone from query: tz=IANA name (e.g., Europe/Paris). Empty => system local.
+	// Determine timezone from query: tz=IANA name (e.g., Europe/Paris). Empty => configured timezone, or system local.
 	tz := strings.TrimSpace(r.URL.Query().Get("tz"))
+	if tz == "" { tz = s.settings.Get().Timezone }
 	var loc *time.Location
 	if tz == "" {
 		loc = time.Local
@@ -415,81 +1000,11 @@ func (s *Server) handleDayTimeline(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, map[string]any{"date": date, "segments": segs})
 }
 
-// Export / Import structures
-
-type activityRow struct {
-	ProcessName string  `db:"process_name" json:"process_name"`
-	StartTime   string  `db:"start_time" json:"start_time"`
-	EndTime     string  `db:"end_time" json:"end_time"`
-	Duration    float64 `db:"duration" json:"duration"`
-	Date        string  `db:"date" json:"date"`
-	FirstLaunch bool    `db:"first_launch" json:"first_launch"`
-}
-
-type renameRow struct {
-	OriginalName string `db:"original_name" json:"original_name"`
-	DisplayName  string `db:"display_name" json:"display_name"`
-}
-
-type finishedRow struct {
-	Name       string `db:"name" json:"name"`
-	FinishedAt string `db:"finished_at" json:"finished_at"`
-}
-
-type firstLaunchRow struct {
-	Name      string `db:"name" json:"name"`
-	FirstDate string `db:"first_date" json:"first_date"`
-}
-
-type metaInfo struct {
-	SchemaVersion int    `json:"schema_version"`
-	ExportedAt    string `json:"exported_at"`
-	Timezone      string `json:"timezone"`
-}
-
-type exportPayload struct {
-	Mode                 string           `json:"mode,omitempty"`
-	Meta                 metaInfo         `json:"meta"`
-	Activities           []activityRow    `json:"activities"`
-	Whitelist            []string         `json:"whitelist"`
-	Blacklist            []string         `json:"blacklist"`
-	RenameMap            []renameRow      `json:"rename_map"`
-	FinishedGames        []finishedRow    `json:"finished_games"`
-	FirstLaunchOverrides []firstLaunchRow `json:"first_launch_override"`
-}
-
 func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
-	// Read all tables
-	var acts []activityRow
-	if err := s.db.Select(&acts, `SELECT process_name, start_time, end_time, duration, date, first_launch FROM activities ORDER BY start_time`); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError); return
-	}
-	wl, err := s.db.GetAllWhitelisted()
+	payload, err := s.db.Export()
 	if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
-	bl, err := s.db.GetAllBlacklisted()
-	if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
-	var ren []renameRow
-	if err := s.db.Select(&ren, `SELECT original_name, display_name FROM rename_map ORDER BY original_name`); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError); return
-	}
-	var fin []finishedRow
-	if err := s.db.Select(&fin, `SELECT name, COALESCE(finished_at,'') AS finished_at FROM finished_games ORDER BY name`); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError); return
-	}
-	var flo []firstLaunchRow
-	if err := s.db.Select(&flo, `SELECT name, COALESCE(first_date,'') AS first_date FROM first_launch_override ORDER BY name`); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError); return
-	}
- // Build meta
-	ver, _ := s.db.GetDbVersion()
-	now := time.Now()
-	payload := exportPayload{
-		Mode: "",
-		Meta: metaInfo{ SchemaVersion: ver, ExportedAt: now.Format(time.RFC3339), Timezone: now.Format("-0700") },
-		Activities: acts, Whitelist: wl, Blacklist: bl, RenameMap: ren, FinishedGames: fin, FirstLaunchOverrides: flo,
-	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	fname := "steam_tracker_export_" + now.Format("20060102_150405") + ".json"
+	fname := "steam_tracker_export_" + time.Now().Format("20060102_150405") + ".json"
 	w.Header().Set("Content-Disposition", "attachment; filename=\""+fname+"\"")
 	enc := json.NewEncoder(w)
 	enc.SetIndent("", "  ")
@@ -501,83 +1016,31 @@ func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
 	// Limit body to 25MB to avoid excessive memory usage
 	limited := http.MaxBytesReader(w, r.Body, 25<<20)
 	defer limited.Close()
-	var payload exportPayload
+	var payload query.Export
 	if err := json.NewDecoder(limited).Decode(&payload); err != nil { http.Error(w, "bad json", http.StatusBadRequest); return }
-	mode := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("mode")))
-	if mode == "" { mode = strings.ToLower(strings.TrimSpace(payload.Mode)) }
-	if mode != "replace" { mode = "merge" }
-	// Begin transaction
-	tx, err := s.db.Beginx()
+	mode, err := s.db.Import(payload, r.URL.Query().Get("mode"))
 	if err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
-	rollback := func(){ _ = tx.Rollback() }
-	if mode == "replace" {
-		// Clear all data tables (keep database_version)
-		stmts := []string{
-			"DELETE FROM activities",
-			"DELETE FROM whitelist",
-			"DELETE FROM blacklist",
-			"DELETE FROM rename_map",
-			"DELETE FROM finished_games",
-			"DELETE FROM first_launch_override",
-		}
-		for _, q := range stmts {
-			if _, err := tx.Exec(q); err != nil { rollback(); http.Error(w, err.Error(), http.StatusInternalServerError); return }
-		}
-	}
-	// Insert / merge
-	// Activities (validate and normalize)
-	for _, a := range payload.Activities {
-		pname := strings.TrimSpace(a.ProcessName)
-		if pname == "" { continue }
-		// Parse times
-		st, err1 := time.Parse(time.RFC3339, strings.TrimSpace(a.StartTime))
-		et, err2 := time.Parse(time.RFC3339, strings.TrimSpace(a.EndTime))
-		if err1 != nil || err2 != nil { continue }
-		if et.Before(st) { continue }
-		// Normalize to RFC3339 (UTC)
-		stUTC := st.UTC().Format(time.RFC3339)
-		etUTC := et.UTC().Format(time.RFC3339)
-		// Derive date from start UTC
-		dateStr := st.UTC().Format("2006-01-02")
-		if mode == "merge" {
-			var exists bool
-			if err := tx.Get(&exists, `SELECT EXISTS(SELECT 1 FROM activities WHERE process_name=? AND start_time=? AND end_time=?)`, pname, stUTC, etUTC); err != nil { rollback(); http.Error(w, err.Error(), http.StatusInternalServerError); return }
-			if exists { continue }
-		}
-		if _, err := tx.Exec(`INSERT INTO activities (process_name, start_time, end_time, duration, date, first_launch) VALUES (?,?,?,?,?,?)`, pname, stUTC, etUTC, a.Duration, dateStr, a.FirstLaunch); err != nil { rollback(); http.Error(w, err.Error(), http.StatusInternalServerError); return }
-	}
-	// Whitelist / Blacklist
-	for _, name := range payload.Whitelist {
-		n := strings.TrimSpace(name); if n=="" { continue }
-		if _, err := tx.Exec(`INSERT OR IGNORE INTO whitelist (name) VALUES (?)`, n); err != nil { rollback(); http.Error(w, err.Error(), http.StatusInternalServerError); return }
-	}
-	for _, name := range payload.Blacklist {
-		n := strings.TrimSpace(name); if n=="" { continue }
-		if _, err := tx.Exec(`INSERT OR IGNORE INTO blacklist (name) VALUES (?)`, n); err != nil { rollback(); http.Error(w, err.Error(), http.StatusInternalServerError); return }
-	}
-	// Rename map upsert
-	for _, r1 := range payload.RenameMap {
-		orig := strings.TrimSpace(r1.OriginalName)
-		disp := strings.TrimSpace(r1.DisplayName)
-		if orig=="" || disp=="" { continue }
-		if _, err := tx.Exec(`INSERT INTO rename_map (original_name, display_name) VALUES (?, ?) ON CONFLICT(original_name) DO UPDATE SET display_name=excluded.display_name`, orig, disp); err != nil { rollback(); http.Error(w, err.Error(), http.StatusInternalServerError); return }
-	}
-	// Finished upsert with validation
-	for _, f := range payload.FinishedGames {
-		name := strings.TrimSpace(f.Name); if name=="" { continue }
-		if _, err := time.Parse("2006-01-02", strings.TrimSpace(f.FinishedAt)); err != nil { continue }
-		if _, err := tx.Exec(`INSERT INTO finished_games (name, finished_at) VALUES (?, ?) ON CONFLICT(name) DO UPDATE SET finished_at=excluded.finished_at`, name, f.FinishedAt); err != nil { rollback(); http.Error(w, err.Error(), http.StatusInternalServerError); return }
-	}
-	// First launch override upsert with validation
-	for _, fl := range payload.FirstLaunchOverrides {
-		name := strings.TrimSpace(fl.Name); if name=="" { continue }
-		if _, err := time.Parse("2006-01-02", strings.TrimSpace(fl.FirstDate)); err != nil { continue }
-		if _, err := tx.Exec(`INSERT INTO first_launch_override (name, first_date) VALUES (?, ?) ON CONFLICT(name) DO UPDATE SET first_date=excluded.first_date`, name, fl.FirstDate); err != nil { rollback(); http.Error(w, err.Error(), http.StatusInternalServerError); return }
-	}
-	if err := tx.Commit(); err != nil { http.Error(w, err.Error(), http.StatusInternalServerError); return }
+	// Reload the in-memory lists so imported entries apply immediately
+	if err := s.lm.RefreshLists(); err != nil { log.Println("RefreshLists:", err) }
+	s.events.Publish(events.DataImported, map[string]string{"source": "import", "mode": mode})
 	writeJSON(w, map[string]string{"status":"ok","mode":mode})
 }
 
+// metricParam reads ?metric=total|active|focused; ?active=1 is a shortcut for active
+func metricParam(r *http.Request) query.Metric {
+	switch query.Metric(strings.ToLower(strings.TrimSpace(r.URL.Query().Get("metric")))) {
+	case query.MetricActive:
+		return query.MetricActive
+	case query.MetricFocused:
+		return query.MetricFocused
+	}
+	v := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("active")))
+	if v == "1" || v == "true" || v == "yes" {
+		return query.MetricActive
+	}
+	return query.MetricTotal
+}
+
 func writeJSON(w http.ResponseWriter, v any) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	enc := json.NewEncoder(w)
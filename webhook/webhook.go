@@ -0,0 +1,277 @@
+// Package webhook posts the sessions and game events to the webhooks of the
+// database. Each payload is queued in the delivery log first, then sent and
+// retried with a growing delay until it is accepted or every attempt is used.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"main/events"
+	"main/query"
+)
+
+// Events sent to the webhooks
+const (
+	SessionStarted = "session.started"
+	SessionEnded   = "session.ended"
+	GameFinished   = "game.finished"
+	FirstLaunch    = "game.first_launch"
+	// Ping is only sent on demand, to try a webhook out
+	Ping = "ping"
+)
+
+// Events lists the events a webhook can filter on
+var Events = []string{SessionStarted, SessionEnded, GameFinished, FirstLaunch, Ping}
+
+// busEvents maps the events of the bus to the events sent
+var busEvents = map[events.Type]string{
+	events.SessionStarted: SessionStarted,
+	events.SessionEnded:   SessionEnded,
+	events.GameFinished:   GameFinished,
+	events.FirstLaunch:    FirstLaunch,
+}
+
+// Headers of the requests
+const (
+	HeaderEvent    = "X-WGT-Event"
+	HeaderDelivery = "X-WGT-Delivery"
+	// HeaderSignature is "sha256=" followed by the hex HMAC-SHA256 of the body
+	// keyed by the secret of the webhook, absent without secret
+	HeaderSignature = "X-WGT-Signature-256"
+)
+
+// retryDelays are the delays before each new attempt of a failed delivery;
+// a delivery is given up after len(retryDelays)+1 attempts
+var retryDelays = []time.Duration{10 * time.Second, time.Minute, 5 * time.Minute, 30 * time.Minute, 2 * time.Hour}
+
+// The delivery log keeps the finished deliveries of the last keepDays days,
+// at most keepDeliveries of them, pruned every pruneInterval
+const (
+	keepDays       = 30
+	keepDeliveries = 1000
+	pruneInterval  = time.Hour
+)
+
+// ErrDisabled fails the deliveries of a webhook disabled since they were queued
+var ErrDisabled = errors.New("webhook disabled")
+
+// Payload is the JSON body posted to the webhooks
+type Payload struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	Data  any       `json:"data,omitempty"`
+}
+
+// Sender queues and sends the payloads
+type Sender struct {
+	db     *query.Database
+	bus    *events.Bus
+	client *http.Client
+	// wake triggers the delivery of the queued payloads
+	wake chan struct{}
+	// pruned is when the delivery log was last pruned
+	pruned time.Time
+}
+
+// New returns the sender of the events of bus to the webhooks of db
+func New(db *query.Database, bus *events.Bus) *Sender {
+	return &Sender{
+		db:     db,
+		bus:    bus,
+		client: &http.Client{Timeout: 10 * time.Second},
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Run queues the events of the bus and sends the deliveries until ctx is done,
+// the pending ones of a previous run first. The events are queued by Publish
+// itself, so a burst loses none of them.
+func (s *Sender) Run(ctx context.Context) {
+	defer s.bus.Handle(s.handle)()
+	s.deliverLoop(ctx)
+}
+
+// handle queues an event of the bus in the delivery log
+func (s *Sender) handle(ev events.Event) {
+	name, ok := busEvents[ev.Type]
+	if !ok {
+		return
+	}
+	if err := s.enqueue(name, ev.Time, ev.Data); err != nil {
+		log.Println("Webhook:", err)
+	}
+}
+
+// Ping queues a ping for the webhook id, whatever its events, and returns the
+// delivery queued
+func (s *Sender) Ping(id int64) (int64, error) {
+	w, err := s.db.GetWebhook(id)
+	if err != nil {
+		return 0, err
+	}
+	ids, err := s.queue([]query.Webhook{w}, Ping, time.Now(), map[string]string{"message": "ping"})
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+// enqueue queues the event for every webhook wanting it
+func (s *Sender) enqueue(event string, at time.Time, data any) error {
+	hooks, err := s.db.GetWebhooks()
+	if err != nil {
+		return err
+	}
+	var targets []query.Webhook
+	for _, w := range hooks {
+		if w.Wants(event) {
+			targets = append(targets, w)
+		}
+	}
+	_, err = s.queue(targets, event, at, data)
+	return err
+}
+
+// queue inserts the payload of the event for each webhook and wakes the delivery
+func (s *Sender) queue(hooks []query.Webhook, event string, at time.Time, data any) ([]int64, error) {
+	if len(hooks) == 0 {
+		return nil, nil
+	}
+	body, err := json.Marshal(Payload{Event: event, Time: at, Data: data})
+	if err != nil {
+		return nil, fmt.Errorf("webhook: %w", err)
+	}
+	created := time.Now().UTC().Format(time.RFC3339)
+	ids := make([]int64, 0, len(hooks))
+	for _, w := range hooks {
+		id, err := s.db.InsertDelivery(query.Delivery{WebhookID: w.ID, Event: event, Payload: string(body), CreatedAt: created})
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return ids, nil
+}
+
+// deliverLoop sends the deliveries when they are due
+func (s *Sender) deliverLoop(ctx context.Context) {
+	for {
+		var timer <-chan time.Time
+		if next := s.deliverDue(ctx); !next.IsZero() {
+			timer = time.After(time.Until(next))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-timer:
+		}
+	}
+}
+
+// deliverDue sends the deliveries due now and returns when the next one is
+// due, zero if none
+func (s *Sender) deliverDue(ctx context.Context) time.Time {
+	if time.Since(s.pruned) >= pruneInterval {
+		s.pruned = time.Now()
+		if _, err := s.db.PruneDeliveries(time.Now().AddDate(0, 0, -keepDays), keepDeliveries); err != nil {
+			log.Println("Webhook:", err)
+		}
+	}
+	due, err := s.db.GetDueDeliveries(time.Now())
+	if err != nil {
+		log.Println("Webhook:", err)
+		return time.Now().Add(time.Minute)
+	}
+	for _, d := range due {
+		if ctx.Err() != nil {
+			return time.Time{}
+		}
+		s.attempt(ctx, d)
+	}
+	next, err := s.db.NextDeliveryAttempt()
+	if err != nil {
+		log.Println("Webhook:", err)
+		return time.Now().Add(time.Minute)
+	}
+	return next
+}
+
+// attempt sends d once and records the outcome
+func (s *Sender) attempt(ctx context.Context, d query.Delivery) {
+	d.Attempts++
+	d.StatusCode, d.Error = 0, ""
+	// The webhook may have been disabled while the delivery was pending
+	w, err := s.db.GetWebhook(d.WebhookID)
+	if err == nil && !w.Enabled {
+		err = ErrDisabled
+	}
+	if err == nil {
+		d.StatusCode, err = s.post(ctx, w, d)
+	}
+	var next time.Time
+	switch {
+	case err == nil:
+		d.State = query.DeliveryDelivered
+		d.DeliveredAt = time.Now().UTC().Format(time.RFC3339)
+	case errors.Is(err, query.ErrWebhookNotFound) || errors.Is(err, ErrDisabled) || d.Attempts > len(retryDelays):
+		d.State, d.Error = query.DeliveryFailed, err.Error()
+		log.Printf("Webhook: livraison %d abandonnée après %d tentative(s): %v\n", d.ID, d.Attempts, err)
+	default:
+		d.State, d.Error = query.DeliveryPending, err.Error()
+		next = time.Now().Add(retryDelays[d.Attempts-1])
+	}
+	if err := s.db.RecordAttempt(d, next); err != nil {
+		log.Println("Webhook:", err)
+	}
+}
+
+// post sends the payload of d to w and returns the status code, an error
+// unless it is a 2xx
+func (s *Sender) post(ctx context.Context, w query.Webhook, d query.Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewBufferString(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "SteamStracker-Webhook")
+	req.Header.Set(HeaderEvent, d.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(d.ID, 10))
+	if w.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(w.Secret, []byte(d.Payload)))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("HTTP %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the signature header of body for secret, for the receivers to
+// check with a constant time comparison
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,218 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"main/events"
+	"main/query"
+)
+
+type request struct {
+	event, delivery, signature string
+	body                       []byte
+	at                         time.Time
+}
+
+// receiver records the requests and answers the first `fail` ones with a 500
+type receiver struct {
+	mu       sync.Mutex
+	fail     int
+	requests []request
+	got      chan struct{}
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	r.requests = append(r.requests, request{req.Header.Get(HeaderEvent), req.Header.Get(HeaderDelivery), req.Header.Get(HeaderSignature), body, time.Now()})
+	failed := len(r.requests) <= r.fail
+	r.mu.Unlock()
+	if failed {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	r.got <- struct{}{}
+}
+
+func (r *receiver) wait(t *testing.T, n int) []request {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.got:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("got %d requests, want %d", i, n)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]request(nil), r.requests...)
+}
+
+func newSender(t *testing.T) (*Sender, *query.Database, *events.Bus) {
+	t.Helper()
+	db, err := query.OpenDatabase(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	bus := events.NewBus()
+	return New(db, bus), db, bus
+}
+
+func TestSenderSignsAndRetries(t *testing.T) {
+	saved := retryDelays
+	retryDelays = []time.Duration{2 * time.Second, 2 * time.Second}
+	defer func() { retryDelays = saved }()
+
+	recv := &receiver{fail: 1, got: make(chan struct{}, 8)}
+	srv := httptest.NewServer(recv)
+	defer srv.Close()
+
+	s, db, bus := newSender(t)
+	hook, err := db.SaveWebhook(query.Webhook{URL: srv.URL + "/hook", Secret: "s3cret", Events: query.EventList{SessionEnded}, Enabled: true}, Events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+	// Wait for the handler
+	time.Sleep(100 * time.Millisecond)
+	bus.Publish(events.SessionStarted, map[string]string{"game": "Game"})
+	bus.Publish(events.SessionEnded, map[string]string{"game": "Game"})
+
+	reqs := recv.wait(t, 2)
+	for _, r := range reqs {
+		if r.event != SessionEnded {
+			t.Errorf("event %q sent, the webhook only wants %q", r.event, SessionEnded)
+		}
+		if want := Sign("s3cret", r.body); r.signature != want {
+			t.Errorf("signature %q, want %q", r.signature, want)
+		}
+	}
+	if reqs[0].delivery != reqs[1].delivery || string(reqs[0].body) != string(reqs[1].body) {
+		t.Errorf("the retry is not the same delivery: %q and %q", reqs[0].delivery, reqs[1].delivery)
+	}
+	// next_attempt_at is stored to the second
+	if gap := reqs[1].at.Sub(reqs[0].at); gap < time.Second {
+		t.Errorf("retried after %v, want about %v", gap, retryDelays[0])
+	}
+
+	var log []query.Delivery
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if log, err = db.GetDeliveries(hook.ID, 10); err != nil {
+			t.Fatal(err)
+		}
+		if len(log) == 1 && log[0].State == query.DeliveryDelivered {
+			break
+		}
+	}
+	if len(log) != 1 {
+		t.Fatalf("%d deliveries logged, want 1", len(log))
+	}
+	d := log[0]
+	if d.State != query.DeliveryDelivered || d.Attempts != 2 || d.StatusCode != http.StatusOK || d.Error != "" || d.DeliveredAt == "" {
+		t.Errorf("delivery logged as %+v", d)
+	}
+	if strconv.FormatInt(d.ID, 10) != reqs[1].delivery {
+		t.Errorf("delivery %d logged, %s sent", d.ID, reqs[1].delivery)
+	}
+}
+
+func TestSenderSkipsDisabledWebhook(t *testing.T) {
+	recv := &receiver{got: make(chan struct{}, 8)}
+	srv := httptest.NewServer(recv)
+	defer srv.Close()
+
+	s, db, _ := newSender(t)
+	hook, err := db.SaveWebhook(query.Webhook{URL: srv.URL, Enabled: true}, Events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := s.Ping(hook.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook.Enabled = false
+	if _, err := db.SaveWebhook(hook, Events); err != nil {
+		t.Fatal(err)
+	}
+	s.deliverDue(context.Background())
+
+	if n := len(recv.wait(t, 0)); n != 0 {
+		t.Errorf("%d requests sent to a disabled webhook", n)
+	}
+	log, err := db.GetDeliveries(hook.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != 1 || log[0].ID != id || log[0].State != query.DeliveryFailed || log[0].Error != ErrDisabled.Error() {
+		t.Errorf("deliveries logged as %+v", log)
+	}
+}
+
+func TestPruneDeliveries(t *testing.T) {
+	_, db, _ := newSender(t)
+	old := time.Now().AddDate(0, 0, -keepDays-1).UTC().Format(time.RFC3339)
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, d := range []struct {
+		created, state string
+	}{{old, query.DeliveryDelivered}, {old, query.DeliveryFailed}, {old, query.DeliveryPending}, {now, query.DeliveryDelivered}, {now, query.DeliveryDelivered}} {
+		id, err := db.InsertDelivery(query.Delivery{WebhookID: 1, Event: Ping, Payload: "{}", CreatedAt: d.created})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.RecordAttempt(query.Delivery{ID: id, State: d.state, Attempts: 1}, time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The two old finished ones, then the oldest recent one past the last one
+	n, err := db.PruneDeliveries(time.Now().AddDate(0, 0, -keepDays), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("%d deliveries pruned, want 3", n)
+	}
+	log, err := db.GetDeliveries(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != 2 || log[0].ID != 5 || log[1].State != query.DeliveryPending {
+		t.Errorf("deliveries left %+v, want the last one and the pending one", log)
+	}
+}
+
+func TestSenderQueuesBurst(t *testing.T) {
+	s, db, bus := newSender(t)
+	// Nothing listens on the port, the deliveries stay pending
+	hook, err := db.SaveWebhook(query.Webhook{URL: "http://127.0.0.1:1/hook", Events: query.EventList{SessionStarted}, Enabled: true}, Events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { s.Run(ctx); close(done) }()
+	time.Sleep(100 * time.Millisecond)
+	// More than any subscription buffer
+	const n = 200
+	for i := 0; i < n; i++ {
+		bus.Publish(events.SessionStarted, map[string]int{"i": i})
+	}
+	cancel()
+	<-done
+
+	log, err := db.GetDeliveries(hook.ID, 2*n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != n {
+		t.Errorf("%d deliveries queued, want %d", len(log), n)
+	}
+}